@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/byteherder/reflector/internal/server"
+)
+
+// shutdownTimeout bounds how long reflector waits for in-flight requests
+// and the span flush when it is asked to stop.
+const shutdownTimeout = 10 * time.Second
+
+func main() {
+	defaultPort := 8080
+	if env := os.Getenv("PORT"); env != "" {
+		if p, err := strconv.Atoi(env); err == nil {
+			defaultPort = p
+		}
+	}
+
+	port := flag.Int("port", defaultPort, "TCP port to bind (env PORT)")
+	bodyBytes := flag.Int("body-bytes", 4096, "max number of request body bytes to capture")
+	historySize := flag.Int("history-size", 100, "number of captures kept in memory (0 disables history)")
+	shareTTL := flag.Duration("share-ttl", 7*24*time.Hour, "how long shared permalinks stay valid (0 disables sharing)")
+	tcpPort := flag.Int("tcp-port", 0, "optional raw TCP echo port (0 disables)")
+	udpPort := flag.Int("udp-port", 0, "optional UDP echo port (0 disables)")
+	proxyProtoFrom := flag.String("proxy-protocol-from", "", "comma-separated CIDRs of load balancers whose PROXY protocol headers the TCP and UDP echo listeners decode")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file; serves HTTPS when set together with --tls-key")
+	tlsKey := flag.String("tls-key", "", "TLS private key file")
+	dnsPort := flag.Int("dns-port", 0, "optional DNS echo port, served on UDP and TCP (0 disables)")
+	logFormat := flag.String("log-format", "logfmt", "log output format: logfmt or json")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	logFields := flag.String("log-fields", "", "comma-separated request headers to include in access logs")
+	requestIDHeader := flag.String("request-id-header", "X-Request-Id", "header used to accept and echo request IDs")
+	redactConfig := flag.String("redact-config", "", "JSON redaction policy file; replaces the built-in policy that hides auth headers")
+	authTokenFile := flag.String("auth-token-file", "", "file of bearer tokens (one per line) accepted on history and metrics endpoints")
+	authHtpasswd := flag.String("auth-htpasswd", "", "htpasswd file with bcrypt hashes for basic auth on history and metrics endpoints")
+	authHeader := flag.String("auth-header", "", "header carrying the user from an authenticating proxy, e.g. X-Forwarded-User")
+	authTrustedProxies := flag.String("auth-trusted-proxies", strings.Join(server.DefaultTrustedProxies, ","), "comma-separated CIDRs allowed to set --auth-header")
+	rateLimit := flag.Float64("rate-limit", 0, "sustained requests per second allowed per client IP (0 disables)")
+	rateBurst := flag.Int("rate-burst", 20, "requests a client may burst above --rate-limit")
+	ratePerPath := flag.Bool("rate-limit-per-path", false, "keep separate rate limit buckets for each endpoint a client uses")
+	maxConcurrent := flag.Int("max-concurrent", 0, "maximum requests served at once; excess gets 503 (0 disables)")
+	rateExempt := flag.String("rate-exempt", "", "comma-separated CIDRs exempt from rate and concurrency limits")
+	rateTrustedProxies := flag.String("rate-trusted-proxies", "", "comma-separated CIDRs of proxies whose X-Forwarded-For identifies the client for rate limiting")
+	acceptCH := flag.String("accept-ch", "", `comma-separated client hints to request with Accept-CH; "ua" expands to every User-Agent hint`)
+	criticalCH := flag.String("critical-ch", "", "comma-separated client hints to also send as Critical-CH")
+	geoipDB := flag.String("geoip-db", "", "MaxMind-format City or Country database (.mmdb) for client locations")
+	asnDB := flag.String("asn-db", "", "MaxMind-format ASN database (.mmdb) for client networks")
+	ipRangeFiles := flag.String("ip-ranges", "", "comma-separated JSON files of named IP ranges (cloud, CDN, Tor) to tag addresses with")
+	reverseDNS := flag.Bool("reverse-dns", false, "look up forward-confirmed PTR names for the peer address")
+	reverseDNSTimeout := flag.Duration("reverse-dns-timeout", 500*time.Millisecond, "time budget for reverse DNS lookups per request")
+	reverseDNSProxies := flag.String("reverse-dns-trusted-proxies", "", "comma-separated CIDRs of proxies whose X-Forwarded-For client is looked up instead of the peer")
+	templateDir := flag.String("template-dir", "", "directory of html/template files (and an assets/ subdirectory) overriding the built-in pages")
+	dev := flag.Bool("dev", false, "reload --template-dir when a file in it changes (checked at most once a second)")
+	replayTargets := flag.String("replay-target", "", "comma-separated base URLs captured requests may be replayed to (empty disables replay)")
+	replayTimeout := flag.Duration("replay-timeout", 10*time.Second, "time limit for a replayed request")
+	upstream := flag.String("upstream", "", "base URL to forward every request to, capturing the request and the upstream response (tee mode)")
+	upstreamRewriteHost := flag.Bool("upstream-rewrite-host", false, "send the upstream's host as Host instead of the client's in tee mode")
+	adminAddr := flag.String("admin-addr", "", "address of a separate listener for /history, /metrics and the other admin endpoints (required with --upstream)")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/HTTP collector URL to export server spans to, e.g. http://localhost:4318")
+	flag.Parse()
+
+	logger, err := newLogger(*logFormat, *logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Route the standard logger (used below and by net/http) through slog too.
+	slog.SetDefault(logger)
+
+	redaction := server.DefaultRedactionPolicy()
+	if *redactConfig != "" {
+		data, err := os.ReadFile(*redactConfig)
+		if err != nil {
+			log.Fatalf("read redaction policy: %v", err)
+		}
+		if redaction, err = server.ParseRedactionPolicy(data); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	auth, err := loadAuth(*authTokenFile, *authHtpasswd, *authHeader, *authTrustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	exempt, err := server.ParseCIDRs(strings.Split(*rateExempt, ","))
+	if err != nil {
+		log.Fatalf("invalid --rate-exempt: %v", err)
+	}
+	rateProxies, err := server.ParseCIDRs(strings.Split(*rateTrustedProxies, ","))
+	if err != nil {
+		log.Fatalf("invalid --rate-trusted-proxies: %v", err)
+	}
+
+	logHeaders, err := server.ParseLogFields(strings.Split(*logFields, ","))
+	if err != nil {
+		log.Fatalf("invalid --log-fields: %v", err)
+	}
+
+	rdnsProxies, err := server.ParseCIDRs(strings.Split(*reverseDNSProxies, ","))
+	if err != nil {
+		log.Fatalf("invalid --reverse-dns-trusted-proxies: %v", err)
+	}
+
+	proxySources, err := server.ParseCIDRs(strings.Split(*proxyProtoFrom, ","))
+	if err != nil {
+		log.Fatalf("invalid --proxy-protocol-from: %v", err)
+	}
+
+	geoip, err := server.OpenGeoIP(*geoipDB, *asnDB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer geoip.Close()
+
+	ipRanges, err := server.LoadIPRanges(strings.Split(*ipRangeFiles, ","))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	targets, err := server.ParseReplayTargets(strings.Split(*replayTargets, ","))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	upstreamURL, err := server.ParseUpstream(*upstream)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if upstreamURL != nil {
+		// Tee mode forwards every path, so captures can only be read through
+		// the admin listener.
+		if *adminAddr == "" {
+			log.Fatal("--upstream requires --admin-addr")
+		}
+		if *historySize <= 0 {
+			log.Fatal("--upstream requires --history-size above 0")
+		}
+	}
+
+	var templates *server.Templates
+	if *templateDir != "" {
+		if templates, err = server.LoadTemplates(*templateDir, *dev); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	opts := []server.Option{
+		server.WithHistorySize(*historySize),
+		server.WithShareTTL(*shareTTL),
+		server.WithRedactionPolicy(redaction),
+		server.WithAuth(auth),
+		server.WithGeoIP(geoip),
+		server.WithIPRanges(ipRanges),
+		server.WithTemplates(templates),
+		server.WithReplay(server.ReplayConfig{Targets: targets, Timeout: *replayTimeout}),
+		server.WithProxy(server.ProxyConfig{Upstream: upstreamURL, RewriteHost: *upstreamRewriteHost}),
+		server.WithClientHints(hintList(*acceptCH), hintList(*criticalCH)),
+		server.WithRateLimit(server.RateLimitConfig{
+			Rate:           *rateLimit,
+			Burst:          *rateBurst,
+			PerPath:        *ratePerPath,
+			MaxConcurrent:  *maxConcurrent,
+			Exempt:         exempt,
+			TrustedProxies: rateProxies,
+		}),
+		server.WithLogger(logger),
+		server.WithLogFields(logHeaders),
+		server.WithOTLPEndpoint(*otlpEndpoint),
+		server.WithRequestIDHeader(*requestIDHeader),
+		server.WithProxyProtocol(proxySources),
+	}
+	if *reverseDNS {
+		opts = append(opts, server.WithReverseDNS(nil, *reverseDNSTimeout, rdnsProxies))
+	}
+	srv := server.New(*bodyBytes, opts...)
+
+	if *tcpPort > 0 {
+		ln, err := net.Listen("tcp", ":"+strconv.Itoa(*tcpPort))
+		if err != nil {
+			log.Fatalf("listen tcp: %v", err)
+		}
+		log.Printf("tcp echo listening on %s", ln.Addr())
+		go func() {
+			if err := srv.ServeTCP(ln); err != nil {
+				log.Fatalf("serve tcp: %v", err)
+			}
+		}()
+	}
+	if *udpPort > 0 {
+		pc, err := net.ListenPacket("udp", ":"+strconv.Itoa(*udpPort))
+		if err != nil {
+			log.Fatalf("listen udp: %v", err)
+		}
+		log.Printf("udp echo listening on %s", pc.LocalAddr())
+		go func() {
+			if err := srv.ServeUDP(pc); err != nil {
+				log.Fatalf("serve udp: %v", err)
+			}
+		}()
+	}
+
+	if *dnsPort > 0 {
+		addr := ":" + strconv.Itoa(*dnsPort)
+		pc, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			log.Fatalf("listen dns udp: %v", err)
+		}
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatalf("listen dns tcp: %v", err)
+		}
+		log.Printf("dns echo listening on %s (udp+tcp)", addr)
+		go func() {
+			if err := srv.ServeDNS(pc); err != nil {
+				log.Fatalf("serve dns udp: %v", err)
+			}
+		}()
+		go func() {
+			if err := srv.ServeDNSTCP(ln); err != nil {
+				log.Fatalf("serve dns tcp: %v", err)
+			}
+		}()
+	}
+
+	httpServer := &http.Server{
+		Addr:              ":" + strconv.Itoa(*port),
+		Handler:           srv.Handler(),
+		ConnContext:       srv.ConnContext,
+		ConnState:         srv.ConnState,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	ln, err := net.Listen("tcp", httpServer.Addr)
+	if err != nil {
+		log.Fatalf("listen: %v", err)
+	}
+	ln = srv.Listener(ln)
+	if *tlsCert != "" || *tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalf("load tls certificate: %v", err)
+		}
+		ln = srv.TLSListener(ln, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"h2", "http/1.1"},
+		})
+		log.Printf("reflector listening on %s (TLS)", httpServer.Addr)
+	} else {
+		log.Printf("reflector listening on %s", httpServer.Addr)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	serveErr := make(chan error, 2)
+	go func() { serveErr <- httpServer.Serve(ln) }()
+
+	var adminServer *http.Server
+	if *adminAddr != "" {
+		adminServer = &http.Server{
+			Addr:              *adminAddr,
+			Handler:           srv.AdminHandler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		adminLn, err := net.Listen("tcp", adminServer.Addr)
+		if err != nil {
+			log.Fatalf("listen admin: %v", err)
+		}
+		log.Printf("admin endpoints listening on %s", adminLn.Addr())
+		go func() { serveErr <- adminServer.Serve(adminLn) }()
+	}
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	// Let in-flight requests finish so their spans are queued, then flush.
+	log.Printf("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("http shutdown: %v", err)
+	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("admin shutdown: %v", err)
+		}
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("flush otlp spans: %v", err)
+	}
+}
+
+func newLogger(format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid --log-level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	case "logfmt", "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid --log-format %q (want logfmt or json)", format)
+	}
+}
+
+func loadAuth(tokenFile, htpasswd, header, trustedProxies string) (server.AuthConfig, error) {
+	cfg := server.AuthConfig{TrustedHeader: header}
+	if tokenFile != "" {
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return cfg, fmt.Errorf("read --auth-token-file: %w", err)
+		}
+		if cfg.Tokens = server.ParseTokens(data); len(cfg.Tokens) == 0 {
+			return cfg, fmt.Errorf("--auth-token-file %s contains no tokens", tokenFile)
+		}
+	}
+	if htpasswd != "" {
+		data, err := os.ReadFile(htpasswd)
+		if err != nil {
+			return cfg, fmt.Errorf("read --auth-htpasswd: %w", err)
+		}
+		if cfg.Users, err = server.ParseHtpasswd(data); err != nil {
+			return cfg, err
+		}
+	}
+	if header != "" {
+		nets, err := server.ParseCIDRs(strings.Split(trustedProxies, ","))
+		if err != nil {
+			return cfg, fmt.Errorf("invalid --auth-trusted-proxies: %w", err)
+		}
+		cfg.TrustedProxies = nets
+	}
+	return cfg, nil
+}
+
+func hintList(value string) []string {
+	var hints []string
+	for _, h := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(h), "ua") {
+			hints = append(hints, server.AllUAHints()...)
+			continue
+		}
+		hints = append(hints, h)
+	}
+	return hints
+}
@@ -1,3 +1,15 @@
 module github.com/byteherder/reflector
 
 go 1.21
+
+require golang.org/x/net v0.25.0
+
+require (
+	github.com/oschwald/maxminddb-golang v1.12.0
+	golang.org/x/crypto v0.23.0
+)
+
+require (
+	golang.org/x/sys v0.20.0 // indirect
+	golang.org/x/text v0.15.0 // indirect
+)
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Address sources, in the order they are listed on the page.
+const (
+	addrSourcePeer      = "peer"
+	addrSourceForwarded = "forwarded"
+	addrSourceXFF       = "x-forwarded-for"
+	addrSourceRealIP    = "x-real-ip"
+)
+
+// addressDetails describes one address that took part in delivering the
+// request: the TCP peer or a hop claimed by a forwarding header.
+type addressDetails struct {
+	IP     string `json:"ip"`
+	Source string `json:"source"`
+	// Hop is the position within a forwarding header, 0 being the original
+	// client as claimed by the first proxy.
+	Hop      int          `json:"hop"`
+	Client   bool         `json:"client,omitempty"`
+	Invalid  bool         `json:"invalid,omitempty"`
+	Classes  []string     `json:"classes,omitempty"`
+	Ranges   []rangeMatch `json:"ranges,omitempty"`
+	Location *geoLocation `json:"location,omitempty"`
+	Network  *geoNetwork  `json:"network,omitempty"`
+
+	ReverseDNS *reverseDNSResult `json:"reverse_dns,omitempty"`
+}
+
+// addressesFromRequest lists the peer address followed by every hop found in
+// Forwarded, X-Forwarded-For and X-Real-Ip, marking the one clientIP chose.
+func addressesFromRequest(r *http.Request) []addressDetails {
+	client := clientIP(r)
+	out := []addressDetails{newAddress(addrHost(r.RemoteAddr), addrSourcePeer, 0)}
+	for i, ip := range forwardedFor(r.Header) {
+		out = append(out, newAddress(ip, addrSourceForwarded, i))
+	}
+	hop := 0
+	for _, line := range r.Header.Values("X-Forwarded-For") {
+		for _, ip := range strings.Split(line, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				out = append(out, newAddress(ip, addrSourceXFF, hop))
+				hop++
+			}
+		}
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-Ip")); ip != "" {
+		out = append(out, newAddress(ip, addrSourceRealIP, 0))
+	}
+
+	for i := range out {
+		if out[i].IP == client {
+			out[i].Client = true
+			break
+		}
+	}
+	return out
+}
+
+func newAddress(ip, source string, hop int) addressDetails {
+	return addressDetails{IP: ip, Source: source, Hop: hop, Invalid: net.ParseIP(ip) == nil}
+}
+
+// forwardedFor extracts the for= parameters of RFC 7239 Forwarded headers,
+// dropping quotes, brackets and ports.
+func forwardedFor(h http.Header) []string {
+	var out []string
+	for _, line := range h.Values("Forwarded") {
+		for _, element := range strings.Split(line, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+				value = strings.Trim(value, `"`)
+				if strings.HasPrefix(value, "[") {
+					if end := strings.Index(value, "]"); end > 0 {
+						value = value[1:end]
+					}
+				} else if host, _, err := net.SplitHostPort(value); err == nil {
+					value = host
+				}
+				out = append(out, value)
+			}
+		}
+	}
+	return out
+}
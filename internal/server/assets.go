@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// assetFiles holds the stylesheets and scripts used by the HTML pages so
+// they work without access to any third-party CDN.
+//
+//go:embed assets
+var assetFiles embed.FS
+
+var embeddedAssets = mustSub(assetFiles, "assets")
+
+const assetPrefix = "/assets/"
+
+// assetSet is the embedded assets, optionally overlaid by the assets
+// directory of a custom template directory. version is a digest of every
+// file; it is part of each asset URL, so the files can be cached forever and
+// any change busts the cache.
+type assetSet struct {
+	fsys    fs.FS
+	version string
+}
+
+func newAssetSet(overlay fs.FS) *assetSet {
+	a := &assetSet{fsys: embeddedAssets, version: hashAssets(embeddedAssets, overlay)}
+	if overlay != nil {
+		a.fsys = overlayFS{upper: overlay, lower: embeddedAssets}
+	}
+	return a
+}
+
+// path returns the versioned URL of an asset.
+func (a *assetSet) path(name string) string {
+	return assetPrefix + a.version + "/" + name
+}
+
+// serve handles /assets/{version}/{name}. Requests for another version get a
+// 404 rather than stale or mismatched content.
+func (a *assetSet) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	version, name, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, assetPrefix), "/")
+	if !ok || version != a.version || !fs.ValidPath(name) || name == "." {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := fs.ReadFile(a.fsys, name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", `"`+a.version+`"`)
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
+
+func (s *Server) assetsHandler(w http.ResponseWriter, r *http.Request) {
+	// A broken template directory in dev mode still leaves the last good
+	// asset set to serve.
+	set, _ := s.templates.current()
+	set.assets.serve(w, r)
+}
+
+// overlayFS serves files from upper, falling back to lower.
+type overlayFS struct {
+	upper, lower fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.lower.Open(name)
+	}
+	return f, err
+}
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+func hashAssets(filesystems ...fs.FS) string {
+	h := sha256.New()
+	for _, fsys := range filesystems {
+		if fsys == nil {
+			continue
+		}
+		var names []string
+		_ = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				names = append(names, path)
+			}
+			return nil
+		})
+		sort.Strings(names)
+		for _, name := range names {
+			data, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				continue
+			}
+			h.Write([]byte(name))
+			h.Write([]byte{0})
+			h.Write(data)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// setPageSecurity sends the Content-Security-Policy for the HTML pages. Only
+// same-origin stylesheets, scripts and connections are allowed; every page
+// keeps its CSS and JavaScript in embedded assets so no inline code needs an
+// exemption.
+func setPageSecurity(w http.ResponseWriter, r *http.Request) {
+	connect := "'self'"
+	if r.Host != "" && !strings.ContainsAny(r.Host, "; ,'\"") {
+		// Older browsers do not treat ws: and wss: as matching 'self'.
+		connect += " ws://" + r.Host + " wss://" + r.Host
+	}
+	w.Header().Set("Content-Security-Policy", strings.Join([]string{
+		"default-src 'none'",
+		"style-src 'self'",
+		"script-src 'self'",
+		"connect-src " + connect,
+		"img-src 'self' data:",
+		"base-uri 'none'",
+		"form-action 'self'",
+		"frame-ancestors 'none'",
+	}, "; "))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+}
@@ -0,0 +1,194 @@
+(function () {
+	"use strict";
+	const hasClientData = document.body.dataset.hasClientData === "true";
+	const statusEl = document.getElementById("status-message");
+	const endpoint = "/collect";
+
+	function updateStatus(message, variant) {
+		if (!statusEl) {
+			return;
+		}
+		statusEl.textContent = message;
+		statusEl.className = "alert alert-" + variant;
+	}
+
+	if (!window.fetch) {
+		updateStatus("Browser fetch API is unavailable in this environment.", "warning");
+		return;
+	}
+
+	if (hasClientData) {
+		return;
+	}
+
+	function storageKeys(storage) {
+		if (!storage) {
+			return [];
+		}
+		const keys = [];
+		try {
+			for (let i = 0; i < storage.length; i += 1) {
+				const key = storage.key(i);
+				if (key !== null) {
+					keys.push(key);
+				}
+			}
+		} catch (err) {
+			return ["unavailable"];
+		}
+		return keys;
+	}
+
+	function boolOrNull(value) {
+		return typeof value === "boolean" ? value : null;
+	}
+
+	function gatherClientData() {
+		const nav = typeof navigator === "undefined" ? {} : navigator;
+		const scr = typeof window === "undefined" || !window.screen ? {} : window.screen;
+		const connection = nav.connection || nav.mozConnection || nav.webkitConnection;
+		const colorScheme = typeof window !== "undefined" && window.matchMedia
+			? (window.matchMedia("(prefers-color-scheme: dark)").matches
+				? "dark"
+				: (window.matchMedia("(prefers-color-scheme: light)").matches ? "light" : "no-preference"))
+			: null;
+		const touchPoints = typeof nav.maxTouchPoints === "number" ? nav.maxTouchPoints : null;
+		let timezone = null;
+		try {
+			if (typeof Intl !== "undefined" && Intl.DateTimeFormat) {
+				timezone = Intl.DateTimeFormat().resolvedOptions().timeZone || null;
+			}
+		} catch (_) {
+			timezone = null;
+		}
+
+		const connectionInfo = connection
+			? {
+				type: connection.type || null,
+				effectiveType: connection.effectiveType || null,
+				downlink: connection.downlink || null,
+				rtt: connection.rtt || null,
+				saveData: boolOrNull(connection.saveData),
+			}
+			: null;
+
+		const performanceInfo = typeof performance !== "undefined" && performance.memory
+			? {
+				jsHeapSizeLimit: performance.memory.jsHeapSizeLimit,
+				totalJSHeapSize: performance.memory.totalJSHeapSize,
+				usedJSHeapSize: performance.memory.usedJSHeapSize,
+			}
+			: null;
+
+		return {
+			timestamp: new Date().toISOString(),
+			location: window.location.href,
+			referrer: document.referrer || null,
+			userAgent: nav.userAgent || null,
+			language: nav.language || null,
+			languages: Array.isArray(nav.languages) ? nav.languages : [],
+			platform: nav.platform || null,
+			deviceMemory: typeof nav.deviceMemory === "number" ? nav.deviceMemory : null,
+			hardwareConcurrency: typeof nav.hardwareConcurrency === "number" ? nav.hardwareConcurrency : null,
+			doNotTrack: nav.doNotTrack || window.doNotTrack || null,
+			cookieEnabled: boolOrNull(nav.cookieEnabled),
+			onLine: boolOrNull(nav.onLine),
+			timezone: timezone,
+			timezoneOffsetMinutes: new Date().getTimezoneOffset(),
+			screen: {
+				width: scr.width || null,
+				height: scr.height || null,
+				availWidth: scr.availWidth || null,
+				availHeight: scr.availHeight || null,
+				colorDepth: scr.colorDepth || null,
+				pixelDepth: scr.pixelDepth || null,
+			},
+			viewport: {
+				width: typeof window.innerWidth === "number" ? window.innerWidth : null,
+				height: typeof window.innerHeight === "number" ? window.innerHeight : null,
+			},
+			colorScheme: colorScheme,
+			historyLength: window.history ? window.history.length : null,
+			connection: connectionInfo,
+			performance: performanceInfo,
+			localStorageKeys: storageKeys(window.localStorage),
+			sessionStorageKeys: storageKeys(window.sessionStorage),
+			touchSupport: {
+				maxTouchPoints: touchPoints,
+				touchEvent: typeof window.ontouchstart !== "undefined",
+				pointerEvent: typeof window.PointerEvent !== "undefined",
+			},
+			mediaDevices: !!(nav.mediaDevices && nav.mediaDevices.enumerateDevices),
+		};
+	}
+
+	async function gatherUserAgentData(nav) {
+		const uaData = nav.userAgentData;
+		if (!uaData) {
+			return null;
+		}
+		const data = {
+			brands: Array.isArray(uaData.brands) ? uaData.brands : [],
+			mobile: boolOrNull(uaData.mobile),
+			platform: uaData.platform || null,
+		};
+		if (typeof uaData.getHighEntropyValues === "function") {
+			try {
+				const high = await uaData.getHighEntropyValues([
+					"architecture",
+					"bitness",
+					"formFactors",
+					"fullVersionList",
+					"model",
+					"platformVersion",
+					"uaFullVersion",
+					"wow64",
+				]);
+				Object.keys(high).forEach(function (key) {
+					data[key] = high[key];
+				});
+			} catch (_) {
+				data.highEntropyError = true;
+			}
+		}
+		return data;
+	}
+
+	function onReady(callback) {
+		if (document.readyState === "loading") {
+			document.addEventListener("DOMContentLoaded", callback, { once: true });
+		} else {
+			callback();
+		}
+	}
+
+	async function send() {
+		updateStatus("Collecting additional details from your browser...", "info");
+		const payload = gatherClientData();
+		payload.userAgentData = await gatherUserAgentData(typeof navigator === "undefined" ? {} : navigator);
+		try {
+			const response = await fetch(endpoint, {
+				method: "POST",
+				credentials: "same-origin",
+				headers: {
+					"Content-Type": "application/json",
+				},
+				body: JSON.stringify(payload),
+			});
+			if (!response.ok) {
+				const text = await response.text();
+				throw new Error("server responded with " + response.status + ": " + text);
+			}
+			updateStatus("Rendering browser metadata...", "info");
+			const html = await response.text();
+			document.open();
+			document.write(html);
+			document.close();
+		} catch (err) {
+			const message = err && err.message ? err.message : String(err);
+			updateStatus("Failed to capture browser details: " + message, "danger");
+		}
+	}
+
+	onReady(send);
+})();
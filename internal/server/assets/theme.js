@@ -0,0 +1,14 @@
+(function () {
+	"use strict";
+	// Follow the system colour scheme unless a template pins data-bs-theme.
+	const root = document.documentElement;
+	if (root.hasAttribute("data-bs-theme") || !window.matchMedia) {
+		return;
+	}
+	const query = window.matchMedia("(prefers-color-scheme: dark)");
+	function apply() {
+		root.setAttribute("data-bs-theme", query.matches ? "dark" : "light");
+	}
+	apply();
+	query.addEventListener("change", apply);
+})();
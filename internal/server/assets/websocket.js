@@ -0,0 +1,96 @@
+(function () {
+	"use strict";
+	const statusEl = document.getElementById("ws-status");
+	const logEl = document.getElementById("ws-log");
+	const connectForm = document.getElementById("ws-connect");
+	const sendForm = document.getElementById("ws-send");
+	const disconnectBtn = document.getElementById("ws-disconnect");
+	const sendBtn = sendForm.querySelector("button[type=submit]");
+	const sendBinaryBtn = document.getElementById("ws-send-binary");
+	const messageInput = document.getElementById("ws-message");
+	const protocolsInput = document.getElementById("ws-protocols");
+	let socket = null;
+
+	function updateStatus(message, variant) {
+		statusEl.textContent = message;
+		statusEl.className = "alert alert-" + variant + " mb-4";
+	}
+
+	function append(direction, text) {
+		logEl.textContent += "[" + new Date().toISOString() + "] " + direction + " " + text + "\n";
+		logEl.scrollTop = logEl.scrollHeight;
+	}
+
+	function setConnected(connected) {
+		sendBtn.disabled = !connected;
+		sendBinaryBtn.disabled = !connected;
+		disconnectBtn.disabled = !connected;
+	}
+
+	function describe(data) {
+		if (typeof data === "string") {
+			try {
+				return JSON.stringify(JSON.parse(data), null, 2);
+			} catch (_) {
+				return data;
+			}
+		}
+		return "<binary " + data.byteLength + " bytes>";
+	}
+
+	connectForm.addEventListener("submit", function (event) {
+		event.preventDefault();
+		if (socket) {
+			socket.close();
+		}
+		const scheme = window.location.protocol === "https:" ? "wss://" : "ws://";
+		const protocols = protocolsInput.value.split(",").map(function (p) { return p.trim(); }).filter(Boolean);
+		try {
+			socket = new WebSocket(scheme + window.location.host + "/ws", protocols);
+		} catch (err) {
+			updateStatus("Failed to open socket: " + err.message, "danger");
+			return;
+		}
+		socket.binaryType = "arraybuffer";
+		updateStatus("Connecting...", "info");
+		socket.addEventListener("open", function () {
+			setConnected(true);
+			updateStatus("Connected (protocol: " + (socket.protocol || "none") + ", extensions: " + (socket.extensions || "none") + ").", "success");
+		});
+		socket.addEventListener("message", function (event) {
+			append("<-", describe(event.data));
+		});
+		socket.addEventListener("close", function (event) {
+			setConnected(false);
+			updateStatus("Closed with code " + event.code + (event.reason ? ": " + event.reason : "") + ".", "secondary");
+			socket = null;
+		});
+		socket.addEventListener("error", function () {
+			updateStatus("WebSocket error; check the browser console and proxy logs.", "danger");
+		});
+	});
+
+	disconnectBtn.addEventListener("click", function () {
+		if (socket) {
+			socket.close(1000, "client disconnect");
+		}
+	});
+
+	sendForm.addEventListener("submit", function (event) {
+		event.preventDefault();
+		if (!socket) {
+			return;
+		}
+		socket.send(messageInput.value);
+		append("->", messageInput.value);
+	});
+
+	sendBinaryBtn.addEventListener("click", function () {
+		if (!socket) {
+			return;
+		}
+		const bytes = new TextEncoder().encode(messageInput.value);
+		socket.send(bytes);
+		append("->", "<binary " + bytes.byteLength + " bytes>");
+	});
+})();
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAssetServing(t *testing.T) {
+	s := New(4096, WithLogger(discardLogger()))
+	h := s.Handler()
+	version := defaultTemplateSet.assets.version
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		status      int
+		contentType string
+	}{
+		{name: "stylesheet", path: "/assets/" + version + "/reflector.css", status: http.StatusOK, contentType: "text/css; charset=utf-8"},
+		{name: "bootstrap", path: "/assets/" + version + "/bootstrap.min.css", status: http.StatusOK, contentType: "text/css; charset=utf-8"},
+		{name: "script", path: "/assets/" + version + "/collector.js", status: http.StatusOK, contentType: "text/javascript; charset=utf-8"},
+		{name: "head", method: http.MethodHead, path: "/assets/" + version + "/theme.js", status: http.StatusOK, contentType: "text/javascript; charset=utf-8"},
+		{name: "unknown asset", path: "/assets/" + version + "/missing.js", status: http.StatusNotFound},
+		{name: "old version", path: "/assets/0123456789ab/reflector.css", status: http.StatusNotFound},
+		{name: "no version", path: "/assets/reflector.css", status: http.StatusNotFound},
+		{name: "directory", path: "/assets/" + version + "/", status: http.StatusNotFound},
+		{name: "post", method: http.MethodPost, path: "/assets/" + version + "/reflector.css", status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, "/", nil)
+			r.URL.Path = tt.path
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				if cc := w.Header().Get("Cache-Control"); strings.Contains(cc, "immutable") {
+					t.Errorf("%d response cached with %q", w.Code, cc)
+				}
+				return
+			}
+			if ct := w.Header().Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("Content-Type %q, want %q", ct, tt.contentType)
+			}
+			if cc := w.Header().Get("Cache-Control"); cc != "public, max-age=31536000, immutable" {
+				t.Errorf("Cache-Control %q", cc)
+			}
+			if etag := w.Header().Get("ETag"); etag != `"`+version+`"` {
+				t.Errorf("ETag %q", etag)
+			}
+			if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+				t.Error("no X-Content-Type-Options: nosniff")
+			}
+			if method == http.MethodHead && w.Body.Len() != 0 {
+				t.Errorf("HEAD returned %d bytes", w.Body.Len())
+			}
+			if method == http.MethodGet && w.Body.Len() == 0 {
+				t.Error("empty asset")
+			}
+		})
+	}
+
+	// The mux cleans dot segments before they reach the handler, so call it
+	// directly to check that it refuses them too.
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.URL.Path = "/assets/" + version + "/../assets.go"
+	w := httptest.NewRecorder()
+	defaultTemplateSet.assets.serve(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("path outside the assets: status %d", w.Code)
+	}
+
+	// A matching ETag is answered without a body.
+	r = httptest.NewRequest(http.MethodGet, "/assets/"+version+"/reflector.css", nil)
+	r.Header.Set("If-None-Match", `"`+version+`"`)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: status %d", w.Code)
+	}
+}
+
+func TestAssetOverlay(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "assets"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"reflector.css": "body { color: red }", "extra.js": "void 0"} {
+		if err := os.WriteFile(filepath.Join(dir, "assets", name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tmpl, err := LoadTemplates(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assets := tmpl.set.assets
+	if assets.version == defaultTemplateSet.assets.version {
+		t.Error("overlay did not change the asset version")
+	}
+	h := New(4096, WithTemplates(tmpl), WithLogger(discardLogger())).Handler()
+
+	for name, want := range map[string]string{"reflector.css": "body { color: red }", "extra.js": "void 0"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, assets.path(name), nil))
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("%s: %d %q", name, w.Code, w.Body.String())
+		}
+	}
+	// Files the overlay does not replace come from the embedded set.
+	embedded, err := fs.ReadFile(embeddedAssets, "collector.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, assets.path("collector.js"), nil))
+	if w.Body.String() != string(embedded) {
+		t.Error("collector.js not served from the embedded assets")
+	}
+}
+
+func TestPageSecurityHeaders(t *testing.T) {
+	h := New(4096, WithLogger(discardLogger())).Handler()
+	version := defaultTemplateSet.assets.version
+	for _, path := range []string{"/", "/websocket", "/cookies"} {
+		t.Run(path, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			r.Host = "reflector.example:8080"
+			r.Header.Set("Accept", "text/html")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d", w.Code)
+			}
+			csp := w.Header().Get("Content-Security-Policy")
+			for _, directive := range []string{
+				"default-src 'none'",
+				"style-src 'self'",
+				"script-src 'self'",
+				"connect-src 'self' ws://reflector.example:8080 wss://reflector.example:8080",
+				"base-uri 'none'",
+				"frame-ancestors 'none'",
+			} {
+				if !strings.Contains(csp, directive) {
+					t.Errorf("CSP %q lacks %q", csp, directive)
+				}
+			}
+			if strings.Contains(csp, "unsafe-inline") || strings.Contains(csp, "http") {
+				t.Errorf("CSP %q allows inline code or other origins", csp)
+			}
+			if w.Header().Get("X-Content-Type-Options") != "nosniff" || w.Header().Get("Referrer-Policy") != "no-referrer" {
+				t.Errorf("headers %v", w.Header())
+			}
+			body := w.Body.String()
+			if !strings.Contains(body, "/assets/"+version+"/") {
+				t.Error("page does not link the versioned assets")
+			}
+			if strings.Contains(body, "cdn.jsdelivr.net") || strings.Contains(body, "<script>") {
+				t.Error("page loads third-party or inline script")
+			}
+		})
+	}
+}
+
+func TestPageSecurityOddHost(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Host = "evil.example; script-src *"
+	w := httptest.NewRecorder()
+	setPageSecurity(w, r)
+	if csp := w.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "connect-src 'self';") {
+		t.Errorf("CSP %q", csp)
+	}
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AuthConfig protects the capture and admin endpoints. Any configured method
+// that succeeds grants access; with nothing configured the endpoints are open.
+type AuthConfig struct {
+	// Tokens are accepted as "Authorization: Bearer <token>".
+	Tokens []string
+	// Users maps basic auth user names to bcrypt password hashes.
+	Users map[string]string
+	// TrustedHeader names a header set by an authenticating proxy, such as
+	// X-Forwarded-User. It is only honoured for peers in TrustedProxies.
+	TrustedHeader  string
+	TrustedProxies []*net.IPNet
+}
+
+// DefaultTrustedProxies limits trusted auth headers to loopback peers.
+var DefaultTrustedProxies = []string{"127.0.0.0/8", "::1/128"}
+
+// dummyHash keeps basic auth for unknown users as slow as for known ones.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("reflector"), bcrypt.DefaultCost)
+	return hash
+})
+
+func (c *AuthConfig) enabled() bool {
+	return c != nil && (len(c.Tokens) > 0 || len(c.Users) > 0 || c.TrustedHeader != "")
+}
+
+// ParseTokens reads bearer tokens, one per line. Blank lines and lines
+// starting with # are ignored.
+func ParseTokens(data []byte) []string {
+	var tokens []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, line)
+	}
+	return tokens
+}
+
+// ParseHtpasswd reads "user:hash" lines as written by htpasswd -B. Only
+// bcrypt hashes are accepted.
+func ParseHtpasswd(data []byte) (map[string]string, error) {
+	users := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("htpasswd line %d: want user:hash", n)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("htpasswd line %d: user %q: not a bcrypt hash", n, user)
+		}
+		users[user] = hash
+	}
+	return users, sc.Err()
+}
+
+// ParseCIDRs parses a list of CIDR blocks; bare addresses are treated as
+// single-host blocks.
+func ParseCIDRs(values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", v)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requireAuth wraps endpoints that expose captured data or server internals.
+func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.auth.enabled() {
+			next(w, r)
+			return
+		}
+		if principal, ok := s.authenticate(r); ok {
+			s.logger.Debug("authenticated", "request_id", requestIDFromRequest(r), "principal", principal)
+			next(w, r)
+			return
+		}
+		if len(s.auth.Users) > 0 {
+			w.Header().Add("WWW-Authenticate", `Basic realm="reflector", charset="UTF-8"`)
+		}
+		if len(s.auth.Tokens) > 0 {
+			w.Header().Add("WWW-Authenticate", `Bearer realm="reflector"`)
+		}
+		status := http.StatusUnauthorized
+		if w.Header().Get("WWW-Authenticate") == "" {
+			// Only the proxy header is accepted, so credentials would not help.
+			status = http.StatusForbidden
+		}
+		http.Error(w, strings.ToLower(http.StatusText(status)), status)
+	}
+}
+
+// authenticate returns a description of the caller when any configured
+// method accepts the request.
+func (s *Server) authenticate(r *http.Request) (string, bool) {
+	cfg := s.auth
+	if cfg.TrustedHeader != "" {
+		if user := r.Header.Get(cfg.TrustedHeader); user != "" && containsIP(cfg.TrustedProxies, net.ParseIP(addrHost(r.RemoteAddr))) {
+			return "header:" + user, true
+		}
+	}
+	scheme, credentials, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	switch {
+	case strings.EqualFold(scheme, "Bearer") && len(cfg.Tokens) > 0:
+		if validToken(cfg.Tokens, strings.TrimSpace(credentials)) {
+			return "token", true
+		}
+	case strings.EqualFold(scheme, "Basic") && len(cfg.Users) > 0:
+		user, password, ok := r.BasicAuth()
+		if !ok {
+			return "", false
+		}
+		hash, known := cfg.Users[user]
+		if !known {
+			_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+			return "", false
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+			return "user:" + user, true
+		}
+	}
+	return "", false
+}
+
+// validToken compares digests so neither the token length nor its contents
+// leak through timing.
+func validToken(tokens []string, candidate string) bool {
+	if candidate == "" {
+		return false
+	}
+	want := sha256.Sum256([]byte(candidate))
+	found := 0
+	for _, token := range tokens {
+		got := sha256.Sum256([]byte(token))
+		found |= subtle.ConstantTimeCompare(want[:], got[:])
+	}
+	return found == 1
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testHtpasswd(t *testing.T, user, password string) map[string]string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := ParseHtpasswd([]byte("# users\n\n" + user + ":" + string(hash) + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return users
+}
+
+// authRequest fetches /history from s and returns the response.
+func authRequest(s *Server, remote string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/history", nil)
+	r.RemoteAddr = remote
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	return w
+}
+
+func TestRequireAuth(t *testing.T) {
+	users := testHtpasswd(t, "alice", "correct horse")
+	proxies := mustCIDRs(t, "10.0.0.0/8")
+	full := AuthConfig{
+		Tokens:         []string{"tok-one", "tok-two"},
+		Users:          users,
+		TrustedHeader:  "X-Forwarded-User",
+		TrustedProxies: proxies,
+	}
+	basic := func(user, password string) http.Header {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth(user, password)
+		return http.Header{"Authorization": r.Header["Authorization"]}
+	}
+	bearer := func(token string) http.Header {
+		return http.Header{"Authorization": {"Bearer " + token}}
+	}
+	forwarded := http.Header{"X-Forwarded-User": {"bob"}}
+
+	tests := []struct {
+		name      string
+		cfg       AuthConfig
+		remote    string
+		header    http.Header
+		status    int
+		challenge []string
+	}{
+		{name: "auth not configured", remote: "192.0.2.1:1000", status: http.StatusOK},
+		{name: "first token", cfg: full, remote: "192.0.2.1:1000", header: bearer("tok-one"), status: http.StatusOK},
+		{name: "second token, lowercase scheme", cfg: full, remote: "192.0.2.1:1000", header: http.Header{"Authorization": {"bearer tok-two"}}, status: http.StatusOK},
+		{name: "wrong token", cfg: full, remote: "192.0.2.1:1000", header: bearer("tok-three"), status: http.StatusUnauthorized, challenge: []string{"Basic", "Bearer"}},
+		{name: "token prefix", cfg: full, remote: "192.0.2.1:1000", header: bearer("tok-on"), status: http.StatusUnauthorized},
+		{name: "empty token", cfg: full, remote: "192.0.2.1:1000", header: bearer(""), status: http.StatusUnauthorized},
+		{name: "basic auth", cfg: full, remote: "192.0.2.1:1000", header: basic("alice", "correct horse"), status: http.StatusOK},
+		{name: "bad password", cfg: full, remote: "192.0.2.1:1000", header: basic("alice", "battery staple"), status: http.StatusUnauthorized},
+		{name: "unknown user", cfg: full, remote: "192.0.2.1:1000", header: basic("mallory", "correct horse"), status: http.StatusUnauthorized},
+		{name: "token sent as basic password", cfg: full, remote: "192.0.2.1:1000", header: basic("alice", "tok-one"), status: http.StatusUnauthorized},
+		{name: "trusted header from proxy", cfg: full, remote: "10.1.2.3:1000", header: forwarded, status: http.StatusOK},
+		{name: "trusted header from anyone else", cfg: full, remote: "192.0.2.1:1000", header: forwarded, status: http.StatusUnauthorized},
+		{name: "no credentials", cfg: full, remote: "192.0.2.1:1000", status: http.StatusUnauthorized, challenge: []string{"Basic", "Bearer"}},
+		{name: "tokens only", cfg: AuthConfig{Tokens: []string{"t"}}, remote: "192.0.2.1:1000", status: http.StatusUnauthorized, challenge: []string{"Bearer"}},
+		{name: "header only, from proxy", cfg: AuthConfig{TrustedHeader: "X-Forwarded-User", TrustedProxies: proxies}, remote: "10.0.0.1:1000", header: forwarded, status: http.StatusOK},
+		{name: "header only, missing", cfg: AuthConfig{TrustedHeader: "X-Forwarded-User", TrustedProxies: proxies}, remote: "10.0.0.1:1000", status: http.StatusForbidden},
+		{name: "header only, spoofed", cfg: AuthConfig{TrustedHeader: "X-Forwarded-User", TrustedProxies: proxies}, remote: "192.0.2.1:1000", header: forwarded, status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(4096, WithAuth(tt.cfg), WithLogger(discardLogger()))
+			w := authRequest(s, tt.remote, tt.header)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+			challenges := w.Header().Values("WWW-Authenticate")
+			if tt.status == http.StatusForbidden && len(challenges) != 0 {
+				t.Errorf("403 with WWW-Authenticate %q", challenges)
+			}
+			if tt.status == http.StatusUnauthorized && len(challenges) == 0 {
+				t.Error("401 without WWW-Authenticate")
+			}
+			if tt.challenge != nil {
+				var schemes []string
+				for _, c := range challenges {
+					scheme, _, _ := strings.Cut(c, " ")
+					schemes = append(schemes, scheme)
+				}
+				if strings.Join(schemes, ",") != strings.Join(tt.challenge, ",") {
+					t.Errorf("challenges %q, want schemes %q", challenges, tt.challenge)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthLeavesPublicEndpointsOpen(t *testing.T) {
+	s := New(4096, WithAuth(AuthConfig{Tokens: []string{"t"}}), WithLogger(discardLogger()))
+	for _, path := range []string{"/", "/healthz"} {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d", path, w.Code)
+		}
+	}
+	for _, path := range []string{"/history", "/metrics", "/diff"} {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", path, w.Code)
+		}
+	}
+}
+
+func TestParseHtpasswd(t *testing.T) {
+	bcryptHash := "$2y$05$" + strings.Repeat("a", 53)
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "bcrypt", data: "alice:" + bcryptHash},
+		{name: "md5 apr1", data: "alice:$apr1$salt$3ZUcT3yE5F5vJ0tJ0Jq7d/", wantErr: true},
+		{name: "sha1", data: "alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=", wantErr: true},
+		{name: "crypt", data: "alice:rl0QH2bJ3zE3k", wantErr: true},
+		{name: "plain text", data: "alice:hunter2", wantErr: true},
+		{name: "no separator", data: "alice", wantErr: true},
+		{name: "empty user", data: ":" + bcryptHash, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, err := ParseHtpasswd([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got %v, %v", users, err)
+			}
+			if err == nil && users["alice"] != bcryptHash {
+				t.Errorf("users %v", users)
+			}
+		})
+	}
+}
+
+func TestParseTokens(t *testing.T) {
+	got := ParseTokens([]byte("# comment\n tok-one \n\n#tok-disabled\ntok-two\n"))
+	if strings.Join(got, ",") != "tok-one,tok-two" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := ParseCIDRs([]string{" 10.0.0.0/8", "", "192.0.2.7", "2001:db8::1", "2001:db8:1::/48"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "192.0.2.7/32", "2001:db8::1/128", "2001:db8:1::/48"}
+	if len(nets) != len(want) {
+		t.Fatalf("got %v", nets)
+	}
+	for i, n := range nets {
+		if n.String() != want[i] {
+			t.Errorf("net %d = %s, want %s", i, n, want[i])
+		}
+	}
+	for _, bad := range []string{"10.0.0.0/33", "not-an-ip", "192.0.2.300"} {
+		if _, err := ParseCIDRs([]string{bad}); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+}
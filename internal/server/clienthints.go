@@ -0,0 +1,199 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// uaHints pairs each User-Agent Client Hint header with the field that
+// navigator.userAgentData reports for it.
+var uaHints = []struct {
+	header string
+	field  string
+}{
+	{"Sec-CH-UA", "brands"},
+	{"Sec-CH-UA-Mobile", "mobile"},
+	{"Sec-CH-UA-Platform", "platform"},
+	{"Sec-CH-UA-Platform-Version", "platformVersion"},
+	{"Sec-CH-UA-Arch", "architecture"},
+	{"Sec-CH-UA-Bitness", "bitness"},
+	{"Sec-CH-UA-Model", "model"},
+	{"Sec-CH-UA-Full-Version", "uaFullVersion"},
+	{"Sec-CH-UA-Full-Version-List", "fullVersionList"},
+	{"Sec-CH-UA-WoW64", "wow64"},
+	{"Sec-CH-UA-Form-Factors", "formFactors"},
+}
+
+// legacyHints are client hints that predate the Sec-CH- prefix.
+var legacyHints = []string{"Device-Memory", "DPR", "Viewport-Width", "Width", "Downlink", "ECT", "RTT", "Save-Data"}
+
+// AllUAHints lists every User-Agent Client Hint header, for use with
+// WithClientHints.
+func AllUAHints() []string {
+	out := make([]string, 0, len(uaHints))
+	for _, h := range uaHints {
+		out = append(out, h.header)
+	}
+	return out
+}
+
+// clientHint compares one hint as sent in request headers with the value
+// the page's script read from navigator.userAgentData.
+type clientHint struct {
+	Header    string `json:"header"`
+	Requested bool   `json:"requested,omitempty"`
+	Critical  bool   `json:"critical,omitempty"`
+	Received  bool   `json:"received"`
+	Value     string `json:"value,omitempty"`
+	Field     string `json:"js_field,omitempty"`
+	Script    string `json:"js_value,omitempty"`
+	Mismatch  bool   `json:"mismatch,omitempty"`
+}
+
+// advertiseClientHints asks the browser for the configured hints on
+// subsequent requests, or immediately for critical ones.
+func (s *Server) advertiseClientHints(w http.ResponseWriter) {
+	if len(s.acceptCH) == 0 {
+		return
+	}
+	w.Header().Set("Accept-CH", strings.Join(s.acceptCH, ", "))
+	if len(s.criticalCH) > 0 {
+		w.Header().Set("Critical-CH", strings.Join(s.criticalCH, ", "))
+	}
+	w.Header().Add("Vary", strings.Join(s.acceptCH, ", "))
+}
+
+func (s *Server) clientHintsFromRequest(r *http.Request, clientData map[string]any) []clientHint {
+	var uaData map[string]any
+	if clientData != nil {
+		uaData, _ = clientData["userAgentData"].(map[string]any)
+	}
+
+	var out []clientHint
+	index := make(map[string]int)
+	add := func(header string) *clientHint {
+		key := strings.ToLower(header)
+		if i, ok := index[key]; ok {
+			return &out[i]
+		}
+		index[key] = len(out)
+		out = append(out, clientHint{Header: header})
+		return &out[len(out)-1]
+	}
+
+	for _, h := range s.acceptCH {
+		add(hintDisplayName(h)).Requested = true
+	}
+	for _, h := range s.criticalCH {
+		add(hintDisplayName(h)).Critical = true
+	}
+	for _, h := range uaHints {
+		_, scripted := uaData[h.field]
+		if r.Header.Get(h.header) != "" || scripted {
+			hint := add(h.header)
+			hint.Field = h.field
+		}
+	}
+	var extra []string
+	for name := range r.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "sec-ch-") || isLegacyHint(lower) {
+			if _, ok := index[lower]; !ok {
+				extra = append(extra, hintDisplayName(name))
+			}
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		add(name)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+
+	for i := range out {
+		hint := &out[i]
+		if values := r.Header.Values(hint.Header); len(values) > 0 {
+			hint.Received = true
+			hint.Value = strings.Join(values, ", ")
+		}
+		if hint.Field == "" {
+			hint.Field = uaHintField(hint.Header)
+		}
+		if v, ok := uaData[hint.Field]; ok && hint.Field != "" {
+			hint.Script = structuredHintValue(v)
+			hint.Mismatch = hint.Received && hint.Script != "" && hint.Script != hint.Value
+		}
+	}
+	return out
+}
+
+func isLegacyHint(lower string) bool {
+	for _, h := range legacyHints {
+		if strings.ToLower(h) == lower {
+			return true
+		}
+	}
+	return false
+}
+
+// hintDisplayName restores the conventional spelling of known hints, since
+// header canonicalisation turns Sec-CH-UA into Sec-Ch-Ua.
+func hintDisplayName(name string) string {
+	lower := strings.ToLower(strings.TrimSpace(name))
+	for _, h := range uaHints {
+		if strings.ToLower(h.header) == lower {
+			return h.header
+		}
+	}
+	for _, h := range legacyHints {
+		if strings.ToLower(h) == lower {
+			return h
+		}
+	}
+	if strings.HasPrefix(lower, "sec-ch-") {
+		return "Sec-CH-" + http.CanonicalHeaderKey(lower[len("sec-ch-"):])
+	}
+	return http.CanonicalHeaderKey(name)
+}
+
+func uaHintField(header string) string {
+	for _, h := range uaHints {
+		if strings.EqualFold(h.header, header) {
+			return h.field
+		}
+	}
+	return ""
+}
+
+// structuredHintValue renders a userAgentData value the way the browser
+// serialises it into the matching structured header.
+func structuredHintValue(v any) string {
+	switch t := v.(type) {
+	case bool:
+		if t {
+			return "?1"
+		}
+		return "?0"
+	case string:
+		return strconv.Quote(t)
+	case []any:
+		parts := make([]string, 0, len(t))
+		for _, item := range t {
+			switch it := item.(type) {
+			case map[string]any:
+				parts = append(parts, fmt.Sprintf("%s;v=%s", strconv.Quote(fmt.Sprint(it["brand"])), strconv.Quote(fmt.Sprint(it["version"]))))
+			default:
+				parts = append(parts, strconv.Quote(fmt.Sprint(it)))
+			}
+		}
+		return strings.Join(parts, ", ")
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(t)
+	}
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdvertiseClientHints(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   []string
+		critical []string
+		want     string
+		wantCrit string
+	}{
+		{name: "none"},
+		{name: "accept only", accept: []string{"sec-ch-ua-model", " Sec-CH-UA-Arch ", ""}, want: "Sec-CH-UA-Model, Sec-CH-UA-Arch"},
+		{
+			name:     "critical hints are also accepted",
+			accept:   []string{"Sec-CH-UA-Platform-Version", "dpr"},
+			critical: []string{"sec-ch-ua-platform-version", "Sec-CH-UA-Bitness"},
+			want:     "Sec-CH-UA-Platform-Version, DPR, Sec-CH-UA-Bitness",
+			wantCrit: "Sec-CH-UA-Platform-Version, Sec-CH-UA-Bitness",
+		},
+		{name: "unknown hint", accept: []string{"sec-ch-prefers-color-scheme"}, want: "Sec-CH-Prefers-Color-Scheme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(4096, WithClientHints(tt.accept, tt.critical), WithLogger(discardLogger()))
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if got := w.Header().Get("Accept-CH"); got != tt.want {
+				t.Errorf("Accept-CH %q, want %q", got, tt.want)
+			}
+			if got := w.Header().Get("Critical-CH"); got != tt.wantCrit {
+				t.Errorf("Critical-CH %q, want %q", got, tt.wantCrit)
+			}
+			vary := strings.Join(w.Header().Values("Vary"), ", ")
+			if tt.want != "" && !strings.Contains(vary, tt.want) {
+				t.Errorf("Vary %q does not list %q", vary, tt.want)
+			}
+			if tt.want == "" && strings.Contains(strings.ToLower(vary), "sec-ch-") {
+				t.Errorf("Vary %q without configured hints", vary)
+			}
+		})
+	}
+}
+
+func TestClientHintsFromRequest(t *testing.T) {
+	s := New(4096, WithClientHints([]string{"Sec-CH-UA-Model", "Sec-CH-UA-Arch"}, []string{"Sec-CH-UA-Platform-Version"}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Sec-CH-UA", `"Chromium";v="124", "Not-A.Brand";v="99"`)
+	r.Header.Set("Sec-CH-UA-Mobile", "?0")
+	r.Header.Set("Sec-CH-UA-Platform", `"Linux"`)
+	r.Header.Set("Sec-CH-UA-Arch", `"x86"`)
+	r.Header.Set("Sec-CH-Prefers-Reduced-Motion", "reduce")
+	r.Header.Set("DPR", "2")
+	r.Header.Set("Accept", "*/*")
+	clientData := map[string]any{
+		"userAgentData": map[string]any{
+			"brands": []any{
+				map[string]any{"brand": "Chromium", "version": "124"},
+				map[string]any{"brand": "Not-A.Brand", "version": "99"},
+			},
+			"mobile":          false,
+			"platform":        "macOS",
+			"architecture":    "x86",
+			"platformVersion": "14.4.0",
+		},
+	}
+
+	hints := s.clientHintsFromRequest(r, clientData)
+	byHeader := make(map[string]clientHint)
+	var order []string
+	for _, h := range hints {
+		byHeader[h.Header] = h
+		order = append(order, h.Header)
+	}
+	// Configured hints come first, then the known UA hints, then any other
+	// hint headers in name order.
+	wantOrder := "Sec-CH-UA-Model,Sec-CH-UA-Arch,Sec-CH-UA-Platform-Version,Sec-CH-UA,Sec-CH-UA-Mobile,Sec-CH-UA-Platform,DPR,Sec-CH-Prefers-Reduced-Motion"
+	if got := strings.Join(order, ","); got != wantOrder {
+		t.Errorf("order %s\nwant  %s", got, wantOrder)
+	}
+
+	tests := []struct {
+		header string
+		want   clientHint
+	}{
+		{"Sec-CH-UA-Model", clientHint{Header: "Sec-CH-UA-Model", Requested: true, Field: "model"}},
+		{"Sec-CH-UA-Arch", clientHint{Header: "Sec-CH-UA-Arch", Requested: true, Received: true, Value: `"x86"`, Field: "architecture", Script: `"x86"`}},
+		{"Sec-CH-UA-Platform-Version", clientHint{Header: "Sec-CH-UA-Platform-Version", Requested: true, Critical: true, Field: "platformVersion", Script: `"14.4.0"`}},
+		{"Sec-CH-UA", clientHint{Header: "Sec-CH-UA", Received: true, Value: `"Chromium";v="124", "Not-A.Brand";v="99"`, Field: "brands", Script: `"Chromium";v="124", "Not-A.Brand";v="99"`}},
+		{"Sec-CH-UA-Mobile", clientHint{Header: "Sec-CH-UA-Mobile", Received: true, Value: "?0", Field: "mobile", Script: "?0"}},
+		{"Sec-CH-UA-Platform", clientHint{Header: "Sec-CH-UA-Platform", Received: true, Value: `"Linux"`, Field: "platform", Script: `"macOS"`, Mismatch: true}},
+		{"DPR", clientHint{Header: "DPR", Received: true, Value: "2"}},
+		{"Sec-CH-Prefers-Reduced-Motion", clientHint{Header: "Sec-CH-Prefers-Reduced-Motion", Received: true, Value: "reduce"}},
+	}
+	for _, tt := range tests {
+		if got := byHeader[tt.header]; got != tt.want {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestClientHintsWithoutHints(t *testing.T) {
+	s := New(4096)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("User-Agent", "curl/8.5.0")
+	if hints := s.clientHintsFromRequest(r, nil); hints != nil {
+		t.Errorf("got %+v", hints)
+	}
+}
+
+func TestStructuredHintValue(t *testing.T) {
+	tests := []struct {
+		in   any
+		want string
+	}{
+		{true, "?1"},
+		{false, "?0"},
+		{"Windows", `"Windows"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{[]any{"Desktop", "XR"}, `"Desktop", "XR"`},
+		{[]any{map[string]any{"brand": "Google Chrome", "version": "124.0.6367.91"}}, `"Google Chrome";v="124.0.6367.91"`},
+		{nil, ""},
+		{float64(64), "64"},
+	}
+	for _, tt := range tests {
+		if got := structuredHintValue(tt.in); got != tt.want {
+			t.Errorf("structuredHintValue(%#v) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHintDisplayName(t *testing.T) {
+	for in, want := range map[string]string{
+		"sec-ch-ua-wow64":         "Sec-CH-UA-WoW64",
+		"SEC-CH-UA":               "Sec-CH-UA",
+		"viewport-width":          "Viewport-Width",
+		"ect":                     "ECT",
+		"sec-ch-viewport-height":  "Sec-CH-Viewport-Height",
+		"x-custom-hint":           "X-Custom-Hint",
+		" sec-ch-ua-form-factors": "Sec-CH-UA-Form-Factors",
+	} {
+		if got := hintDisplayName(in); got != want {
+			t.Errorf("hintDisplayName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type connContextKey struct{}
+
+type connDetailsKey struct{}
+
+// connInfo is the per-connection state shared by every request served on it.
+type connInfo struct {
+	id         uint64
+	acceptedAt time.Time
+	localAddr  string
+	requests   atomic.Int64
+	idleSince  atomic.Int64
+	timed      *timedConn
+}
+
+// connDetails is the snapshot of connInfo taken when a request starts.
+type connDetails struct {
+	ID              uint64    `json:"id"`
+	RequestNumber   int64     `json:"request_number"`
+	Reused          bool      `json:"reused"`
+	AcceptedAt      time.Time `json:"accepted_at"`
+	AgeMS           float64   `json:"age_ms"`
+	IdleMS          float64   `json:"idle_before_request_ms,omitempty"`
+	LocalAddr       string    `json:"local_addr"`
+	LocalIP         string    `json:"local_ip"`
+	LocalPort       string    `json:"local_port"`
+	OpenConnections int       `json:"open_connections"`
+}
+
+// connTracker hands out connection IDs and remembers open connections so
+// ConnState transitions can be attributed to them.
+type connTracker struct {
+	seq   atomic.Uint64
+	mu    sync.Mutex
+	conns map[net.Conn]*connInfo
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: make(map[net.Conn]*connInfo)}
+}
+
+// ConnContext is meant for http.Server.ConnContext. It tags each accepted
+// connection with an ID so requests can report whether they reused it.
+func (s *Server) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	info := &connInfo{
+		id:         s.conns.seq.Add(1),
+		acceptedAt: time.Now(),
+		localAddr:  c.LocalAddr().String(),
+		timed:      timedConnFrom(c),
+	}
+	if info.timed != nil {
+		info.acceptedAt = info.timed.acceptedAt
+	}
+	s.conns.mu.Lock()
+	s.conns.conns[c] = info
+	s.conns.mu.Unlock()
+	return context.WithValue(ctx, connContextKey{}, info)
+}
+
+// ConnState is meant for http.Server.ConnState.
+func (s *Server) ConnState(c net.Conn, state http.ConnState) {
+	s.conns.mu.Lock()
+	defer s.conns.mu.Unlock()
+	info, ok := s.conns.conns[c]
+	if !ok {
+		return
+	}
+	switch state {
+	case http.StateIdle:
+		info.idleSince.Store(time.Now().UnixNano())
+		if info.timed != nil {
+			info.timed.rearm()
+		}
+	case http.StateClosed, http.StateHijacked:
+		delete(s.conns.conns, c)
+	}
+}
+
+func (t *connTracker) open() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns)
+}
+
+// trackConnections counts requests per connection and stores a connDetails
+// snapshot in the request context for the handlers to render.
+func (s *Server) trackConnections(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, ok := r.Context().Value(connContextKey{}).(*connInfo)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		now := time.Now()
+		n := info.requests.Add(1)
+		details := &connDetails{
+			ID:              info.id,
+			RequestNumber:   n,
+			Reused:          n > 1,
+			AcceptedAt:      info.acceptedAt.UTC(),
+			AgeMS:           float64(now.Sub(info.acceptedAt).Microseconds()) / 1000,
+			LocalAddr:       info.localAddr,
+			OpenConnections: s.conns.open(),
+		}
+		if idle := info.idleSince.Load(); idle > 0 && n > 1 {
+			details.IdleMS = float64(now.Sub(time.Unix(0, idle)).Microseconds()) / 1000
+		}
+		if host, port, err := net.SplitHostPort(info.localAddr); err == nil {
+			details.LocalIP, details.LocalPort = host, port
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), connDetailsKey{}, details)))
+	})
+}
+
+func connFromRequest(r *http.Request) *connDetails {
+	details, _ := r.Context().Value(connDetailsKey{}).(*connDetails)
+	return details
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serveTracked starts s behind an httptest server wired up the way main
+// wires the real one, so connections are tracked.
+func serveTracked(t *testing.T, s *Server) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewUnstartedServer(s.Handler())
+	ts.Config.ConnContext = s.ConnContext
+	ts.Config.ConnState = s.ConnState
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func fetch(t *testing.T, client *http.Client, url string) {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+func TestConnectionReuse(t *testing.T) {
+	s := New(4096, WithLogger(discardLogger()))
+	ts := serveTracked(t, s)
+
+	pooled := &http.Client{Transport: &http.Transport{}}
+	defer pooled.CloseIdleConnections()
+	fetch(t, pooled, ts.URL+"/first")
+	fetch(t, pooled, ts.URL+"/second")
+	fresh := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	fetch(t, fresh, ts.URL+"/third")
+
+	captures := s.history.list(captureHTTP)
+	if len(captures) != 3 {
+		t.Fatalf("history has %d captures", len(captures))
+	}
+	byURI := map[string]*connDetails{}
+	for _, c := range captures {
+		byURI[c.HTTP.RequestURI] = c.HTTP.Connection
+	}
+	first, second, third := byURI["/first"], byURI["/second"], byURI["/third"]
+	if first == nil || second == nil || third == nil {
+		t.Fatalf("missing connection details: %+v", byURI)
+	}
+	if first.RequestNumber != 1 || first.Reused || first.IdleMS != 0 {
+		t.Errorf("first request: %+v", first)
+	}
+	if second.ID != first.ID || second.RequestNumber != 2 || !second.Reused {
+		t.Errorf("second request on the same connection: %+v (first %+v)", second, first)
+	}
+	if second.AgeMS < first.AgeMS || !second.AcceptedAt.Equal(first.AcceptedAt) {
+		t.Errorf("connection age went backwards: %v then %v", first.AgeMS, second.AgeMS)
+	}
+	if third.ID == first.ID || third.RequestNumber != 1 || third.Reused {
+		t.Errorf("request on a new connection: %+v", third)
+	}
+	if first.LocalIP != "127.0.0.1" || first.LocalPort == "" || first.OpenConnections < 1 {
+		t.Errorf("local address: %+v", first)
+	}
+}
+
+func TestConnectionUntracked(t *testing.T) {
+	s := New(4096, WithLogger(discardLogger()))
+	s.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if c := s.history.list(captureHTTP); len(c) != 1 || c[0].HTTP.Connection != nil {
+		t.Errorf("request without ConnContext has connection details: %+v", c)
+	}
+}
@@ -0,0 +1,397 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxCookieBytes is the per-cookie size browsers are required to support.
+	maxCookieBytes   = 4096
+	maxCookieFormLen = 64 << 10
+)
+
+// cookieValues parses the raw Cookie headers rather than using r.Cookies,
+// which silently drops malformed entries and hides duplicates.
+func cookieValues(r *http.Request) []cookieDetails {
+	var out []cookieDetails
+	seen := make(map[string]bool)
+	for _, line := range r.Header.Values("Cookie") {
+		for _, part := range strings.Split(line, ";") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			c := parseCookiePair(part)
+			c.Duplicate = seen[c.Name]
+			seen[c.Name] = true
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func parseCookiePair(part string) cookieDetails {
+	name, value, ok := strings.Cut(part, "=")
+	c := cookieDetails{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value), Size: len(part)}
+	switch {
+	case !ok:
+		c.Problems = append(c.Problems, "missing '='")
+	case c.Name == "":
+		c.Problems = append(c.Problems, "empty name")
+	case !validCookieName(c.Name):
+		c.Problems = append(c.Problems, "name contains characters outside an HTTP token")
+	}
+	if ok && !validCookieValue(c.Value) {
+		c.Problems = append(c.Problems, "value contains characters browsers may reject")
+	}
+	if c.Size > maxCookieBytes {
+		c.Problems = append(c.Problems, fmt.Sprintf("larger than %d bytes", maxCookieBytes))
+	}
+	return c
+}
+
+func validCookieName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isTokenChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTokenChar(c byte) bool {
+	if c <= ' ' || c >= 0x7f {
+		return false
+	}
+	return !strings.ContainsRune(`()<>@,;:\"/[]?={}`, rune(c))
+}
+
+// validCookieValue checks the cookie-octet grammar from RFC 6265, allowing
+// the value to be wrapped in double quotes.
+func validCookieValue(v string) bool {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = v[1 : len(v)-1]
+	}
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c < 0x21 || c > 0x7e || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+func cookieHeaderBytes(r *http.Request) int {
+	n := 0
+	for _, line := range r.Header.Values("Cookie") {
+		n += len(line)
+	}
+	return n
+}
+
+// cookieSpec is a cookie the /cookies tool asks the browser to store. It is
+// serialised by hand because net/http does not know about Partitioned.
+type cookieSpec struct {
+	Name        string
+	Value       string
+	Domain      string
+	Path        string
+	SameSite    string
+	MaxAge      string
+	Secure      bool
+	HTTPOnly    bool
+	Partitioned bool
+}
+
+func (c cookieSpec) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name + "=" + c.Value)
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + c.Domain)
+	}
+	if c.MaxAge != "" {
+		b.WriteString("; Max-Age=" + c.MaxAge)
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HTTPOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.SameSite != "" {
+		b.WriteString("; SameSite=" + c.SameSite)
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+func (c cookieSpec) validate() error {
+	if !validCookieName(c.Name) {
+		return fmt.Errorf("cookie name %q is not a valid token", c.Name)
+	}
+	if !validCookieValue(c.Value) {
+		return fmt.Errorf("cookie value for %q contains characters outside RFC 6265", c.Name)
+	}
+	for attr, v := range map[string]string{"Domain": c.Domain, "Path": c.Path} {
+		if strings.ContainsAny(v, ";\r\n") {
+			return fmt.Errorf("%s must not contain ';' or line breaks", attr)
+		}
+	}
+	switch c.SameSite {
+	case "", "Lax", "Strict", "None":
+	default:
+		return fmt.Errorf("SameSite must be Lax, Strict or None")
+	}
+	if c.MaxAge != "" {
+		if _, err := strconv.Atoi(c.MaxAge); err != nil {
+			return fmt.Errorf("Max-Age must be a whole number of seconds")
+		}
+	}
+	return nil
+}
+
+// warnings lists the reasons a browser is likely to refuse or restrict the
+// cookie, so a missing cookie on the next request can be explained.
+func (c cookieSpec) warnings(secureContext bool) []string {
+	var out []string
+	if c.Secure && !secureContext {
+		out = append(out, "Secure cookies are rejected when set over plain HTTP")
+	}
+	if c.SameSite == "None" && !c.Secure {
+		out = append(out, "SameSite=None requires Secure")
+	}
+	if c.Partitioned && !c.Secure {
+		out = append(out, "Partitioned requires Secure")
+	}
+	if strings.HasPrefix(c.Name, "__Secure-") && !c.Secure {
+		out = append(out, "__Secure- prefix requires Secure")
+	}
+	if strings.HasPrefix(c.Name, "__Host-") && (!c.Secure || c.Path != "/" || c.Domain != "") {
+		out = append(out, "__Host- prefix requires Secure, Path=/ and no Domain")
+	}
+	if c.HTTPOnly {
+		out = append(out, "HttpOnly cookies are still sent to the server but hidden from JavaScript")
+	}
+	return out
+}
+
+// parseCookieSpec reads a Set-Cookie line produced by cookieSpec.String.
+func parseCookieSpec(line string) cookieSpec {
+	parts := strings.Split(line, ";")
+	name, value, _ := strings.Cut(parts[0], "=")
+	c := cookieSpec{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)}
+	for _, attr := range parts[1:] {
+		key, v, _ := strings.Cut(strings.TrimSpace(attr), "=")
+		switch strings.ToLower(key) {
+		case "path":
+			c.Path = v
+		case "domain":
+			c.Domain = v
+		case "max-age":
+			c.MaxAge = v
+		case "samesite":
+			c.SameSite = v
+		case "secure":
+			c.Secure = true
+		case "httponly":
+			c.HTTPOnly = true
+		case "partitioned":
+			c.Partitioned = true
+		}
+	}
+	return c
+}
+
+// cookieMatrix covers the attribute combinations that most often explain
+// cookies going missing through CDNs and cross-site flows.
+func cookieMatrix() []cookieSpec {
+	value := randomHex(4)
+	return []cookieSpec{
+		{Name: "rf_default", Value: value, Path: "/"},
+		{Name: "rf_lax", Value: value, Path: "/", SameSite: "Lax"},
+		{Name: "rf_strict", Value: value, Path: "/", SameSite: "Strict"},
+		{Name: "rf_none_insecure", Value: value, Path: "/", SameSite: "None"},
+		{Name: "rf_none_secure", Value: value, Path: "/", SameSite: "None", Secure: true},
+		{Name: "rf_partitioned", Value: value, Path: "/", SameSite: "None", Secure: true, Partitioned: true},
+		{Name: "rf_httponly", Value: value, Path: "/", HTTPOnly: true},
+		{Name: "rf_cookies_path", Value: value, Path: "/cookies"},
+		{Name: "__Host-rf", Value: value, Path: "/", Secure: true},
+	}
+}
+
+type cookieResult struct {
+	SetCookie     string
+	Name          string
+	Returned      bool
+	ReturnedValue string
+	// Matches is worked out before the values are redacted.
+	Matches  bool
+	Warnings []string
+}
+
+// cookieValueDigest stands in for a cookie value in the redirect after a
+// POST, so values never reach URLs, access logs or the history. It is keyed
+// per process like hash-mode redaction.
+func cookieValueDigest(v string) string {
+	mac := hmac.New(sha256.New, redactHashKey)
+	mac.Write([]byte(v))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// clearCookieSpecs expires name under every path and attribute combination
+// cookieMatrix and the form default use. A cookie is only replaced by one
+// with the same path, and a partitioned cookie only by a partitioned one.
+func clearCookieSpecs(name string) []cookieSpec {
+	secure := strings.HasPrefix(name, "__Secure-") || strings.HasPrefix(name, "__Host-")
+	specs := []cookieSpec{
+		{Name: name, Path: "/", MaxAge: "0", Secure: secure},
+		{Name: name, Path: "/", MaxAge: "0", Secure: true, SameSite: "None", Partitioned: true},
+	}
+	// __Host- cookies can only have Path=/.
+	if !strings.HasPrefix(name, "__Host-") {
+		specs = append(specs, cookieSpec{Name: name, Path: "/cookies", MaxAge: "0", Secure: secure})
+	}
+	return specs
+}
+
+type cookiePageData struct {
+	Received    []cookieDetails
+	HeaderBytes int
+	Results     []cookieResult
+	Secure      bool
+	Error       string
+}
+
+// cookiesHandler sets cookies chosen in a form (POST) and, after redirecting,
+// reports which of them the browser sent back (GET).
+func (s *Server) cookiesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.renderCookiePage(w, r, http.StatusOK, "")
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxCookieFormLen)
+		if err := r.ParseForm(); err != nil {
+			s.renderCookiePage(w, r, http.StatusBadRequest, "could not read form: "+err.Error())
+			return
+		}
+		specs, err := cookieSpecsFromForm(r)
+		if err != nil {
+			s.renderCookiePage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		next := url.Values{}
+		for _, c := range specs {
+			w.Header().Add("Set-Cookie", c.String())
+			if c.MaxAge != "0" {
+				pending := c
+				pending.Value = cookieValueDigest(c.Value)
+				next.Add("set", pending.String())
+			}
+		}
+		target := "/cookies"
+		if len(next) > 0 {
+			target += "?" + next.Encode()
+		}
+		http.Redirect(w, r, target, http.StatusSeeOther)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func cookieSpecsFromForm(r *http.Request) ([]cookieSpec, error) {
+	switch r.PostForm.Get("action") {
+	case "matrix":
+		return cookieMatrix(), nil
+	case "clear":
+		// Duplicates share a name, so the expiries for the first clear
+		// them all as long as they differ only in path or partitioning.
+		var specs []cookieSpec
+		for _, c := range cookieValues(r) {
+			if c.Duplicate || !validCookieName(c.Name) {
+				continue
+			}
+			specs = append(specs, clearCookieSpecs(c.Name)...)
+		}
+		return specs, nil
+	}
+	checked := func(key string) bool {
+		switch r.PostForm.Get(key) {
+		case "on", "1", "true":
+			return true
+		}
+		return false
+	}
+	c := cookieSpec{
+		Name:        strings.TrimSpace(r.PostForm.Get("name")),
+		Value:       r.PostForm.Get("value"),
+		Domain:      strings.TrimSpace(r.PostForm.Get("domain")),
+		Path:        strings.TrimSpace(r.PostForm.Get("path")),
+		SameSite:    r.PostForm.Get("samesite"),
+		MaxAge:      strings.TrimSpace(r.PostForm.Get("max_age")),
+		Secure:      checked("secure"),
+		HTTPOnly:    checked("httponly"),
+		Partitioned: checked("partitioned"),
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return []cookieSpec{c}, nil
+}
+
+func (s *Server) renderCookiePage(w http.ResponseWriter, r *http.Request, status int, errMessage string) {
+	received := cookieValues(r)
+	sent := make(map[string]string, len(received))
+	for _, c := range received {
+		if !c.Duplicate {
+			sent[c.Name] = c.Value
+		}
+	}
+	secure := schemeFromRequest(r) == "https"
+
+	page := cookiePageData{HeaderBytes: cookieHeaderBytes(r), Secure: secure, Error: errMessage}
+	// The redirect carries a digest in place of each value, so the value
+	// that was set is only shown when the browser sent the same one back.
+	for _, line := range r.URL.Query()["set"] {
+		spec := parseCookieSpec(line)
+		value, ok := sent[spec.Name]
+		matches := ok && cookieValueDigest(value) == spec.Value
+		spec.Value = "…"
+		if matches {
+			spec.Value = value
+		}
+		page.Results = append(page.Results, cookieResult{
+			SetCookie:     s.redaction.redactSetCookie(spec.String()),
+			Name:          spec.Name,
+			Returned:      ok,
+			ReturnedValue: s.redaction.redactCookie(spec.Name, value),
+			Matches:       matches,
+			Warnings:      spec.warnings(secure),
+		})
+	}
+	for i := range received {
+		received[i].Value = s.redaction.redactCookie(received[i].Name, received[i].Value)
+	}
+	page.Received = received
+
+	w.Header().Set("Cache-Control", "no-store")
+	s.renderPage(w, r, status, "cookies", page)
+}
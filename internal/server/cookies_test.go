@@ -0,0 +1,160 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCookieRoundTripRedaction(t *testing.T) {
+	policy, err := ParseRedactionPolicy([]byte(`{"cookies": ["session"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(4096, WithRedactionPolicy(policy), WithLogger(discardLogger())).Handler()
+	roundTrip := func(name, value, cookie string) string {
+		t.Helper()
+		form := url.Values{"name": {name}, "value": {value}, "path": {"/"}}
+		r := httptest.NewRequest(http.MethodPost, "/cookies", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		location := w.Header().Get("Location")
+		if w.Code != http.StatusSeeOther || !strings.HasPrefix(location, "/cookies?") {
+			t.Fatalf("POST: status %d, Location %q", w.Code, location)
+		}
+		if strings.Contains(location, value) {
+			t.Errorf("redirect %q carries the value", location)
+		}
+
+		r = httptest.NewRequest(http.MethodGet, location, nil)
+		r.Header.Set("Cookie", cookie)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d", w.Code)
+		}
+		return w.Body.String()
+	}
+
+	tests := []struct {
+		name   string
+		cookie string
+		badge  string
+		hidden []string
+		shown  []string
+	}{
+		{
+			name:   "redacted cookie sent back unchanged",
+			cookie: "session=s3cr3t",
+			badge:  "text-bg-success",
+			hidden: []string{"s3cr3t"},
+		},
+		{
+			name:   "redacted cookie sent back changed",
+			cookie: "session=0ther",
+			badge:  "different value",
+			hidden: []string{"s3cr3t", "0ther"},
+		},
+		{
+			name:   "other cookies are shown",
+			cookie: "theme=light",
+			badge:  "different value",
+			hidden: []string{"dark"},
+			shown:  []string{"<code>light</code>", "theme=…; Path=/"},
+		},
+		{
+			name:   "value shown once it comes back",
+			cookie: "theme=dark",
+			badge:  "text-bg-success",
+			shown:  []string{"theme=dark; Path=/"},
+		},
+		{
+			name:   "missing cookie",
+			cookie: "other=1",
+			badge:  "missing",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, value := "theme", "dark"
+			if strings.HasPrefix(tt.cookie, "session=") {
+				name, value = "session", "s3cr3t"
+			}
+			body := roundTrip(name, value, tt.cookie)
+			if !strings.Contains(body, tt.badge) {
+				t.Errorf("result does not show %q", tt.badge)
+			}
+			for _, v := range tt.hidden {
+				if strings.Contains(body, v) {
+					t.Errorf("page leaks %q", v)
+				}
+			}
+			for _, v := range tt.shown {
+				if !strings.Contains(body, v) {
+					t.Errorf("page does not show %q", v)
+				}
+			}
+		})
+	}
+}
+
+func TestCookieClear(t *testing.T) {
+	h := New(4096, WithLogger(discardLogger())).Handler()
+	r := httptest.NewRequest(http.MethodPost, "/cookies", strings.NewReader("action=clear"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Cookie", "rf_cookies_path=1; rf_default=2; rf_cookies_path=3; rf_partitioned=4; __Host-rf=5; bad name=6")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/cookies" {
+		t.Fatalf("status %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+
+	cleared := map[string]bool{}
+	for _, line := range w.Header().Values("Set-Cookie") {
+		c := parseCookieSpec(line)
+		if c.MaxAge != "0" || c.Value != "" {
+			t.Errorf("%q does not expire the cookie", line)
+		}
+		key := c.Name + " " + c.Path
+		if c.Partitioned {
+			key += " partitioned"
+		}
+		if cleared[key] {
+			t.Errorf("%q sent twice", line)
+		}
+		cleared[key] = true
+	}
+	for _, want := range []string{
+		"rf_cookies_path /", "rf_cookies_path /cookies", "rf_cookies_path / partitioned",
+		"rf_default /", "rf_partitioned / partitioned", "__Host-rf /",
+	} {
+		if !cleared[want] {
+			t.Errorf("no expiry for %s in %v", want, cleared)
+		}
+	}
+	if cleared["__Host-rf /cookies"] {
+		t.Error("__Host- cookie expired with Path=/cookies")
+	}
+	if len(cleared) != 3*4-1 {
+		t.Errorf("sent %d expiries: %v", len(cleared), cleared)
+	}
+}
+
+// Every cookie the matrix sets must be removable with the clear button. Over
+// HTTPS an expiry without Secure still replaces a Secure cookie.
+func TestCookieClearCoversMatrix(t *testing.T) {
+	for _, set := range cookieMatrix() {
+		found := false
+		for _, c := range clearCookieSpecs(set.Name) {
+			if c.Path == set.Path && c.Domain == set.Domain && c.Partitioned == set.Partitioned {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("nothing clears %s", set)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Change kinds for a compared field.
+const (
+	diffSame    = "same"
+	diffAdded   = "added"
+	diffRemoved = "removed"
+	diffChanged = "changed"
+)
+
+// maxDiffCells bounds the line diff table; bodies larger than that are
+// reported as replaced wholesale.
+const maxDiffCells = 1 << 20
+
+// diffEntry compares one named field of two captures. A holds the values in
+// the first capture and B in the second.
+type diffEntry struct {
+	Name   string   `json:"name"`
+	Change string   `json:"change"`
+	A      []string `json:"a,omitempty"`
+	B      []string `json:"b,omitempty"`
+}
+
+type diffSection struct {
+	Name    string      `json:"name"`
+	Entries []diffEntry `json:"entries"`
+}
+
+// Changed returns the entries that differ.
+func (s diffSection) Changed() []diffEntry {
+	var out []diffEntry
+	for _, e := range s.Entries {
+		if e.Change != diffSame {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Same returns the entries that are identical in both captures.
+func (s diffSection) Same() []diffEntry {
+	var out []diffEntry
+	for _, e := range s.Entries {
+		if e.Change == diffSame {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+type diffLine struct {
+	Op   string `json:"op"` // " ", "+" or "-"
+	Text string `json:"text"`
+}
+
+type bodyDiff struct {
+	Equal      bool       `json:"equal"`
+	ATruncated bool       `json:"a_truncated,omitempty"`
+	BTruncated bool       `json:"b_truncated,omitempty"`
+	Lines      []diffLine `json:"lines,omitempty"`
+}
+
+type diffSide struct {
+	ID         string    `json:"id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Method     string    `json:"method"`
+	RequestURI string    `json:"request_uri"`
+}
+
+// reflectionDiff compares two HTTP captures.
+type reflectionDiff struct {
+	A        diffSide      `json:"a"`
+	B        diffSide      `json:"b"`
+	Changes  int           `json:"changes"`
+	Sections []diffSection `json:"sections"`
+	Body     bodyDiff      `json:"body"`
+}
+
+func diffReflections(a, b *reflection) reflectionDiff {
+	d := reflectionDiff{
+		A: diffSide{Timestamp: a.Timestamp, Method: a.Method, RequestURI: a.RequestURI},
+		B: diffSide{Timestamp: b.Timestamp, Method: b.Method, RequestURI: b.RequestURI},
+		Sections: []diffSection{
+			{Name: "Request", Entries: diffMaps(requestFields(a), requestFields(b))},
+			{Name: "Headers", Entries: diffMaps(a.Headers, b.Headers)},
+			{Name: "Query", Entries: diffMaps(a.Query, b.Query)},
+			{Name: "Cookies", Entries: diffMaps(cookieFields(a.Cookies), cookieFields(b.Cookies))},
+			{Name: "TLS", Entries: diffMaps(tlsFields(a.TLS), tlsFields(b.TLS))},
+		},
+		Body: diffBodies(a, b),
+	}
+	if a.Upstream != nil || b.Upstream != nil {
+		d.Sections = append(d.Sections,
+			diffSection{Name: "Upstream", Entries: diffMaps(upstreamFields(a.Upstream), upstreamFields(b.Upstream))},
+			diffSection{Name: "Upstream headers", Entries: diffMaps(upstreamHeaders(a.Upstream), upstreamHeaders(b.Upstream))},
+		)
+	}
+	for _, s := range d.Sections {
+		d.Changes += len(s.Changed())
+	}
+	if !d.Body.Equal {
+		d.Changes++
+	}
+	return d
+}
+
+// diffMaps compares multi-valued fields by name; value order matters.
+func diffMaps(a, b map[string][]string) []diffEntry {
+	names := make([]string, 0, len(a)+len(b))
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	out := make([]diffEntry, 0, len(names))
+	for _, name := range names {
+		av, inA := a[name]
+		bv, inB := b[name]
+		e := diffEntry{Name: name, A: av, B: bv}
+		switch {
+		case !inA:
+			e.Change = diffAdded
+		case !inB:
+			e.Change = diffRemoved
+		case slices.Equal(av, bv):
+			e.Change = diffSame
+		default:
+			e.Change = diffChanged
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func requestFields(r *reflection) map[string][]string {
+	return map[string][]string{
+		"Method":      {r.Method},
+		"Protocol":    {r.Proto},
+		"Scheme":      {r.Scheme},
+		"Host":        {r.Host},
+		"Request URI": {r.RequestURI},
+		"Client IP":   {r.RemoteIP},
+	}
+}
+
+func cookieFields(cookies []cookieDetails) map[string][]string {
+	out := make(map[string][]string, len(cookies))
+	for _, c := range cookies {
+		out[c.Name] = append(out[c.Name], c.Value)
+	}
+	return out
+}
+
+// tlsFields is empty for plain HTTP, so every field shows as added or
+// removed when only one capture used TLS.
+func tlsFields(t *tlsDetails) map[string][]string {
+	if t == nil {
+		return nil
+	}
+	return map[string][]string{
+		"Version":      {t.Version},
+		"Cipher Suite": {t.CipherSuite},
+		"Server Name":  {t.ServerName},
+		"ALPN":         {t.Negotiated},
+	}
+}
+
+// upstreamFields summarises the upstream side of a capture made in tee mode.
+func upstreamFields(u *upstreamExchange) map[string][]string {
+	if u == nil {
+		return nil
+	}
+	fields := map[string][]string{
+		"URL":  {u.URL},
+		"Host": {u.Host},
+	}
+	if u.Status != 0 {
+		fields["Status"] = []string{strconv.Itoa(u.Status) + " " + u.StatusText}
+	}
+	if u.Error != "" {
+		fields["Error"] = []string{u.Error}
+	}
+	return fields
+}
+
+func upstreamHeaders(u *upstreamExchange) map[string][]string {
+	if u == nil {
+		return nil
+	}
+	return u.Headers
+}
+
+func diffBodies(a, b *reflection) bodyDiff {
+	d := bodyDiff{
+		Equal:      a.BodyPreview == b.BodyPreview,
+		ATruncated: a.BodyTruncated,
+		BTruncated: b.BodyTruncated,
+	}
+	if !d.Equal {
+		d.Lines = diffLines(splitLines(a.BodyPreview), splitLines(b.BodyPreview))
+	}
+	return d
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines is a longest-common-subsequence line diff, which is plenty for
+// the body sizes reflector captures.
+func diffLines(a, b []string) []diffLine {
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		out := make([]diffLine, 0, len(a)+len(b))
+		for _, l := range a {
+			out = append(out, diffLine{Op: "-", Text: l})
+		}
+		for _, l := range b {
+			out = append(out, diffLine{Op: "+", Text: l})
+		}
+		return out
+	}
+	// lcs[i][j] is the LCS length of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var out []diffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, diffLine{Op: " ", Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, diffLine{Op: "-", Text: a[i]})
+			i++
+		default:
+			out = append(out, diffLine{Op: "+", Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, diffLine{Op: "-", Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		out = append(out, diffLine{Op: "+", Text: b[j]})
+	}
+	return out
+}
+
+type diffPage struct {
+	A, B     string
+	Captures []capture
+	Diff     *reflectionDiff
+}
+
+// diffPicker is the data for one capture selector on the diff page.
+type diffPicker struct {
+	Name     string
+	Selected string
+	Captures []capture
+}
+
+// Picker returns the selector for the "a" or "b" parameter.
+func (p diffPage) Picker(name string) diffPicker {
+	selected := p.A
+	if name == "b" {
+		selected = p.B
+	}
+	return diffPicker{Name: name, Selected: selected, Captures: p.Captures}
+}
+
+// diffHandler serves /diff?a={capture}&b={capture}. Without both IDs it
+// shows pickers for the captures in history.
+func (s *Server) diffHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	asJSON := q.Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json")
+	page := diffPage{A: strings.TrimSpace(q.Get("a")), B: strings.TrimSpace(q.Get("b"))}
+
+	if page.A != "" && page.B != "" {
+		a, okA := s.history.get(page.A)
+		b, okB := s.history.get(page.B)
+		if !okA || !okB {
+			http.Error(w, "capture not found", http.StatusNotFound)
+			return
+		}
+		if a.HTTP == nil || b.HTTP == nil {
+			http.Error(w, "only HTTP captures can be compared", http.StatusBadRequest)
+			return
+		}
+		d := diffReflections(a.HTTP, b.HTTP)
+		d.A.ID, d.B.ID = a.ID, b.ID
+		page.Diff = &d
+	} else if asJSON {
+		http.Error(w, "a and b capture ids are required", http.StatusBadRequest)
+		return
+	}
+
+	if asJSON {
+		s.writeJSON(w, http.StatusOK, page.Diff)
+		return
+	}
+	page.Captures = s.history.list(captureHTTP)
+	s.renderPage(w, r, http.StatusOK, "diff", page)
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDiffMaps(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b map[string][]string
+		want string
+	}{
+		{name: "both empty", want: ""},
+		{
+			name: "every kind",
+			a:    map[string][]string{"Accept": {"*/*"}, "X-Old": {"1"}, "X-Multi": {"a", "b"}},
+			b:    map[string][]string{"Accept": {"*/*"}, "X-New": {"2"}, "X-Multi": {"b", "a"}},
+			want: "Accept same [*/*] [*/*]; X-Multi changed [a b] [b a]; X-New added [] [2]; X-Old removed [1] []",
+		},
+		{
+			name: "one side missing",
+			a:    nil,
+			b:    map[string][]string{"Version": {"TLS 1.3"}},
+			want: "Version added [] [TLS 1.3]",
+		},
+		{
+			name: "empty value differs from missing",
+			a:    map[string][]string{"X": {}},
+			b:    map[string][]string{"X": {""}},
+			want: "X changed [] []",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range diffMaps(tt.a, tt.b) {
+				got = append(got, fmt.Sprintf("%s %s %v %v", e.Name, e.Change, e.A, e.B))
+			}
+			if strings.Join(got, "; ") != tt.want {
+				t.Errorf("got  %s\nwant %s", strings.Join(got, "; "), tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"identical", "a\nb", "a\nb", "  a|  b"},
+		{"insert", "a\nc", "a\nb\nc", "  a|+ b|  c"},
+		{"delete", "a\nb\nc", "a\nc", "  a|- b|  c"},
+		{"change", "a\nb\nc", "a\nB\nc", "  a|- b|+ B|  c"},
+		{"from empty", "", "x\ny", "+ x|+ y"},
+		{"to empty", "x\ny", "", "- x|- y"},
+		{"trailing newline ignored", "a\n", "a", "  a"},
+		{"json field reordered", `{` + "\n" + `"a":1,` + "\n" + `"b":2` + "\n" + `}`, `{` + "\n" + `"b":2,` + "\n" + `"a":1` + "\n" + `}`, `  {|- "a":1,|- "b":2|+ "b":2,|+ "a":1|  }`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatDiffLines(diffLines(splitLines(tt.a), splitLines(tt.b))); got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesFallback(t *testing.T) {
+	// 1024 lines on each side needs more than maxDiffCells table cells.
+	var a, b []string
+	for i := 0; i < 1024; i++ {
+		a = append(a, fmt.Sprint("line ", i))
+		b = append(b, fmt.Sprint("line ", i))
+	}
+	b[512] = "changed"
+	if (len(a)+1)*(len(b)+1) <= maxDiffCells {
+		t.Fatal("inputs do not exceed maxDiffCells")
+	}
+	got := diffLines(a, b)
+	if len(got) != len(a)+len(b) {
+		t.Fatalf("got %d lines, want every line removed and added", len(got))
+	}
+	for i, l := range got {
+		want := diffLine{Op: "-", Text: a[i%len(a)]}
+		if i >= len(a) {
+			want = diffLine{Op: "+", Text: b[i-len(a)]}
+		}
+		if l != want {
+			t.Fatalf("line %d = %+v, want %+v", i, l, want)
+		}
+	}
+
+	// Just under the limit the real diff is used.
+	short := diffLines(a[:1000], b[:1000])
+	if len(short) != 1001 {
+		t.Errorf("LCS diff of 1000 lines has %d lines, want 1001", len(short))
+	}
+}
+
+func formatDiffLines(lines []diffLine) string {
+	parts := make([]string, len(lines))
+	for i, l := range lines {
+		parts[i] = l.Op + " " + l.Text
+	}
+	return strings.Join(parts, "|")
+}
+
+func diffTestReflections() (reflection, reflection) {
+	a := reflection{
+		Method: http.MethodPost, Proto: "HTTP/1.1", Scheme: "https", Host: "example.com", RequestURI: "/api?v=1", RemoteIP: "192.0.2.1",
+		Headers:       map[string][]string{"Accept": {"*/*"}, "X-Edge": {"ams"}},
+		Query:         map[string][]string{"v": {"1"}},
+		Cookies:       []cookieDetails{{Name: "theme", Value: "dark"}},
+		TLS:           &tlsDetails{Version: "TLS 1.3", CipherSuite: "TLS_AES_128_GCM_SHA256", ServerName: "example.com", Negotiated: "h2"},
+		BodyPreview:   "one\ntwo\n",
+		BodyTruncated: true,
+	}
+	b := reflection{
+		Method: http.MethodPost, Proto: "HTTP/1.1", Scheme: "http", Host: "example.com", RequestURI: "/api?v=2", RemoteIP: "192.0.2.1",
+		Headers:     map[string][]string{"Accept": {"*/*"}, "X-Forwarded-For": {"192.0.2.1"}},
+		Query:       map[string][]string{"v": {"2"}},
+		Cookies:     []cookieDetails{{Name: "theme", Value: "dark"}},
+		BodyPreview: "one\nthree\n",
+	}
+	return a, b
+}
+
+func TestDiffReflections(t *testing.T) {
+	a, b := diffTestReflections()
+	d := diffReflections(&a, &b)
+
+	changed := map[string][]string{}
+	for _, s := range d.Sections {
+		for _, e := range s.Changed() {
+			changed[s.Name] = append(changed[s.Name], e.Name+" "+e.Change)
+		}
+	}
+	want := map[string]string{
+		"Request": "Request URI changed,Scheme changed",
+		"Headers": "X-Edge removed,X-Forwarded-For added",
+		"Query":   "v changed",
+		"TLS":     "ALPN removed,Cipher Suite removed,Server Name removed,Version removed",
+	}
+	for section, w := range want {
+		if got := strings.Join(changed[section], ","); got != w {
+			t.Errorf("%s: %s, want %s", section, got, w)
+		}
+	}
+	if _, ok := changed["Cookies"]; ok {
+		t.Errorf("cookies changed: %v", changed["Cookies"])
+	}
+	for _, s := range d.Sections {
+		if strings.HasPrefix(s.Name, "Upstream") {
+			t.Errorf("upstream section %q without tee captures", s.Name)
+		}
+	}
+	if d.Body.Equal || !d.Body.ATruncated || d.Body.BTruncated || formatDiffLines(d.Body.Lines) != "  one|- two|+ three" {
+		t.Errorf("body %+v", d.Body)
+	}
+	// Two request fields, two headers, one query, four TLS and the body.
+	if d.Changes != 10 {
+		t.Errorf("Changes = %d, want 10", d.Changes)
+	}
+
+	same := diffReflections(&a, &a)
+	if same.Changes != 0 || !same.Body.Equal || same.Body.Lines != nil {
+		t.Errorf("capture compared with itself: %+v", same)
+	}
+
+	a.Upstream = &upstreamExchange{URL: "http://origin/api", Host: "origin", Status: 200, StatusText: "OK", Headers: map[string][]string{"Server": {"nginx"}}}
+	b.Upstream = &upstreamExchange{URL: "http://origin/api", Host: "origin", Error: "connection refused"}
+	d = diffReflections(&a, &b)
+	upstream := map[string]string{}
+	for _, s := range d.Sections {
+		if strings.HasPrefix(s.Name, "Upstream") {
+			var names []string
+			for _, e := range s.Changed() {
+				names = append(names, e.Name+" "+e.Change)
+			}
+			upstream[s.Name] = strings.Join(names, ",")
+		}
+	}
+	if upstream["Upstream"] != "Error added,Status removed" || upstream["Upstream headers"] != "Server removed" {
+		t.Errorf("upstream sections %v", upstream)
+	}
+}
+
+func TestDiffHandler(t *testing.T) {
+	s := New(4096, WithLogger(discardLogger()))
+	h := s.Handler()
+	a, b := diffTestReflections()
+	idA, idB := storeCapture(s, a), storeCapture(s, b)
+	dnsID := s.history.add(capture{Kind: captureDNS, DNS: &dnsQuery{}})
+
+	get := func(target, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		accept string
+		status int
+	}{
+		{"picker", "/diff", "", http.StatusOK},
+		{"html diff", "/diff?a=" + idA + "&b=" + idB, "", http.StatusOK},
+		{"json without ids", "/diff?format=json&a=" + idA, "", http.StatusBadRequest},
+		{"unknown capture", "/diff?a=" + idA + "&b=nope", "", http.StatusNotFound},
+		{"dns capture", "/diff?a=" + idA + "&b=" + dnsID, "", http.StatusBadRequest},
+		{"dns capture as json", "/diff?a=" + dnsID + "&b=" + idB, "application/json", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := get(tt.target, tt.accept); w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+	if w := get("/diff?a="+idA+"&b="+idB, ""); !strings.Contains(w.Body.String(), "X-Forwarded-For") {
+		t.Error("HTML diff does not list the changed header")
+	}
+
+	for _, target := range []string{"/diff?format=json&a=" + idA + "&b=" + idB, "/diff?a=" + idA + "&b=" + idB} {
+		accept := ""
+		if !strings.Contains(target, "format=json") {
+			accept = "application/json"
+		}
+		w := get(target, accept)
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			t.Fatalf("%s: status %d, Content-Type %q", target, w.Code, w.Header().Get("Content-Type"))
+		}
+		var got struct {
+			A struct {
+				ID         string `json:"id"`
+				Method     string `json:"method"`
+				RequestURI string `json:"request_uri"`
+			} `json:"a"`
+			B        struct{ ID string } `json:"b"`
+			Changes  int                 `json:"changes"`
+			Sections []struct {
+				Name    string `json:"name"`
+				Entries []struct {
+					Name   string   `json:"name"`
+					Change string   `json:"change"`
+					A      []string `json:"a"`
+					B      []string `json:"b"`
+				} `json:"entries"`
+			} `json:"sections"`
+			Body struct {
+				Equal      bool `json:"equal"`
+				ATruncated bool `json:"a_truncated"`
+				Lines      []struct {
+					Op   string `json:"op"`
+					Text string `json:"text"`
+				} `json:"lines"`
+			} `json:"body"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.A.ID != idA || got.B.ID != idB || got.A.Method != http.MethodPost || got.A.RequestURI != "/api?v=1" {
+			t.Errorf("sides %+v %+v", got.A, got.B)
+		}
+		if got.Changes != 10 || len(got.Sections) != 5 || got.Sections[1].Name != "Headers" {
+			t.Errorf("changes %d, %d sections", got.Changes, len(got.Sections))
+		}
+		if got.Body.Equal || !got.Body.ATruncated || len(got.Body.Lines) != 3 || got.Body.Lines[1].Op != "-" {
+			t.Errorf("body %+v", got.Body)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/diff", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d", w.Code)
+	}
+}
@@ -0,0 +1,423 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	dnsMaxUDPSize     = 512
+	dnsAdvertisedSize = 1232
+	dnsTCPTimeout     = 30 * time.Second
+	ednsOptionECS     = 8
+	ednsFlagDNSSEC    = 0x8000
+	dnsMaxTXTString   = 255
+)
+
+// dnsQuery describes a single query as it reached reflector.
+type dnsQuery struct {
+	Transport    string       `json:"transport"`
+	ResolverAddr string       `json:"resolver_addr"`
+	ResolverIP   string       `json:"resolver_ip"`
+	LocalAddr    string       `json:"local_addr"`
+	ID           uint16       `json:"id"`
+	Name         string       `json:"name"`
+	Type         string       `json:"type"`
+	Class        string       `json:"class"`
+	MixedCase    bool         `json:"mixed_case"`
+	Recursion    bool         `json:"recursion_desired"`
+	CheckingOff  bool         `json:"checking_disabled"`
+	EDNS         *ednsDetails `json:"edns,omitempty"`
+	Error        string       `json:"error,omitempty"`
+	Answers      []string     `json:"answers,omitempty"`
+	ReceivedAt   time.Time    `json:"received_at"`
+	Truncated    bool         `json:"truncated,omitempty"`
+	DurationMS   float64      `json:"duration_ms"`
+}
+
+type ednsDetails struct {
+	Version      int           `json:"version"`
+	UDPSize      int           `json:"udp_size"`
+	DNSSECOK     bool          `json:"dnssec_ok"`
+	ClientSubnet *clientSubnet `json:"client_subnet,omitempty"`
+	Options      []string      `json:"options,omitempty"`
+}
+
+// clientSubnet is the EDNS Client Subnet option from RFC 7871.
+type clientSubnet struct {
+	Family       int    `json:"family"`
+	SourcePrefix int    `json:"source_prefix"`
+	ScopePrefix  int    `json:"scope_prefix"`
+	Address      string `json:"address"`
+}
+
+// ServeDNS answers queries arriving on pc. Every question, whatever its type,
+// is answered with TXT records describing the querying resolver.
+func (s *Server) ServeDNS(pc net.PacketConn) error {
+	buf := make([]byte, udpMaxDatagram)
+	for {
+		n, peer, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		resp := s.answerDNS(buf[:n], "udp", peer, pc.LocalAddr())
+		if resp == nil {
+			continue
+		}
+		if _, err := pc.WriteTo(resp, peer); err != nil {
+			s.logger.Warn("dns write failed", "peer", peer.String(), "err", err)
+		}
+	}
+}
+
+// ServeDNSTCP is the TCP counterpart of ServeDNS, using the two byte length
+// framing from RFC 1035 section 4.2.2.
+func (s *Server) ServeDNSTCP(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.handleDNSTCP(conn)
+	}
+}
+
+func (s *Server) handleDNSTCP(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for {
+		_ = conn.SetDeadline(time.Now().Add(dnsTCPTimeout))
+		var length [2]byte
+		if _, err := io.ReadFull(br, length[:]); err != nil {
+			return
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(br, msg); err != nil {
+			return
+		}
+		resp := s.answerDNS(msg, "tcp", conn.RemoteAddr(), conn.LocalAddr())
+		if resp == nil {
+			return
+		}
+		framed := make([]byte, 2, 2+len(resp))
+		binary.BigEndian.PutUint16(framed, uint16(len(resp)))
+		if _, err := conn.Write(append(framed, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+// answerDNS parses msg, records it in the history and builds the response.
+// It returns nil when the message is too malformed to answer.
+func (s *Server) answerDNS(msg []byte, transport string, peer, local net.Addr) []byte {
+	query := &dnsQuery{
+		Transport:    transport,
+		ResolverAddr: peer.String(),
+		ResolverIP:   addrIP(peer),
+		LocalAddr:    local.String(),
+		ReceivedAt:   time.Now().UTC(),
+	}
+	defer func() {
+		query.DurationMS = msSince(query.ReceivedAt)
+		s.history.add(capture{Kind: captureDNS, Timestamp: query.ReceivedAt, DNS: query})
+		s.logger.Info("dns query",
+			"transport", transport,
+			"type", query.Type,
+			"name", query.Name,
+			"resolver", query.ResolverAddr,
+			"duration_ms", query.DurationMS,
+		)
+	}()
+
+	var parser dnsmessage.Parser
+	header, err := parser.Start(msg)
+	if err != nil {
+		query.Error = err.Error()
+		return nil
+	}
+	query.ID = header.ID
+	query.Recursion = header.RecursionDesired
+	query.CheckingOff = header.CheckingDisabled
+
+	question, err := parser.Question()
+	if err != nil {
+		query.Error = err.Error()
+		if header.Response {
+			return nil
+		}
+		return dnsError(header, nil, dnsmessage.RCodeFormatError)
+	}
+	query.Name = question.Name.String()
+	query.Type = strings.TrimPrefix(question.Type.String(), "Type")
+	query.Class = strings.TrimPrefix(question.Class.String(), "Class")
+	query.MixedCase = hasMixedCase(query.Name)
+
+	if err := parser.SkipAllQuestions(); err == nil {
+		if err := parser.SkipAllAnswers(); err == nil {
+			if err := parser.SkipAllAuthorities(); err == nil {
+				query.EDNS = parseEDNS(&parser)
+			}
+		}
+	}
+	// Answering a response would let two servers, or a spoofed source,
+	// bounce messages between each other forever.
+	if header.Response {
+		query.Error = "unexpected response message"
+		return nil
+	}
+	if header.OpCode != 0 {
+		query.Error = "not a standard query"
+		return dnsError(header, &question, dnsmessage.RCodeNotImplemented)
+	}
+
+	query.Answers = dnsAnswerStrings(query)
+	// Honour a larger EDNS buffer, but never beyond what reflector itself
+	// advertises, which also bounds the reply to a spoofed source.
+	limit := dnsMaxUDPSize
+	if query.EDNS != nil && query.EDNS.UDPSize > limit {
+		limit = min(query.EDNS.UDPSize, dnsAdvertisedSize)
+	}
+	resp, err := buildDNSResponse(header, question, query, true)
+	if err == nil && transport == "udp" && len(resp) > limit {
+		query.Truncated = true
+		resp, err = buildDNSResponse(header, question, query, false)
+	}
+	if err != nil {
+		query.Error = err.Error()
+		return dnsError(header, &question, dnsmessage.RCodeServerFailure)
+	}
+	return resp
+}
+
+func buildDNSResponse(reqHeader dnsmessage.Header, question dnsmessage.Question, query *dnsQuery, withAnswers bool) ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 reqHeader.ID,
+		Response:           true,
+		Authoritative:      true,
+		Truncated:          !withAnswers,
+		RecursionDesired:   reqHeader.RecursionDesired,
+		RecursionAvailable: false,
+		CheckingDisabled:   reqHeader.CheckingDisabled,
+		RCode:              dnsmessage.RCodeSuccess,
+	})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(question); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	if withAnswers {
+		rrHeader := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 0}
+		for _, answer := range query.Answers {
+			if err := b.TXTResource(rrHeader, dnsmessage.TXTResource{TXT: splitTXT(answer)}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if query.EDNS != nil {
+		if err := b.StartAdditionals(); err != nil {
+			return nil, err
+		}
+		var opt dnsmessage.ResourceHeader
+		if err := opt.SetEDNS0(dnsAdvertisedSize, dnsmessage.RCodeSuccess, query.EDNS.DNSSECOK); err != nil {
+			return nil, err
+		}
+		var options []dnsmessage.Option
+		if ecs := query.EDNS.ClientSubnet; ecs != nil {
+			// RFC 7871: echo family, source prefix and address; a scope of
+			// zero says the answer is valid for every client.
+			options = append(options, dnsmessage.Option{Code: ednsOptionECS, Data: encodeClientSubnet(ecs)})
+		}
+		if err := b.OPTResource(opt, dnsmessage.OPTResource{Options: options}); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+func dnsError(reqHeader dnsmessage.Header, question *dnsmessage.Question, rcode dnsmessage.RCode) []byte {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:               reqHeader.ID,
+		Response:         true,
+		OpCode:           reqHeader.OpCode,
+		RecursionDesired: reqHeader.RecursionDesired,
+		RCode:            rcode,
+	})
+	if question != nil {
+		if err := b.StartQuestions(); err == nil {
+			_ = b.Question(*question)
+		}
+	}
+	resp, err := b.Finish()
+	if err != nil {
+		return nil
+	}
+	return resp
+}
+
+func parseEDNS(parser *dnsmessage.Parser) *ednsDetails {
+	for {
+		rh, err := parser.AdditionalHeader()
+		if err != nil {
+			return nil
+		}
+		if rh.Type != dnsmessage.TypeOPT {
+			if err := parser.SkipAdditional(); err != nil {
+				return nil
+			}
+			continue
+		}
+		opt, err := parser.OPTResource()
+		if err != nil {
+			return nil
+		}
+		edns := &ednsDetails{
+			Version:  int(rh.TTL>>16) & 0xff,
+			UDPSize:  int(rh.Class),
+			DNSSECOK: rh.TTL&ednsFlagDNSSEC != 0,
+		}
+		for _, o := range opt.Options {
+			if o.Code == ednsOptionECS {
+				if ecs, err := parseClientSubnet(o.Data); err == nil {
+					edns.ClientSubnet = ecs
+					continue
+				}
+			}
+			edns.Options = append(edns.Options, ednsOptionName(o.Code))
+		}
+		return edns
+	}
+}
+
+func parseClientSubnet(data []byte) (*clientSubnet, error) {
+	if len(data) < 4 {
+		return nil, errors.New("short client subnet option")
+	}
+	ecs := &clientSubnet{
+		Family:       int(binary.BigEndian.Uint16(data)),
+		SourcePrefix: int(data[2]),
+		ScopePrefix:  int(data[3]),
+	}
+	var ip net.IP
+	switch ecs.Family {
+	case 1:
+		ip = make(net.IP, net.IPv4len)
+	case 2:
+		ip = make(net.IP, net.IPv6len)
+	default:
+		return nil, fmt.Errorf("unknown client subnet family %d", ecs.Family)
+	}
+	addr := data[4:]
+	if len(addr) > len(ip) || ecs.SourcePrefix > len(ip)*8 {
+		return nil, errors.New("malformed client subnet option")
+	}
+	copy(ip, addr)
+	ecs.Address = ip.String()
+	return ecs, nil
+}
+
+func encodeClientSubnet(ecs *clientSubnet) []byte {
+	ip := net.ParseIP(ecs.Address)
+	if ecs.Family == 1 {
+		ip = ip.To4()
+	}
+	addr := ip[:(ecs.SourcePrefix+7)/8]
+	out := make([]byte, 4, 4+len(addr))
+	binary.BigEndian.PutUint16(out, uint16(ecs.Family))
+	out[2] = byte(ecs.SourcePrefix)
+	out[3] = 0
+	return append(out, addr...)
+}
+
+// dnsAnswerStrings renders the query details as key=value strings, one per
+// TXT record, in a stable order so dig output is easy to scan.
+func dnsAnswerStrings(q *dnsQuery) []string {
+	out := []string{
+		"resolver=" + q.ResolverIP,
+		"transport=" + q.Transport,
+		"qname=" + q.Name,
+		"qtype=" + q.Type,
+		"0x20=" + strconv.FormatBool(q.MixedCase),
+		"rd=" + strconv.FormatBool(q.Recursion),
+	}
+	if q.EDNS == nil {
+		return append(out, "edns=none")
+	}
+	out = append(out,
+		fmt.Sprintf("edns=version:%d udp_size:%d", q.EDNS.Version, q.EDNS.UDPSize),
+		"do="+strconv.FormatBool(q.EDNS.DNSSECOK),
+	)
+	if ecs := q.EDNS.ClientSubnet; ecs != nil {
+		out = append(out, fmt.Sprintf("ecs=%s/%d scope:%d", ecs.Address, ecs.SourcePrefix, ecs.ScopePrefix))
+	} else {
+		out = append(out, "ecs=none")
+	}
+	if len(q.EDNS.Options) > 0 {
+		out = append(out, "edns_options="+strings.Join(q.EDNS.Options, ","))
+	}
+	return out
+}
+
+func splitTXT(s string) []string {
+	var out []string
+	for len(s) > dnsMaxTXTString {
+		out = append(out, s[:dnsMaxTXTString])
+		s = s[dnsMaxTXTString:]
+	}
+	return append(out, s)
+}
+
+// hasMixedCase reports whether name contains both upper and lower case
+// letters, which is what resolvers using 0x20 encoding send.
+func hasMixedCase(name string) bool {
+	var upper, lower bool
+	for _, r := range name {
+		upper = upper || unicode.IsUpper(r)
+		lower = lower || unicode.IsLower(r)
+	}
+	return upper && lower
+}
+
+func ednsOptionName(code uint16) string {
+	switch code {
+	case 3:
+		return "NSID"
+	case 10:
+		return "COOKIE"
+	case 11:
+		return "TCP_KEEPALIVE"
+	case 12:
+		return "PADDING"
+	case 15:
+		return "EDE"
+	default:
+		return fmt.Sprintf("OPT%d", code)
+	}
+}
+
+func addrIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
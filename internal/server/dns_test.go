@@ -0,0 +1,252 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var (
+	testResolver = &net.UDPAddr{IP: net.ParseIP("192.0.2.53"), Port: 5353}
+	testDNSLocal = &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 53}
+)
+
+// dnsTestQuery builds a TXT query for name. A non-nil opt adds an OPT record
+// advertising udpSize.
+func dnsTestQuery(t testing.TB, name string, udpSize int, opt *dnsmessage.OPTResource) []byte {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 0x1234, RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  dnsmessage.TypeTXT,
+		Class: dnsmessage.ClassINET,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if opt != nil {
+		if err := b.StartAdditionals(); err != nil {
+			t.Fatal(err)
+		}
+		var rh dnsmessage.ResourceHeader
+		if err := rh.SetEDNS0(udpSize, dnsmessage.RCodeSuccess, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.OPTResource(rh, *opt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// parseDNSResponse returns the header and TXT answers of resp.
+func parseDNSResponse(t *testing.T, resp []byte) (dnsmessage.Header, []string) {
+	t.Helper()
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		t.Fatalf("parse response: %v", err)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		t.Fatal(err)
+	}
+	var answers []string
+	for {
+		rh, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			return h, answers
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rh.Type != dnsmessage.TypeTXT {
+			t.Fatalf("answer of type %v", rh.Type)
+		}
+		txt, err := p.TXTResource()
+		if err != nil {
+			t.Fatal(err)
+		}
+		answers = append(answers, strings.Join(txt.TXT, ""))
+	}
+}
+
+func TestAnswerDNS(t *testing.T) {
+	s := New(4096)
+	ecs := dnsmessage.Option{Code: ednsOptionECS, Data: []byte{0, 1, 24, 0, 203, 0, 113}}
+	msg := dnsTestQuery(t, "WhoAmI.example.", 1232, &dnsmessage.OPTResource{Options: []dnsmessage.Option{ecs}})
+
+	h, answers := parseDNSResponse(t, s.answerDNS(msg, "udp", testResolver, testDNSLocal))
+	if h.ID != 0x1234 || !h.Response || h.RCode != dnsmessage.RCodeSuccess || h.Truncated {
+		t.Errorf("header %+v", h)
+	}
+	want := []string{
+		"resolver=192.0.2.53",
+		"transport=udp",
+		"qname=WhoAmI.example.",
+		"qtype=TXT",
+		"0x20=true",
+		"rd=true",
+		"edns=version:0 udp_size:1232",
+		"do=false",
+		"ecs=203.0.113.0/24 scope:0",
+	}
+	if strings.Join(answers, "\n") != strings.Join(want, "\n") {
+		t.Errorf("answers:\n%s\nwant:\n%s", strings.Join(answers, "\n"), strings.Join(want, "\n"))
+	}
+	if c := s.history.list(captureDNS); len(c) != 1 || c[0].DNS.Name != "WhoAmI.example." {
+		t.Errorf("history has %+v", c)
+	}
+}
+
+func TestAnswerDNSMalformed(t *testing.T) {
+	valid := dnsTestQuery(t, "example.", 0, nil)
+	response := append([]byte(nil), valid...)
+	response[2] |= 0x80
+	responseNoQuestion := append([]byte(nil), response[:12]...)
+	notify := append([]byte(nil), valid...)
+	notify[2] |= 4 << 3
+
+	tests := []struct {
+		name  string
+		msg   []byte
+		rcode dnsmessage.RCode
+		nilOK bool
+	}{
+		{name: "empty", msg: nil, nilOK: true},
+		{name: "short header", msg: valid[:11], nilOK: true},
+		{name: "missing question", msg: valid[:12], rcode: dnsmessage.RCodeFormatError},
+		{name: "truncated question", msg: valid[:len(valid)-2], rcode: dnsmessage.RCodeFormatError},
+		{name: "response bit set", msg: response, nilOK: true},
+		{name: "response without question", msg: responseNoQuestion, nilOK: true},
+		{name: "non-query opcode", msg: notify, rcode: dnsmessage.RCodeNotImplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(4096)
+			resp := s.answerDNS(tt.msg, "udp", testResolver, testDNSLocal)
+			if tt.nilOK {
+				if resp != nil {
+					t.Errorf("answered %d bytes, want no reply", len(resp))
+				}
+			} else {
+				h, answers := parseDNSResponse(t, resp)
+				if h.RCode != tt.rcode || len(answers) != 0 {
+					t.Errorf("rcode %v with %d answers, want %v", h.RCode, len(answers), tt.rcode)
+				}
+			}
+			if c := s.history.list(captureDNS); len(c) != 1 || c[0].DNS.Error == "" {
+				t.Errorf("history has %+v, want the error recorded", c)
+			}
+		})
+	}
+}
+
+func TestAnswerDNSTruncation(t *testing.T) {
+	// Enough unknown options to push the edns_options answer past the
+	// advertised 1232 bytes.
+	var opt dnsmessage.OPTResource
+	for code := uint16(65000); code < 65150; code++ {
+		opt.Options = append(opt.Options, dnsmessage.Option{Code: code})
+	}
+	tests := []struct {
+		name      string
+		udpSize   int
+		opt       *dnsmessage.OPTResource
+		transport string
+		truncated bool
+	}{
+		{"small answer", 0, nil, "udp", false},
+		{"large answer, 4096 advertised", 4096, &opt, "udp", true},
+		{"large answer, 65535 advertised", 65535, &opt, "udp", true},
+		{"large answer over tcp", 4096, &opt, "tcp", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := dnsTestQuery(t, "example.", tt.udpSize, tt.opt)
+			resp := New(4096).answerDNS(msg, tt.transport, testResolver, testDNSLocal)
+			h, answers := parseDNSResponse(t, resp)
+			if h.Truncated != tt.truncated {
+				t.Fatalf("TC=%v, want %v (%d bytes)", h.Truncated, tt.truncated, len(resp))
+			}
+			if tt.truncated && (len(answers) != 0 || len(resp) > dnsAdvertisedSize) {
+				t.Errorf("truncated reply has %d answers in %d bytes", len(answers), len(resp))
+			}
+			if !tt.truncated && len(answers) == 0 {
+				t.Error("reply has no answers")
+			}
+		})
+	}
+}
+
+func TestParseClientSubnet(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "ipv4 /24", data: []byte{0, 1, 24, 0, 192, 0, 2}, want: "192.0.2.0/24"},
+		{name: "ipv4 /0", data: []byte{0, 1, 0, 0}, want: "0.0.0.0/0"},
+		{name: "ipv6 /56", data: []byte{0, 2, 56, 0, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0}, want: "2001:db8::/56"},
+		{name: "short", data: []byte{0, 1, 24}, wantErr: true},
+		{name: "unknown family", data: []byte{0, 3, 8, 0, 1}, wantErr: true},
+		{name: "ipv4 prefix too long", data: []byte{0, 1, 33, 0, 1, 2, 3, 4}, wantErr: true},
+		{name: "ipv4 address too long", data: []byte{0, 1, 32, 0, 1, 2, 3, 4, 5}, wantErr: true},
+		{name: "ipv6 prefix too long", data: []byte{0, 2, 129, 0}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ecs, err := parseClientSubnet(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", ecs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ecs.Address + "/" + strconv.Itoa(ecs.SourcePrefix)
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			if round, err := parseClientSubnet(encodeClientSubnet(ecs)); err != nil || *round != *ecs {
+				t.Errorf("re-encoding gave %+v, %v", round, err)
+			}
+		})
+	}
+}
+
+func FuzzAnswerDNS(f *testing.F) {
+	f.Add(dnsTestQuery(f, "example.", 0, nil))
+	f.Add(dnsTestQuery(f, "ExAmPlE.", 4096, &dnsmessage.OPTResource{Options: []dnsmessage.Option{
+		{Code: ednsOptionECS, Data: []byte{0, 2, 48, 0, 0x20, 0x01, 0x0d, 0xb8, 0, 0}},
+		{Code: 10, Data: make([]byte, 8)},
+	}}))
+	f.Add([]byte{0x12, 0x34, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xc0, 0x0c})
+	s := New(4096, WithHistorySize(0))
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		resp := s.answerDNS(msg, "udp", testResolver, testDNSLocal)
+		if resp == nil {
+			return
+		}
+		if len(resp) > dnsAdvertisedSize {
+			t.Fatalf("%d byte UDP reply exceeds %d", len(resp), dnsAdvertisedSize)
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(resp)
+		if err != nil || !h.Response || h.ID != uint16(msg[0])<<8|uint16(msg[1]) {
+			t.Fatalf("invalid reply header %+v: %v", h, err)
+		}
+	})
+}
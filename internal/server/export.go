@@ -0,0 +1,441 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// exportFormat turns a captured request into something that can send it
+// again. The map key doubles as the file extension in export URLs.
+type exportFormat struct {
+	contentType string
+	attachment  bool
+	render      func(*reflection) ([]byte, error)
+}
+
+var exportFormats = map[string]exportFormat{
+	"curl": {contentType: "text/plain; charset=utf-8", render: exportCurl},
+	"har":  {contentType: "application/json", attachment: true, render: exportHAR},
+	"http": {contentType: "text/plain; charset=utf-8", render: exportRaw},
+	"go":   {contentType: "text/plain; charset=utf-8", render: exportGo},
+	"py":   {contentType: "text/plain; charset=utf-8", render: exportPython},
+}
+
+// exportHeaders are request headers that the sending client computes itself
+// and which would be wrong, or duplicated, if copied from the capture.
+var exportHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Host":              true,
+}
+
+// exportHandler serves /export/{capture}.{format}.
+func (s *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ext, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/export/"), ".")
+	if _, ok := exportFormats[ext]; !ok {
+		http.Error(w, "unknown export format", http.StatusNotFound)
+		return
+	}
+	c, ok := s.history.get(id)
+	if !ok || c.HTTP == nil {
+		http.Error(w, "capture not found", http.StatusNotFound)
+		return
+	}
+	s.writeExport(w, c.HTTP, "request-"+id, ext)
+}
+
+func (s *Server) writeExport(w http.ResponseWriter, ref *reflection, name, ext string) {
+	f := exportFormats[ext]
+	out, err := f.render(ref)
+	if err != nil {
+		s.logger.Error("export request", "format", ext, "err", err)
+		http.Error(w, "failed to export request", http.StatusInternalServerError)
+		return
+	}
+	disposition := "inline"
+	if f.attachment {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", f.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, name+"."+ext))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, _ = w.Write(out)
+}
+
+// exportURL rebuilds the absolute URL the client requested.
+func exportURL(ref *reflection) string {
+	scheme := ref.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	uri := ref.RequestURI
+	if u, err := url.ParseRequestURI(uri); err == nil && u.IsAbs() {
+		return uri
+	}
+	return scheme + "://" + ref.Host + uri
+}
+
+type exportHeader struct {
+	Name, Value string
+}
+
+// exportHeaderList flattens the captured headers in name order, dropping the
+// ones the sender computes.
+func exportHeaderList(ref *reflection) []exportHeader {
+	names := make([]string, 0, len(ref.Headers))
+	for name := range ref.Headers {
+		if !exportHeaders[http.CanonicalHeaderKey(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var out []exportHeader
+	for _, name := range names {
+		for _, v := range ref.Headers[name] {
+			out = append(out, exportHeader{Name: name, Value: v})
+		}
+	}
+	return out
+}
+
+// exportNotes lists the ways an export differs from what was received.
+func exportNotes(ref *reflection) []string {
+	var notes []string
+	if ref.BodyTruncated {
+		total := "an unknown number of"
+		if ref.ContentLength >= 0 {
+			total = strconv.FormatInt(ref.ContentLength, 10)
+		}
+		notes = append(notes, fmt.Sprintf("body truncated to the first %d of %s bytes when captured", len(ref.BodyPreview), total))
+	}
+	if len(ref.Redactions) > 0 {
+		names := make([]string, len(ref.Redactions))
+		for i, name := range ref.Redactions {
+			names[i] = commentSafe(name)
+		}
+		notes = append(notes, "redacted values must be filled in: "+strings.Join(names, ", "))
+	}
+	return notes
+}
+
+// commentSafe returns s as is when it is printable ASCII and quoted
+// otherwise. Redaction names come from the request, and a query name with a
+// line break would otherwise end the comment a note is written into and
+// turn the rest of the name into code.
+func commentSafe(s string) string {
+	if q := strconv.QuoteToASCII(s); q[1:len(q)-1] != s {
+		return q
+	}
+	return s
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func exportCurl(ref *reflection) ([]byte, error) {
+	var b strings.Builder
+	for _, note := range exportNotes(ref) {
+		b.WriteString("# " + note + "\n")
+	}
+	b.WriteString("curl")
+	switch {
+	case ref.Method == http.MethodHead:
+		b.WriteString(" --head")
+	case ref.Method != http.MethodGet || ref.BodyPreview != "":
+		b.WriteString(" -X " + shellQuote(ref.Method))
+	}
+	switch ref.Proto {
+	case "HTTP/1.0":
+		b.WriteString(" --http1.0")
+	case "HTTP/1.1":
+		if ref.Scheme == "https" {
+			b.WriteString(" --http1.1")
+		}
+	case "HTTP/2.0":
+		b.WriteString(" --http2")
+	}
+	b.WriteString(" " + shellQuote(exportURL(ref)))
+	for _, h := range exportHeaderList(ref) {
+		b.WriteString(" \\\n  -H " + shellQuote(h.Name+": "+h.Value))
+	}
+	if ref.BodyPreview != "" {
+		b.WriteString(" \\\n  --data-binary " + shellQuote(ref.BodyPreview))
+	}
+	b.WriteString("\n")
+	return []byte(b.String()), nil
+}
+
+// exportRaw writes the request as it would appear on an HTTP/1.1 connection.
+func exportRaw(ref *reflection) ([]byte, error) {
+	target := ref.RequestURI
+	if u, err := url.ParseRequestURI(target); err == nil && u.IsAbs() {
+		target = u.RequestURI()
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\n", ref.Method, target)
+	fmt.Fprintf(&b, "Host: %s\r\n", ref.Host)
+	for _, h := range exportHeaderList(ref) {
+		fmt.Fprintf(&b, "%s: %s\r\n", h.Name, h.Value)
+	}
+	if ref.BodyPreview != "" || ref.ContentLength > 0 {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n", len(ref.BodyPreview))
+	}
+	b.WriteString("\r\n")
+	b.WriteString(ref.BodyPreview)
+	return b.Bytes(), nil
+}
+
+// HAR 1.2, limited to the fields a capture can fill in. The response is the
+// reflection page itself and carries no useful detail.
+type harLog struct {
+	Log struct {
+		Version string     `json:"version"`
+		Creator harCreator `json:"creator"`
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func exportHAR(ref *reflection) ([]byte, error) {
+	req := harRequest{
+		Method:      ref.Method,
+		URL:         exportURL(ref),
+		HTTPVersion: ref.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     []harNameValue{{Name: "Host", Value: ref.Host}},
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    len(ref.BodyPreview),
+	}
+	for _, h := range exportHeaderList(ref) {
+		req.Headers = append(req.Headers, harNameValue{Name: h.Name, Value: h.Value})
+	}
+	for _, c := range ref.Cookies {
+		req.Cookies = append(req.Cookies, harNameValue{Name: c.Name, Value: c.Value})
+	}
+	for _, kv := range mapToPairs(ref.Query) {
+		for _, v := range kv.Values {
+			req.QueryString = append(req.QueryString, harNameValue{Name: kv.Key, Value: v})
+		}
+	}
+	if ref.BodyPreview != "" {
+		mime := ""
+		if v := ref.Headers["Content-Type"]; len(v) > 0 {
+			mime = v[0]
+		}
+		req.PostData = &harPostData{MimeType: mime, Text: ref.BodyPreview}
+	}
+
+	entry := harEntry{
+		StartedDateTime: ref.Timestamp.Format("2006-01-02T15:04:05.000Z07:00"),
+		Request:         req,
+		Response: harResponse{
+			Status:      http.StatusOK,
+			StatusText:  http.StatusText(http.StatusOK),
+			HTTPVersion: ref.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     []harNameValue{},
+			Content:     harContent{MimeType: "text/html; charset=utf-8"},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Comment: strings.Join(exportNotes(ref), "; "),
+	}
+	if ref.Timing != nil {
+		entry.Timings.Send = ref.Timing.BodyReadMS
+		entry.Time = entry.Timings.Send
+	}
+	if ref.Connection != nil {
+		entry.ServerIPAddress = addrHost(ref.Connection.LocalAddr)
+	}
+
+	var har harLog
+	har.Log.Version = "1.2"
+	har.Log.Creator = harCreator{Name: "reflector", Version: buildVersion()}
+	har.Log.Entries = []harEntry{entry}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(har); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func buildVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+const goExportTemplate = `package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+func main() {
+%s	req, err := http.NewRequest(%s, %s, %s)
+	if err != nil {
+		panic(err)
+	}
+%s	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	fmt.Println(resp.Proto, resp.Status)
+	for name, values := range resp.Header {
+		fmt.Printf("%%s: %%s\n", name, strings.Join(values, ", "))
+	}
+	fmt.Println()
+	_, _ = io.Copy(os.Stdout, resp.Body)
+}
+`
+
+func exportGo(ref *reflection) ([]byte, error) {
+	var comment, headers strings.Builder
+	for _, note := range exportNotes(ref) {
+		comment.WriteString("\t// " + note + "\n")
+	}
+	body := "nil"
+	if ref.BodyPreview != "" {
+		body = "strings.NewReader(" + strconv.Quote(ref.BodyPreview) + ")"
+	}
+	for _, h := range exportHeaderList(ref) {
+		fmt.Fprintf(&headers, "\treq.Header.Add(%s, %s)\n", strconv.Quote(h.Name), strconv.Quote(h.Value))
+	}
+	src := fmt.Sprintf(goExportTemplate, comment.String(), strconv.Quote(ref.Method), strconv.Quote(exportURL(ref)), body, headers.String())
+	return format.Source([]byte(src))
+}
+
+func exportPython(ref *reflection) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("import requests\n\n")
+	for _, note := range exportNotes(ref) {
+		b.WriteString("# " + note + "\n")
+	}
+	b.WriteString("url = " + pyString(exportURL(ref)) + "\n")
+	b.WriteString("headers = {\n")
+	// requests takes a dict, so repeated headers are folded the way a
+	// proxy would fold them.
+	var order []string
+	folded := make(map[string][]string)
+	for _, h := range exportHeaderList(ref) {
+		if _, ok := folded[h.Name]; !ok {
+			order = append(order, h.Name)
+		}
+		folded[h.Name] = append(folded[h.Name], h.Value)
+	}
+	for _, name := range order {
+		sep := ", "
+		if name == "Cookie" {
+			sep = "; "
+		}
+		fmt.Fprintf(&b, "    %s: %s,\n", pyString(name), pyString(strings.Join(folded[name], sep)))
+	}
+	b.WriteString("}\n")
+	args := "headers=headers"
+	if ref.BodyPreview != "" {
+		b.WriteString("data = " + pyString(ref.BodyPreview) + "\n")
+		args += ", data=data.encode()"
+	}
+	fmt.Fprintf(&b, "\nresponse = requests.request(%s, url, %s)\n", pyString(ref.Method), args)
+	b.WriteString("print(response.status_code, response.reason)\n")
+	b.WriteString("for name, value in response.headers.items():\n")
+	b.WriteString("    print(f\"{name}: {value}\")\n")
+	b.WriteString("print()\n")
+	b.WriteString("print(response.text)\n")
+	return []byte(b.String()), nil
+}
+
+// pyString quotes s as a Python string literal. JSON string syntax is a
+// subset of Python's.
+func pyString(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestExportNotes(t *testing.T) {
+	tests := []struct {
+		name string
+		ref  reflection
+		want []string
+	}{
+		{"complete", reflection{BodyPreview: "abc", ContentLength: 3}, nil},
+		{
+			"truncated",
+			reflection{BodyPreview: "abc", BodyTruncated: true, ContentLength: 4096},
+			[]string{"body truncated to the first 3 of 4096 bytes when captured"},
+		},
+		{
+			"truncated chunked",
+			reflection{BodyPreview: "abc", BodyTruncated: true, ContentLength: -1, TransferEncoding: []string{"chunked"}},
+			[]string{"body truncated to the first 3 of an unknown number of bytes when captured"},
+		},
+		{
+			"redacted",
+			reflection{Redactions: []string{"header:Authorization", "cookie:session"}},
+			[]string{"redacted values must be filled in: header:Authorization, cookie:session"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := exportNotes(&tt.ref)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportHostileRedactionName(t *testing.T) {
+	policy, err := ParseRedactionPolicy([]byte(`{"query": ["*"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := "\nos.system('id') # \r\u2028"
+	tests := []struct {
+		name      string
+		bodyCap   int
+		truncated bool
+	}{
+		{"body", 4096, false},
+		{"truncated body", 8, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(tt.bodyCap, WithRedactionPolicy(policy), WithLogger(discardLogger()))
+			target := "/submit?" + url.Values{payload: {"v"}, "page": {"2"}}.Encode()
+			r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"user":"alice","note":"it's \"quoted\""}`))
+			r.Header.Set("Content-Type", "application/json")
+			s.Handler().ServeHTTP(httptest.NewRecorder(), r)
+			captures := s.history.list(captureHTTP)
+			if len(captures) != 1 {
+				t.Fatalf("%d captures", len(captures))
+			}
+			ref := captures[0].HTTP
+			if ref.BodyTruncated != tt.truncated || ref.BodyPreview == "" {
+				t.Fatalf("body %q truncated %v", ref.BodyPreview, ref.BodyTruncated)
+			}
+			notes := strings.Join(exportNotes(ref), "\n")
+			if !strings.Contains(notes, `"query:\nos.system('id') # \r\u2028"`) || !strings.Contains(notes, "query:page") {
+				t.Errorf("notes %q do not name the redactions safely", notes)
+			}
+
+			for format, f := range exportFormats {
+				out, err := f.render(ref)
+				if err != nil {
+					t.Errorf("%s: %v", format, err)
+					continue
+				}
+				text := string(out)
+				if strings.ContainsRune(text, '\u2028') {
+					t.Errorf("%s: raw line separator in output", format)
+				}
+				for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == '\r' }) {
+					if strings.HasPrefix(strings.TrimSpace(line), "os.system") {
+						t.Errorf("%s: redaction name escaped its comment:\n%s", format, text)
+						break
+					}
+				}
+				if format == "go" {
+					if _, err := parser.ParseFile(token.NewFileSet(), "main.go", out, 0); err != nil {
+						t.Errorf("go: %v\n%s", err, out)
+					}
+				}
+				if format == "har" {
+					var har harLog
+					if err := json.Unmarshal(out, &har); err != nil || !strings.Contains(har.Log.Entries[0].Comment, "redacted values") {
+						t.Errorf("har: %v, comment %q", err, har.Log.Entries[0].Comment)
+					}
+				}
+				if tt.truncated && format != "http" && !strings.Contains(text, "body truncated") {
+					t.Errorf("%s: no truncation note", format)
+				}
+			}
+		})
+	}
+}
+
+func TestCommentSafe(t *testing.T) {
+	for in, want := range map[string]string{
+		"header:Authorization": "header:Authorization",
+		"body:user.password":   "body:user.password",
+		"query:a\nb":           `"query:a\nb"`,
+		`query:say "hi"`:       `"query:say \"hi\""`,
+		"query:ünï":            `"query:\u00fcn\u00ef"`,
+		"":                     "",
+	} {
+		if got := commentSafe(in); got != want {
+			t.Errorf("commentSafe(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
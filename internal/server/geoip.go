@@ -0,0 +1,148 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoIP looks addresses up in local MaxMind-format databases. Either
+// database may be missing.
+type GeoIP struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+// OpenGeoIP opens a City or Country database and an ASN database. Empty
+// paths are skipped.
+func OpenGeoIP(cityPath, asnPath string) (*GeoIP, error) {
+	g := &GeoIP{}
+	var err error
+	if cityPath != "" {
+		if g.city, err = maxminddb.Open(cityPath); err != nil {
+			return nil, fmt.Errorf("open geoip database: %w", err)
+		}
+	}
+	if asnPath != "" {
+		if g.asn, err = maxminddb.Open(asnPath); err != nil {
+			g.Close()
+			return nil, fmt.Errorf("open asn database: %w", err)
+		}
+	}
+	return g, nil
+}
+
+// Close releases the database files.
+func (g *GeoIP) Close() error {
+	if g == nil {
+		return nil
+	}
+	var errs []error
+	if g.city != nil {
+		errs = append(errs, g.city.Close())
+	}
+	if g.asn != nil {
+		errs = append(errs, g.asn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+type geoLocation struct {
+	CountryCode string  `json:"country_code,omitempty"`
+	Country     string  `json:"country,omitempty"`
+	Region      string  `json:"region,omitempty"`
+	City        string  `json:"city,omitempty"`
+	Latitude    float64 `json:"latitude,omitempty"`
+	Longitude   float64 `json:"longitude,omitempty"`
+	TimeZone    string  `json:"time_zone,omitempty"`
+}
+
+type geoNetwork struct {
+	ASN          uint   `json:"asn,omitempty"`
+	Organization string `json:"organization,omitempty"`
+	Prefix       string `json:"prefix,omitempty"`
+}
+
+type mmdbNames map[string]string
+
+func (n mmdbNames) english() string {
+	return n["en"]
+}
+
+// mmdbCity covers both the GeoIP2/GeoLite2 City and Country layouts.
+type mmdbCity struct {
+	Country struct {
+		ISOCode string    `maxminddb:"iso_code"`
+		Names   mmdbNames `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names mmdbNames `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names mmdbNames `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+		TimeZone  string  `maxminddb:"time_zone"`
+	} `maxminddb:"location"`
+}
+
+type mmdbASN struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// annotate fills in location and network details for every valid address.
+func (g *GeoIP) annotate(addrs []addressDetails) {
+	if g == nil {
+		return
+	}
+	for i := range addrs {
+		ip := net.ParseIP(addrs[i].IP)
+		if ip == nil {
+			continue
+		}
+		addrs[i].Location = g.location(ip)
+		addrs[i].Network = g.network(ip)
+	}
+}
+
+func (g *GeoIP) location(ip net.IP) *geoLocation {
+	if g.city == nil {
+		return nil
+	}
+	var rec mmdbCity
+	if _, ok, err := g.city.LookupNetwork(ip, &rec); err != nil || !ok {
+		return nil
+	}
+	loc := &geoLocation{
+		CountryCode: rec.Country.ISOCode,
+		Country:     rec.Country.Names.english(),
+		City:        rec.City.Names.english(),
+		Latitude:    rec.Location.Latitude,
+		Longitude:   rec.Location.Longitude,
+		TimeZone:    rec.Location.TimeZone,
+	}
+	if len(rec.Subdivisions) > 0 {
+		loc.Region = rec.Subdivisions[0].Names.english()
+	}
+	if *loc == (geoLocation{}) {
+		return nil
+	}
+	return loc
+}
+
+func (g *GeoIP) network(ip net.IP) *geoNetwork {
+	if g.asn == nil {
+		return nil
+	}
+	var rec mmdbASN
+	prefix, ok, err := g.asn.LookupNetwork(ip, &rec)
+	if err != nil || !ok || rec.Number == 0 {
+		return nil
+	}
+	return &geoNetwork{ASN: rec.Number, Organization: rec.Organization, Prefix: prefix.String()}
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// mmdbFixture writes a tiny IPv4 MaxMind database mapping each CIDR to its
+// record and returns its path. It implements just enough of the MMDB format
+// (24-bit search tree records, maps, strings, doubles and unsigned ints) for
+// the reader to load it.
+func mmdbFixture(t *testing.T, dbType string, records map[string]map[string]any) string {
+	t.Helper()
+	type trieNode struct {
+		child [2]*trieNode
+		data  int // offset in the data section, or -1
+	}
+	root := &trieNode{data: -1}
+	var data bytes.Buffer
+	cidrs := make([]string, 0, len(records))
+	for cidr := range records {
+		cidrs = append(cidrs, cidr)
+	}
+	sort.Strings(cidrs)
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip := ipnet.IP.To4()
+		bits, _ := ipnet.Mask.Size()
+		n := root
+		for i := 0; i < bits; i++ {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if n.child[bit] == nil {
+				n.child[bit] = &trieNode{data: -1}
+			}
+			n = n.child[bit]
+		}
+		n.data = data.Len()
+		data.Write(mmdbEncode(records[cidr]))
+	}
+
+	// Number the inner nodes breadth first; leaves become data pointers.
+	var nodes []*trieNode
+	index := map[*trieNode]int{}
+	for queue := []*trieNode{root}; len(queue) > 0; queue = queue[1:] {
+		n := queue[0]
+		index[n] = len(nodes)
+		nodes = append(nodes, n)
+		for _, c := range n.child {
+			if c != nil && c.data < 0 {
+				queue = append(queue, c)
+			}
+		}
+	}
+	nodeCount := len(nodes)
+	var file bytes.Buffer
+	for _, n := range nodes {
+		for _, c := range n.child {
+			record := nodeCount // not found
+			switch {
+			case c == nil:
+			case c.data >= 0:
+				record = nodeCount + 16 + c.data
+			default:
+				record = index[c]
+			}
+			file.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+	file.Write(make([]byte, 16))
+	file.Write(data.Bytes())
+	file.WriteString("\xab\xcd\xefMaxMind.com")
+	file.Write(mmdbEncode(map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1700000000),
+		"database_type":               dbType,
+		"description":                 map[string]any{"en": "reflector test fixture"},
+		"ip_version":                  uint16(4),
+		"languages":                   []any{"en"},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(24),
+	}))
+
+	path := filepath.Join(t.TempDir(), dbType+".mmdb")
+	if err := os.WriteFile(path, file.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// mmdbEncode encodes v in the MMDB data section format.
+func mmdbEncode(v any) []byte {
+	var out bytes.Buffer
+	control := func(typ, size int) {
+		first := byte(typ << 5)
+		var ext []byte
+		if typ > 7 {
+			first = 0
+			ext = []byte{byte(typ - 7)}
+		}
+		switch {
+		case size < 29:
+			out.WriteByte(first | byte(size))
+			out.Write(ext)
+		case size < 285:
+			out.WriteByte(first | 29)
+			out.Write(ext)
+			out.WriteByte(byte(size - 29))
+		default:
+			out.WriteByte(first | 30)
+			out.Write(ext)
+			out.Write(binary.BigEndian.AppendUint16(nil, uint16(size-285)))
+		}
+	}
+	uintBytes := func(n uint64) []byte {
+		b := binary.BigEndian.AppendUint64(nil, n)
+		return bytes.TrimLeft(b, "\x00")
+	}
+	switch t := v.(type) {
+	case string:
+		control(2, len(t))
+		out.WriteString(t)
+	case float64:
+		control(3, 8)
+		out.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(t)))
+	case uint16:
+		b := uintBytes(uint64(t))
+		control(5, len(b))
+		out.Write(b)
+	case uint32:
+		b := uintBytes(uint64(t))
+		control(6, len(b))
+		out.Write(b)
+	case uint64:
+		b := uintBytes(t)
+		control(9, len(b))
+		out.Write(b)
+	case []any:
+		control(11, len(t))
+		for _, item := range t {
+			out.Write(mmdbEncode(item))
+		}
+	case map[string]any:
+		control(7, len(t))
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			out.Write(mmdbEncode(k))
+			out.Write(mmdbEncode(t[k]))
+		}
+	default:
+		panic("mmdbEncode: unsupported type")
+	}
+	return out.Bytes()
+}
+
+func openTestGeoIP(t *testing.T) *GeoIP {
+	t.Helper()
+	city := mmdbFixture(t, "GeoLite2-City", map[string]map[string]any{
+		"192.0.2.0/24": {
+			"country":      map[string]any{"iso_code": "JP", "names": map[string]any{"en": "Japan", "de": "Japan"}},
+			"subdivisions": []any{map[string]any{"names": map[string]any{"en": "Tokyo"}}},
+			"city":         map[string]any{"names": map[string]any{"en": "Chiyoda"}},
+			"location":     map[string]any{"latitude": 35.69, "longitude": 139.75, "time_zone": "Asia/Tokyo"},
+		},
+		"198.51.100.0/25": {
+			"country": map[string]any{"iso_code": "NL", "names": map[string]any{"en": "Netherlands"}},
+		},
+	})
+	asn := mmdbFixture(t, "GeoLite2-ASN", map[string]map[string]any{
+		"192.0.2.0/24":    {"autonomous_system_number": uint32(64500), "autonomous_system_organization": "Example Transit"},
+		"198.51.100.0/22": {"autonomous_system_number": uint32(64501), "autonomous_system_organization": "Example Hosting"},
+	})
+	g, err := OpenGeoIP(city, asn)
+	if err != nil {
+		t.Fatalf("OpenGeoIP: %v", err)
+	}
+	t.Cleanup(func() { g.Close() })
+	return g
+}
+
+func TestGeoIPAnnotate(t *testing.T) {
+	g := openTestGeoIP(t)
+	addrs := []addressDetails{
+		{IP: "192.0.2.10"},
+		{IP: "198.51.100.200"},
+		{IP: "203.0.113.1"},
+		{IP: "not-an-ip"},
+	}
+	g.annotate(addrs)
+
+	tokyo := geoLocation{CountryCode: "JP", Country: "Japan", Region: "Tokyo", City: "Chiyoda", Latitude: 35.69, Longitude: 139.75, TimeZone: "Asia/Tokyo"}
+	if loc := addrs[0].Location; loc == nil || *loc != tokyo {
+		t.Errorf("city lookup: got %+v, want %+v", loc, tokyo)
+	}
+	if n := addrs[0].Network; n == nil || *n != (geoNetwork{ASN: 64500, Organization: "Example Transit", Prefix: "192.0.2.0/24"}) {
+		t.Errorf("asn lookup: got %+v", n)
+	}
+
+	// In the ASN database only, outside the /25 the city database covers.
+	if loc := addrs[1].Location; loc != nil {
+		t.Errorf("198.51.100.200 has location %+v, want none", loc)
+	}
+	if n := addrs[1].Network; n == nil || n.ASN != 64501 || n.Prefix != "198.51.100.0/22" {
+		t.Errorf("asn lookup: got %+v", n)
+	}
+
+	for _, a := range addrs[2:] {
+		if a.Location != nil || a.Network != nil {
+			t.Errorf("%s: got %+v / %+v, want no match", a.IP, a.Location, a.Network)
+		}
+	}
+}
+
+func TestGeoIPCountryOnly(t *testing.T) {
+	country := mmdbFixture(t, "GeoLite2-Country", map[string]map[string]any{
+		"192.0.2.0/24": {"country": map[string]any{"iso_code": "DE", "names": map[string]any{"en": "Germany"}}},
+	})
+	g, err := OpenGeoIP(country, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	addrs := []addressDetails{{IP: "192.0.2.1"}}
+	g.annotate(addrs)
+	if loc := addrs[0].Location; loc == nil || *loc != (geoLocation{CountryCode: "DE", Country: "Germany"}) {
+		t.Errorf("got %+v", loc)
+	}
+	if addrs[0].Network != nil {
+		t.Errorf("network %+v without an ASN database", addrs[0].Network)
+	}
+}
+
+func TestOpenGeoIPErrors(t *testing.T) {
+	bogus := filepath.Join(t.TempDir(), "bogus.mmdb")
+	if err := os.WriteFile(bogus, []byte("not a database"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenGeoIP(bogus, ""); err == nil {
+		t.Error("opened a file without MMDB metadata")
+	}
+	if _, err := OpenGeoIP("", filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Error("opened a missing ASN database")
+	}
+	var g *GeoIP
+	g.annotate([]addressDetails{{IP: "192.0.2.1"}})
+	if err := g.Close(); err != nil {
+		t.Errorf("nil Close: %v", err)
+	}
+}
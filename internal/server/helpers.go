@@ -1,6 +1,8 @@
 package server
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"net/http"
@@ -28,9 +30,44 @@ func clientIP(r *http.Request) string {
 	if realIP := r.Header.Get("X-Real-Ip"); realIP != "" {
 		return realIP
 	}
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	return addrHost(r.RemoteAddr)
+}
+
+// trustedClientIP is the client address as far as it can be vouched for.
+// Forwarding headers are only honoured when the peer is in trusted, and
+// X-Forwarded-For is read from the right so a client cannot prepend an
+// address of its choosing: the first hop that is not itself trusted wins.
+func trustedClientIP(r *http.Request, trusted []*net.IPNet) string {
+	ip := addrHost(r.RemoteAddr)
+	if !containsIP(trusted, net.ParseIP(ip)) {
+		return ip
+	}
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				// Nothing left of a malformed entry can be trusted.
+				return ip
+			}
+			ip = hop.String()
+			if !containsIP(trusted, hop) {
+				return ip
+			}
+		}
+		return ip
+	}
+	if realIP := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-Ip"))); realIP != nil {
+		return realIP.String()
+	}
+	return ip
+}
+
+// addrHost strips the port from a host:port address.
+func addrHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
-		return r.RemoteAddr
+		return addr
 	}
 	return host
 }
@@ -72,18 +109,6 @@ func queryValues(r *http.Request) map[string][]string {
 	return out
 }
 
-func cookieValues(r *http.Request) []cookieDetails {
-	cookies := r.Cookies()
-	if len(cookies) == 0 {
-		return nil
-	}
-	out := make([]cookieDetails, 0, len(cookies))
-	for _, c := range cookies {
-		out = append(out, cookieDetails{Name: c.Name, Value: c.Value})
-	}
-	return out
-}
-
 func tlsFromRequest(r *http.Request) *tlsDetails {
 	if r.TLS == nil {
 		return nil
@@ -153,3 +178,34 @@ func mapToPairs(m map[string][]string) []keyValues {
 	}
 	return out
 }
+
+// headerTokens splits every value of a comma-separated header into trimmed tokens.
+func headerTokens(h http.Header, name string) []string {
+	var out []string
+	for _, value := range h.Values(name) {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				out = append(out, token)
+			}
+		}
+	}
+	return out
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, t := range headerTokens(h, name) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// randomHex returns n random bytes encoded as lowercase hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("reflector: read random bytes: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultHistorySize = 100
+
+const (
+	captureHTTP = "http"
+	captureTCP  = "tcp"
+	captureUDP  = "udp"
+	captureDNS  = "dns"
+)
+
+// capture is one entry in the history. HTTP reflections and raw connection
+// sessions share the same envelope so they can be listed side by side.
+type capture struct {
+	ID        string       `json:"id"`
+	Kind      string       `json:"kind"`
+	Timestamp time.Time    `json:"timestamp"`
+	HTTP      *reflection  `json:"http,omitempty"`
+	Session   *connSession `json:"session,omitempty"`
+	DNS       *dnsQuery    `json:"dns,omitempty"`
+}
+
+// history is a fixed-size ring of the most recent captures.
+type history struct {
+	mu      sync.Mutex
+	entries []capture
+	next    int
+	full    bool
+}
+
+func newHistory(size int) *history {
+	return &history{entries: make([]capture, size)}
+}
+
+// add stores c, assigning an ID when it has none, and returns the stored ID.
+func (h *history) add(c capture) string {
+	if c.ID == "" {
+		c.ID = newCaptureID()
+	}
+	if len(h.entries) == 0 {
+		return c.ID
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries[h.next] = c
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+	return c.ID
+}
+
+// list returns the stored captures, newest first.
+func (h *history) list(kind string) []capture {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := h.next
+	if h.full {
+		n = len(h.entries)
+	}
+	out := make([]capture, 0, n)
+	for i := 1; i <= n; i++ {
+		c := h.entries[(h.next-i+len(h.entries))%len(h.entries)]
+		if kind != "" && c.Kind != kind {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func (h *history) get(id string) (capture, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, c := range h.entries {
+		if c.ID != "" && c.ID == id {
+			return c, true
+		}
+	}
+	return capture{}, false
+}
+
+func newCaptureID() string {
+	return randomHex(16)
+}
+
+func (s *Server) historyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/history"), "/")
+	if id == "" {
+		s.writeJSON(w, http.StatusOK, s.history.list(r.URL.Query().Get("kind")))
+		return
+	}
+	c, ok := s.history.get(id)
+	if !ok {
+		http.Error(w, "capture not found", http.StatusNotFound)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, c)
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		s.logger.Warn("write json response", "err", err)
+	}
+}
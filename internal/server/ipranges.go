@@ -0,0 +1,287 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Address classes for special-purpose ranges (RFC 6890 and friends).
+var specialRanges = []struct {
+	prefix netip.Prefix
+	class  string
+}{
+	{netip.MustParsePrefix("0.0.0.0/8"), "this-network"},
+	{netip.MustParsePrefix("10.0.0.0/8"), "private"},
+	{netip.MustParsePrefix("100.64.0.0/10"), "cgnat"},
+	{netip.MustParsePrefix("127.0.0.0/8"), "loopback"},
+	{netip.MustParsePrefix("169.254.0.0/16"), "link-local"},
+	{netip.MustParsePrefix("172.16.0.0/12"), "private"},
+	{netip.MustParsePrefix("192.0.0.0/24"), "ietf-protocol"},
+	{netip.MustParsePrefix("192.0.2.0/24"), "documentation"},
+	{netip.MustParsePrefix("192.88.99.0/24"), "6to4-relay"},
+	{netip.MustParsePrefix("192.168.0.0/16"), "private"},
+	{netip.MustParsePrefix("198.18.0.0/15"), "benchmarking"},
+	{netip.MustParsePrefix("198.51.100.0/24"), "documentation"},
+	{netip.MustParsePrefix("203.0.113.0/24"), "documentation"},
+	{netip.MustParsePrefix("224.0.0.0/4"), "multicast"},
+	// The broadcast address lies inside 240.0.0.0/4, so it must come first.
+	{netip.MustParsePrefix("255.255.255.255/32"), "broadcast"},
+	{netip.MustParsePrefix("240.0.0.0/4"), "reserved"},
+	{netip.MustParsePrefix("::/128"), "unspecified"},
+	{netip.MustParsePrefix("::1/128"), "loopback"},
+	{netip.MustParsePrefix("64:ff9b::/96"), "nat64"},
+	{netip.MustParsePrefix("100::/64"), "discard"},
+	{netip.MustParsePrefix("2001::/32"), "teredo"},
+	{netip.MustParsePrefix("2001:db8::/32"), "documentation"},
+	{netip.MustParsePrefix("2002::/16"), "6to4"},
+	{netip.MustParsePrefix("fc00::/7"), "unique-local"},
+	{netip.MustParsePrefix("fe80::/10"), "link-local"},
+	{netip.MustParsePrefix("ff00::/8"), "multicast"},
+}
+
+// classifyIP returns the special-purpose classes of ip, or "public". An
+// IPv4-mapped IPv6 address is reported as such followed by the class of the
+// embedded IPv4 address.
+func classifyIP(ip netip.Addr) []string {
+	// Prefixes never contain an address with a zone.
+	ip = ip.WithZone("")
+	var classes []string
+	if ip.Is4In6() {
+		classes = append(classes, "ipv4-mapped")
+		ip = ip.Unmap()
+	}
+	for _, r := range specialRanges {
+		if r.prefix.Contains(ip) {
+			return append(classes, r.class)
+		}
+	}
+	return append(classes, "public")
+}
+
+// IPRanges holds named address ranges, such as cloud provider, CDN or Tor
+// exit lists, loaded from local JSON files.
+//
+// Ranges are indexed by their masked prefix, so a lookup probes one map key
+// per prefix length in use rather than scanning every range; the full AWS
+// list alone has around 10,000.
+type IPRanges struct {
+	byPrefix map[netip.Prefix][]ipRange
+	// bits4 and bits6 are the prefix lengths in use, longest first.
+	bits4, bits6 []int
+}
+
+type ipRange struct {
+	prefix netip.Prefix
+	name   string
+	kind   string
+	detail string
+}
+
+// rangeMatch is a named range that contains an address.
+type rangeMatch struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Prefix string `json:"prefix"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// rangeFile accepts reflector's own format as well as the files published
+// by AWS (ip-ranges.json), Google Cloud (cloud.json, goog.json) and the
+// Cloudflare API (/client/v4/ips).
+type rangeFile struct {
+	Name     string            `json:"name"`
+	Kind     string            `json:"kind"`
+	Prefixes []json.RawMessage `json:"prefixes"`
+
+	IPv6Prefixes []rangeFilePrefix `json:"ipv6_prefixes"`
+	Result       *struct {
+		IPv4 []string `json:"ipv4_cidrs"`
+		IPv6 []string `json:"ipv6_cidrs"`
+	} `json:"result"`
+}
+
+type rangeFilePrefix struct {
+	AWSv4   string `json:"ip_prefix"`
+	AWSv6   string `json:"ipv6_prefix"`
+	Region  string `json:"region"`
+	Service string `json:"service"`
+	GCPv4   string `json:"ipv4Prefix"`
+	GCPv6   string `json:"ipv6Prefix"`
+	Scope   string `json:"scope"`
+}
+
+// LoadIPRanges reads range files. A file may hold a single range set or an
+// array of them.
+func LoadIPRanges(paths []string) (*IPRanges, error) {
+	r := &IPRanges{byPrefix: make(map[netip.Prefix][]ipRange)}
+	for _, path := range paths {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read ip ranges: %w", err)
+		}
+		if err := r.parse(data); err != nil {
+			return nil, fmt.Errorf("ip ranges %s: %w", path, err)
+		}
+	}
+	// Most specific ranges first so the best match is listed first.
+	for prefix := range r.byPrefix {
+		if prefix.Addr().Is4() {
+			r.bits4 = appendBits(r.bits4, prefix.Bits())
+		} else {
+			r.bits6 = appendBits(r.bits6, prefix.Bits())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(r.bits4)))
+	sort.Sort(sort.Reverse(sort.IntSlice(r.bits6)))
+	return r, nil
+}
+
+func appendBits(bits []int, n int) []int {
+	for _, b := range bits {
+		if b == n {
+			return bits
+		}
+	}
+	return append(bits, n)
+}
+
+func (r *IPRanges) parse(data []byte) error {
+	var files []rangeFile
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(data, &files); err != nil {
+			return err
+		}
+	} else {
+		var f rangeFile
+		if err := json.Unmarshal(data, &f); err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+	for _, f := range files {
+		if err := r.add(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *IPRanges) add(f rangeFile) error {
+	if f.Result != nil {
+		return r.addPrefixes(defaultString(f.Name, "Cloudflare"), defaultString(f.Kind, "cdn"), "", append(f.Result.IPv4, f.Result.IPv6...))
+	}
+	for _, raw := range f.Prefixes {
+		var plain string
+		if json.Unmarshal(raw, &plain) == nil {
+			if err := r.addPrefixes(defaultString(f.Name, "unnamed"), defaultString(f.Kind, "network"), "", []string{plain}); err != nil {
+				return err
+			}
+			continue
+		}
+		var p rangeFilePrefix
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return fmt.Errorf("unrecognised prefix entry %s", raw)
+		}
+		if err := r.addProvider(f, p); err != nil {
+			return err
+		}
+	}
+	for _, p := range f.IPv6Prefixes {
+		if err := r.addProvider(f, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *IPRanges) addProvider(f rangeFile, p rangeFilePrefix) error {
+	switch {
+	case p.AWSv4 != "" || p.AWSv6 != "":
+		kind := "cloud"
+		if p.Service == "CLOUDFRONT" || p.Service == "CLOUDFRONT_ORIGIN_FACING" {
+			kind = "cdn"
+		}
+		return r.addPrefixes(defaultString(f.Name, "AWS"), defaultString(f.Kind, kind), strings.TrimSpace(p.Service+" "+p.Region), []string{p.AWSv4 + p.AWSv6})
+	case p.GCPv4 != "" || p.GCPv6 != "":
+		return r.addPrefixes(defaultString(f.Name, "Google"), defaultString(f.Kind, "cloud"), strings.TrimSpace(p.Service+" "+p.Scope), []string{p.GCPv4 + p.GCPv6})
+	}
+	return nil
+}
+
+func (r *IPRanges) addPrefixes(name, kind, detail string, prefixes []string) error {
+	for _, s := range prefixes {
+		s = strings.TrimSpace(s)
+		var prefix netip.Prefix
+		var err error
+		if strings.Contains(s, "/") {
+			prefix, err = netip.ParsePrefix(s)
+		} else {
+			var addr netip.Addr
+			if addr, err = netip.ParseAddr(s); err == nil {
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		prefix = prefix.Masked()
+		r.byPrefix[prefix] = append(r.byPrefix[prefix], ipRange{prefix: prefix, name: name, kind: kind, detail: detail})
+	}
+	return nil
+}
+
+func defaultString(v, fallback string) string {
+	if v != "" {
+		return v
+	}
+	return fallback
+}
+
+// lookup returns every named range containing ip, most specific first, with
+// at most one entry per range name.
+func (r *IPRanges) lookup(ip netip.Addr) []rangeMatch {
+	if r == nil {
+		return nil
+	}
+	ip = ip.Unmap().WithZone("")
+	bits := r.bits6
+	if ip.Is4() {
+		bits = r.bits4
+	}
+	var out []rangeMatch
+	seen := make(map[string]bool)
+	for _, n := range bits {
+		prefix, err := ip.Prefix(n)
+		if err != nil {
+			continue
+		}
+		for _, e := range r.byPrefix[prefix] {
+			if seen[e.name] {
+				continue
+			}
+			seen[e.name] = true
+			out = append(out, rangeMatch{Name: e.name, Kind: e.kind, Prefix: e.prefix.String(), Detail: e.detail})
+		}
+	}
+	return out
+}
+
+// classify records the special-purpose class and any named ranges for every
+// valid address.
+func (r *IPRanges) classify(addrs []addressDetails) {
+	for i := range addrs {
+		ip, err := netip.ParseAddr(addrs[i].IP)
+		if err != nil {
+			continue
+		}
+		addrs[i].Classes = classifyIP(ip)
+		addrs[i].Ranges = r.lookup(ip)
+	}
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeRangeFile(t testing.TB, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadIPRanges(t *testing.T) {
+	dir := t.TempDir()
+	aws := writeRangeFile(t, dir, "ip-ranges.json", `{
+		"syncToken": "1714000000",
+		"prefixes": [
+			{"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "AMAZON", "network_border_group": "ap-northeast-2"},
+			{"ip_prefix": "3.5.140.0/24", "region": "ap-northeast-2", "service": "S3", "network_border_group": "ap-northeast-2"},
+			{"ip_prefix": "13.32.0.0/15", "region": "GLOBAL", "service": "CLOUDFRONT", "network_border_group": "GLOBAL"}
+		],
+		"ipv6_prefixes": [
+			{"ipv6_prefix": "2600:1f14::/35", "region": "us-west-2", "service": "EC2", "network_border_group": "us-west-2"}
+		]
+	}`)
+	gcp := writeRangeFile(t, dir, "cloud.json", `{
+		"syncToken": "1714000000",
+		"prefixes": [
+			{"ipv4Prefix": "34.1.208.0/20", "service": "Google Cloud", "scope": "africa-south1"},
+			{"ipv6Prefix": "2600:1900:8000::/44", "service": "Google Cloud", "scope": "us-east1"}
+		]
+	}`)
+	cloudflare := writeRangeFile(t, dir, "cloudflare.json", `{
+		"result": {"ipv4_cidrs": ["104.16.0.0/13"], "ipv6_cidrs": ["2606:4700::/32"], "etag": "x"},
+		"success": true
+	}`)
+	own := writeRangeFile(t, dir, "own.json", `[
+		{"name": "Tor exits", "kind": "tor", "prefixes": ["185.220.101.5", "2001:67c:e60:c0c:192:42:116:16"]},
+		{"name": "Office", "prefixes": ["3.5.140.7/32", "10.1.2.99/16"]}
+	]`)
+
+	r, err := LoadIPRanges([]string{aws, "", gcp, " " + cloudflare + " ", own})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip   string
+		want []rangeMatch
+	}{
+		{"3.5.140.7", []rangeMatch{
+			{Name: "Office", Kind: "network", Prefix: "3.5.140.7/32"},
+			// Only the most specific AWS range is listed.
+			{Name: "AWS", Kind: "cloud", Prefix: "3.5.140.0/24", Detail: "S3 ap-northeast-2"},
+		}},
+		{"3.5.143.1", []rangeMatch{{Name: "AWS", Kind: "cloud", Prefix: "3.5.140.0/22", Detail: "AMAZON ap-northeast-2"}}},
+		{"13.33.1.1", []rangeMatch{{Name: "AWS", Kind: "cdn", Prefix: "13.32.0.0/15", Detail: "CLOUDFRONT GLOBAL"}}},
+		{"2600:1f14:10::1", []rangeMatch{{Name: "AWS", Kind: "cloud", Prefix: "2600:1f14::/35", Detail: "EC2 us-west-2"}}},
+		{"34.1.210.4", []rangeMatch{{Name: "Google", Kind: "cloud", Prefix: "34.1.208.0/20", Detail: "Google Cloud africa-south1"}}},
+		{"2600:1900:8000::9", []rangeMatch{{Name: "Google", Kind: "cloud", Prefix: "2600:1900:8000::/44", Detail: "Google Cloud us-east1"}}},
+		{"104.18.2.3", []rangeMatch{{Name: "Cloudflare", Kind: "cdn", Prefix: "104.16.0.0/13"}}},
+		{"::ffff:104.18.2.3", []rangeMatch{{Name: "Cloudflare", Kind: "cdn", Prefix: "104.16.0.0/13"}}},
+		{"2606:4700::6810:84e5", []rangeMatch{{Name: "Cloudflare", Kind: "cdn", Prefix: "2606:4700::/32"}}},
+		{"185.220.101.5", []rangeMatch{{Name: "Tor exits", Kind: "tor", Prefix: "185.220.101.5/32"}}},
+		{"185.220.101.6", nil},
+		{"2001:67c:e60:c0c:192:42:116:16", []rangeMatch{{Name: "Tor exits", Kind: "tor", Prefix: "2001:67c:e60:c0c:192:42:116:16/128"}}},
+		// Host bits in a prefix are masked off.
+		{"10.1.200.1", []rangeMatch{{Name: "Office", Kind: "network", Prefix: "10.1.0.0/16"}}},
+		{"192.0.2.1", nil},
+	}
+	for _, tt := range tests {
+		got := r.lookup(netip.MustParseAddr(tt.ip))
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("lookup(%s)\n got %v\nwant %v", tt.ip, got, tt.want)
+		}
+	}
+
+	var none *IPRanges
+	if got := none.lookup(netip.MustParseAddr("3.5.140.7")); got != nil {
+		t.Errorf("nil ranges: %v", got)
+	}
+}
+
+func TestLoadIPRangesErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]string{
+		"bad prefix":        `{"name": "x", "prefixes": ["10.0.0.0/33"]}`,
+		"bad address":       `{"name": "x", "prefixes": ["not-an-ip"]}`,
+		"bad cloudflare":    `{"result": {"ipv4_cidrs": ["1.2.3"]}}`,
+		"unrecognised":      `{"prefixes": [42]}`,
+		"not json":          `prefixes: [10.0.0.0/8]`,
+		"array of non-sets": `["10.0.0.0/8"]`,
+	}
+	for name, content := range tests {
+		path := writeRangeFile(t, dir, strings.ReplaceAll(name, " ", "-")+".json", content)
+		if _, err := LoadIPRanges([]string{path}); err == nil {
+			t.Errorf("%s: loaded", name)
+		}
+	}
+	if _, err := LoadIPRanges([]string{filepath.Join(dir, "missing.json")}); err == nil {
+		t.Error("missing file loaded")
+	}
+}
+
+func TestClassifyIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"8.8.8.8", "public"},
+		{"0.1.2.3", "this-network"},
+		{"10.20.30.40", "private"},
+		{"172.31.255.255", "private"},
+		{"172.32.0.1", "public"},
+		{"192.168.1.1", "private"},
+		{"100.64.0.1", "cgnat"},
+		{"100.128.0.1", "public"},
+		{"127.0.0.53", "loopback"},
+		{"169.254.169.254", "link-local"},
+		{"192.0.0.9", "ietf-protocol"},
+		{"192.0.2.1", "documentation"},
+		{"198.51.100.7", "documentation"},
+		{"203.0.113.200", "documentation"},
+		{"192.88.99.1", "6to4-relay"},
+		{"198.19.255.1", "benchmarking"},
+		{"224.0.0.251", "multicast"},
+		{"240.0.0.1", "reserved"},
+		{"255.255.255.255", "broadcast"},
+		{"2606:4700::1111", "public"},
+		{"::", "unspecified"},
+		{"::1", "loopback"},
+		{"64:ff9b::808:808", "nat64"},
+		{"100::1", "discard"},
+		{"2001:0:4136:e378::1", "teredo"},
+		{"2001:db8::1", "documentation"},
+		{"2002:c000:204::1", "6to4"},
+		{"fd12:3456::1", "unique-local"},
+		{"fe80::1%eth0", "link-local"},
+		{"ff02::fb", "multicast"},
+		{"::ffff:10.0.0.1", "ipv4-mapped,private"},
+		{"::ffff:8.8.8.8", "ipv4-mapped,public"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(classifyIP(netip.MustParseAddr(tt.ip)), ","); got != tt.want {
+			t.Errorf("classifyIP(%s) = %s, want %s", tt.ip, got, tt.want)
+		}
+	}
+}
+
+// BenchmarkIPRangesLookup uses a list the size of AWS's ip-ranges.json.
+func BenchmarkIPRangesLookup(b *testing.B) {
+	var prefixes []string
+	for i := 0; i < 10000; i++ {
+		prefixes = append(prefixes, fmt.Sprintf(`{"ip_prefix": "%d.%d.%d.0/%d", "region": "r", "service": "EC2"}`, 3+i/4096, (i/16)%256, (i%16)*16, 20+i%5))
+	}
+	path := writeRangeFile(b, b.TempDir(), "aws.json", `{"prefixes": [`+strings.Join(prefixes, ",")+`]}`)
+	r, err := LoadIPRanges([]string{path})
+	if err != nil {
+		b.Fatal(err)
+	}
+	ips := []netip.Addr{netip.MustParseAddr("3.7.48.9"), netip.MustParseAddr("198.51.100.1"), netip.MustParseAddr("2001:db8::1")}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.lookup(ips[i%len(ips)])
+	}
+}
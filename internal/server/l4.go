@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	l4IdleTimeout      = 5 * time.Minute
+	proxyDetectTimeout = 250 * time.Millisecond
+	udpMaxDatagram     = 64 << 10
+)
+
+// connSession describes one raw TCP connection or UDP datagram.
+type connSession struct {
+	Transport   string       `json:"transport"`
+	PeerAddr    string       `json:"peer_addr"`
+	LocalAddr   string       `json:"local_addr"`
+	Proxy       *proxyHeader `json:"proxy,omitempty"`
+	StartedAt   time.Time    `json:"started_at"`
+	FirstByteMS float64      `json:"first_byte_ms,omitempty"`
+	DurationMS  float64      `json:"duration_ms"`
+	BytesIn     int64        `json:"bytes_in"`
+	BytesOut    int64        `json:"bytes_out"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// ServeTCP accepts connections on ln until it is closed. Every connection
+// first receives a one-line JSON report about itself, after which all bytes
+// it sends are echoed back.
+func (s *Server) ServeTCP(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(50 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.handleTCP(conn)
+	}
+}
+
+func (s *Server) handleTCP(conn net.Conn) {
+	defer conn.Close()
+	session := &connSession{
+		Transport: captureTCP,
+		PeerAddr:  conn.RemoteAddr().String(),
+		LocalAddr: conn.LocalAddr().String(),
+		StartedAt: time.Now().UTC(),
+	}
+	defer func() {
+		session.DurationMS = msSince(session.StartedAt)
+		s.history.add(capture{Kind: captureTCP, Timestamp: session.StartedAt, Session: session})
+		s.logSession(session)
+	}()
+
+	// Load balancers send the PROXY header immediately after connecting,
+	// so a short deadline is enough to tell whether one is coming.
+	br := bufio.NewReader(conn)
+	if s.acceptsProxyHeader(conn.RemoteAddr()) {
+		_ = conn.SetReadDeadline(time.Now().Add(proxyDetectTimeout))
+		proxy, err := readProxyHeader(br)
+		switch {
+		case err == nil:
+			session.Proxy = proxy
+		case errors.Is(err, os.ErrDeadlineExceeded):
+		case errors.Is(err, io.EOF):
+			return
+		default:
+			session.Error = err.Error()
+			return
+		}
+		if br.Buffered() > 0 {
+			session.FirstByteMS = msSince(session.StartedAt)
+		}
+	}
+
+	report, err := json.Marshal(session)
+	if err != nil {
+		session.Error = err.Error()
+		return
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(l4IdleTimeout))
+	if _, err := conn.Write(append(report, '\n')); err != nil {
+		session.Error = err.Error()
+		return
+	}
+
+	buf := make([]byte, 32<<10)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(l4IdleTimeout))
+		n, err := br.Read(buf)
+		if n > 0 {
+			if session.FirstByteMS == 0 {
+				session.FirstByteMS = msSince(session.StartedAt)
+			}
+			session.BytesIn += int64(n)
+			_ = conn.SetWriteDeadline(time.Now().Add(l4IdleTimeout))
+			written, werr := conn.Write(buf[:n])
+			session.BytesOut += int64(written)
+			if werr != nil {
+				session.Error = werr.Error()
+				return
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				session.Error = err.Error()
+			}
+			return
+		}
+	}
+}
+
+// ServeUDP echoes every datagram received on pc. Unlike TCP there is no
+// report in the reply: UDP sources can be spoofed, and a reply larger than
+// the request would make reflector an amplifier. Sessions are still recorded
+// in history.
+func (s *Server) ServeUDP(pc net.PacketConn) error {
+	buf := make([]byte, udpMaxDatagram)
+	for {
+		n, peer, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.handleUDP(pc, peer, buf[:n])
+	}
+}
+
+func (s *Server) handleUDP(pc net.PacketConn, peer net.Addr, payload []byte) {
+	session := &connSession{
+		Transport: captureUDP,
+		PeerAddr:  peer.String(),
+		LocalAddr: pc.LocalAddr().String(),
+		StartedAt: time.Now().UTC(),
+		BytesIn:   int64(len(payload)),
+	}
+	if bytes.HasPrefix(payload, proxyV2Signature) && s.acceptsProxyHeader(peer) {
+		proxy, n, err := parseProxyV2(payload)
+		if err != nil {
+			session.Error = err.Error()
+		} else {
+			session.Proxy = proxy
+			payload = payload[n:]
+		}
+	}
+
+	if len(payload) > 0 && session.Error == "" {
+		if n, err := pc.WriteTo(payload, peer); err != nil {
+			session.Error = err.Error()
+		} else {
+			session.BytesOut += int64(n)
+		}
+	}
+
+	session.DurationMS = msSince(session.StartedAt)
+	s.history.add(capture{Kind: captureUDP, Timestamp: session.StartedAt, Session: session})
+	s.logSession(session)
+}
+
+// acceptsProxyHeader reports whether peer is a load balancer allowed to
+// describe the original client in a PROXY header.
+func (s *Server) acceptsProxyHeader(peer net.Addr) bool {
+	return containsIP(s.proxyProtoFrom, net.ParseIP(addrHost(peer.String())))
+}
+
+func (s *Server) logSession(session *connSession) {
+	attrs := []any{
+		"transport", session.Transport,
+		"peer", session.PeerAddr,
+		"local", session.LocalAddr,
+		"bytes_in", session.BytesIn,
+		"bytes_out", session.BytesOut,
+		"duration_ms", session.DurationMS,
+	}
+	if session.Proxy != nil {
+		attrs = append(attrs, "proxy_source", session.Proxy.SourceAddr)
+	}
+	if session.Error != "" {
+		attrs = append(attrs, "err", session.Error)
+	}
+	s.logger.Info("session", attrs...)
+}
+
+func msSince(t time.Time) float64 {
+	return msBetween(t, time.Now())
+}
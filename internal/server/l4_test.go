@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loopback(t *testing.T) []*net.IPNet {
+	t.Helper()
+	nets, err := ParseCIDRs([]string{"127.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nets
+}
+
+// udpExchange sends payload to a UDP echo listener and returns every reply
+// datagram that arrives shortly afterwards.
+func udpExchange(t *testing.T, s *Server, payload []byte) [][]byte {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.ServeUDP(pc) }()
+	t.Cleanup(func() { pc.Close() })
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	var replies [][]byte
+	buf := make([]byte, udpMaxDatagram)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return replies
+		}
+		replies = append(replies, append([]byte(nil), buf[:n]...))
+	}
+}
+
+func TestUDPEchoNeverAmplifies(t *testing.T) {
+	s := New(4096)
+	replies := udpExchange(t, s, []byte("x"))
+	if len(replies) != 1 || string(replies[0]) != "x" {
+		t.Fatalf("got replies %q, want only the echoed byte", replies)
+	}
+	sessions := s.history.list(captureUDP)
+	if len(sessions) != 1 || sessions[0].Session.BytesIn != 1 || sessions[0].Session.BytesOut != 1 {
+		t.Errorf("history has %+v", sessions)
+	}
+}
+
+func TestUDPProxyHeaderTrust(t *testing.T) {
+	header := proxyV2(0x1, 0x12, ipv4Block("192.0.2.1", "198.51.100.2", 5000, 53))
+	datagram := append(append([]byte(nil), header...), "ping"...)
+
+	untrusted := New(4096)
+	replies := udpExchange(t, untrusted, datagram)
+	if len(replies) != 1 || !bytes.Equal(replies[0], datagram) {
+		t.Errorf("untrusted peer: got %q, want the datagram echoed whole", replies)
+	}
+	if c := untrusted.history.list(captureUDP); len(c) != 1 || c[0].Session.Proxy != nil {
+		t.Errorf("untrusted peer: PROXY header was decoded: %+v", c)
+	}
+
+	trusted := New(4096, WithProxyProtocol(loopback(t)))
+	replies = udpExchange(t, trusted, datagram)
+	if len(replies) != 1 || string(replies[0]) != "ping" {
+		t.Errorf("trusted peer: got %q, want the payload without the header", replies)
+	}
+	c := trusted.history.list(captureUDP)
+	if len(c) != 1 || c[0].Session.Proxy == nil || c[0].Session.Proxy.SourceAddr != "192.0.2.1:5000" {
+		t.Errorf("trusted peer: got %+v", c)
+	}
+}
+
+// tcpExchange connects to a TCP echo listener, sends data and returns the
+// JSON report followed by the echoed bytes.
+func tcpExchange(t *testing.T, s *Server, data string) (connSession, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.ServeTCP(ln) }()
+	t.Cleanup(func() { ln.Close() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report connSession
+	if err := json.Unmarshal(line, &report); err != nil {
+		t.Fatalf("decode report %q: %v", line, err)
+	}
+	echo := make([]byte, 0, len(data))
+	buf := make([]byte, len(data))
+	for len(echo) < cap(echo) {
+		_ = conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		n, err := br.Read(buf)
+		echo = append(echo, buf[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	return report, string(echo)
+}
+
+func TestTCPProxyHeaderTrust(t *testing.T) {
+	header := "PROXY TCP4 192.0.2.1 198.51.100.2 5000 7\r\n"
+
+	report, echo := tcpExchange(t, New(4096), header+"hi")
+	if report.Proxy != nil || !strings.HasPrefix(echo, "PROXY TCP4") {
+		t.Errorf("untrusted peer: report %+v, echo %q", report.Proxy, echo)
+	}
+
+	report, echo = tcpExchange(t, New(4096, WithProxyProtocol(loopback(t))), header+"hi")
+	if report.Proxy == nil || report.Proxy.SourceAddr != "192.0.2.1:5000" || !strings.HasPrefix(echo, "hi") {
+		t.Errorf("trusted peer: report %+v, echo %q", report.Proxy, echo)
+	}
+}
@@ -1,15 +1,57 @@
 package server
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
+
+	"golang.org/x/net/http/httpguts"
 )
 
-func logRequests(next http.Handler) http.Handler {
+// ParseLogFields validates the header names given to --log-fields, so a
+// typo fails at startup instead of silently logging nothing.
+func ParseLogFields(values []string) ([]string, error) {
+	var fields []string
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !httpguts.ValidHeaderFieldName(v) {
+			return nil, fmt.Errorf("%q is not a header name", v)
+		}
+		fields = append(fields, http.CanonicalHeaderKey(v))
+	}
+	return fields, nil
+}
+
+// logRequests writes one structured access log line per request, including
+// the response status and size and any headers selected with WithLogFields.
+func (s *Server) logRequests(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		next.ServeHTTP(w, r)
-		log.Printf("%s %s from %s (%s) in %s", r.Method, r.URL.String(), clientIP(r), r.RemoteAddr, time.Since(start))
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		attrs := []slog.Attr{
+			slog.String("request_id", requestIDFromRequest(r)),
+			slog.String("method", r.Method),
+			slog.String("uri", s.redaction.redactURI(r.URL.RequestURI())),
+			slog.String("proto", r.Proto),
+			slog.String("host", r.Host),
+			slog.Int("status", rec.statusCode()),
+			slog.Int64("bytes", rec.bytes),
+			slog.Float64("duration_ms", msSince(start)),
+			slog.String("remote_ip", clientIP(r)),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
+		for _, name := range s.logFields {
+			if value := r.Header.Get(name); value != "" {
+				attrs = append(attrs, slog.String("header."+http.CanonicalHeaderKey(name), s.redaction.redactHeader(name, value)))
+			}
+		}
+		s.logger.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
 	})
 }
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// logLines decodes the JSON access log lines written to buf.
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		if m["msg"] == "request" {
+			lines = append(lines, m)
+		}
+	}
+	return lines
+}
+
+func jsonLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(buf, nil))
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	fields, err := ParseLogFields([]string{" user-agent", "Authorization", "", "X-Missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(4096, WithLogger(jsonLogger(&buf)), WithRedactionPolicy(testPolicy()), WithLogFields(fields))
+
+	r := httptest.NewRequest(http.MethodGet, "/healthz?token=secret123&page=2", nil)
+	r.RemoteAddr = "192.0.2.7:4000"
+	r.Header.Set("User-Agent", "curl/8.5.0")
+	r.Header.Set("Authorization", "Bearer abcdef")
+	r.Header.Set("Cookie", "session=hidden")
+	s.Handler().ServeHTTP(httptest.NewRecorder(), r)
+
+	lines := logLines(t, &buf)
+	if len(lines) != 1 {
+		t.Fatalf("got %d access log lines", len(lines))
+	}
+	line := lines[0]
+	want := map[string]any{
+		"method":               "GET",
+		"proto":                "HTTP/1.1",
+		"host":                 "example.com",
+		"status":               float64(200),
+		"bytes":                float64(2),
+		"remote_ip":            "192.0.2.7",
+		"remote_addr":          "192.0.2.7:4000",
+		"header.User-Agent":    "curl/8.5.0",
+		"header.Authorization": redactedMarker,
+	}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("%s = %v, want %v", k, line[k], v)
+		}
+	}
+	if uri, _ := line["uri"].(string); strings.Contains(uri, "secret123") || !strings.Contains(uri, "page=2") {
+		t.Errorf("uri %q, want the token redacted and page kept", uri)
+	}
+	if id, _ := line["request_id"].(string); id == "" {
+		t.Error("no request_id")
+	}
+	for k := range line {
+		if k == "header.X-Missing" || k == "header.Cookie" {
+			t.Errorf("logged %s", k)
+		}
+	}
+	if strings.Contains(buf.String(), "abcdef") || strings.Contains(buf.String(), "hidden") {
+		t.Errorf("secret reached the log: %s", buf.String())
+	}
+}
+
+func TestParseLogFields(t *testing.T) {
+	got, err := ParseLogFields([]string{"x-forwarded-for", " CF-Ray ", ""})
+	if err != nil || strings.Join(got, ",") != "X-Forwarded-For,Cf-Ray" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	for _, bad := range []string{"User Agent", "X-Foo:", "héader", "a\nb"} {
+		if _, err := ParseLogFields([]string{"Host", bad}); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+}
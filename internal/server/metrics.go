@@ -0,0 +1,247 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets matches the Prometheus client default buckets, in seconds.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestLabels struct {
+	method string
+	path   string
+	proto  string
+	tls    string
+	status string
+}
+
+type requestSeries struct {
+	count   uint64
+	sum     float64
+	buckets []uint64
+}
+
+// metrics keeps the counters exposed on /metrics. It renders the Prometheus
+// text format itself so reflector stays free of client library dependencies.
+type metrics struct {
+	mu                sync.Mutex
+	requests          map[requestLabels]*requestSeries
+	inFlight          int64
+	bodyCaptured      uint64
+	bodyTruncated     uint64
+	collectDecodeFail uint64
+	limited           map[string]uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: make(map[requestLabels]*requestSeries),
+		limited:  map[string]uint64{"rate": 0, "concurrency": 0},
+	}
+}
+
+// instrument records request counts and latency for every response,
+// labelling the path with classify.
+func (s *Server) instrument(next http.Handler, classify func(string) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		s.metrics.addInFlight(1)
+		defer s.metrics.addInFlight(-1)
+
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		labels := requestLabels{
+			method: methodClass(r.Method),
+			path:   classify(r.URL.Path),
+			proto:  r.Proto,
+			tls:    "none",
+			status: strconv.Itoa(rec.statusCode()),
+		}
+		if r.TLS != nil {
+			labels.tls = tlsVersionName(r.TLS.Version)
+		}
+		s.metrics.observeRequest(labels, time.Since(start))
+	})
+}
+
+func (m *metrics) addInFlight(delta int64) {
+	m.mu.Lock()
+	m.inFlight += delta
+	m.mu.Unlock()
+}
+
+func (m *metrics) observeRequest(labels requestLabels, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series, ok := m.requests[labels]
+	if !ok {
+		series = &requestSeries{buckets: make([]uint64, len(latencyBuckets))}
+		m.requests[labels] = series
+	}
+	seconds := d.Seconds()
+	series.count++
+	series.sum += seconds
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			series.buckets[i]++
+		}
+	}
+}
+
+func (m *metrics) observeBody(captured int, truncated bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bodyCaptured += uint64(captured)
+	if truncated {
+		m.bodyTruncated++
+	}
+}
+
+func (m *metrics) collectDecodeFailed() {
+	m.mu.Lock()
+	m.collectDecodeFail++
+	m.mu.Unlock()
+}
+
+func (m *metrics) rateLimited(reason string) {
+	m.mu.Lock()
+	m.limited[reason]++
+	m.mu.Unlock()
+}
+
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	s.metrics.write(w)
+}
+
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]requestLabels, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+
+	fmt.Fprintln(w, "# HELP reflector_http_requests_total HTTP requests served, by method, path class, protocol, TLS version and status.")
+	fmt.Fprintln(w, "# TYPE reflector_http_requests_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "reflector_http_requests_total{%s} %d\n", k, m.requests[k].count)
+	}
+
+	fmt.Fprintln(w, "# HELP reflector_http_request_duration_seconds Time spent serving HTTP requests.")
+	fmt.Fprintln(w, "# TYPE reflector_http_request_duration_seconds histogram")
+	for _, k := range keys {
+		series := m.requests[k]
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(w, "reflector_http_request_duration_seconds_bucket{%s,le=%q} %d\n", k, formatFloat(le), series.buckets[i])
+		}
+		fmt.Fprintf(w, "reflector_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", k, series.count)
+		fmt.Fprintf(w, "reflector_http_request_duration_seconds_sum{%s} %s\n", k, formatFloat(series.sum))
+		fmt.Fprintf(w, "reflector_http_request_duration_seconds_count{%s} %d\n", k, series.count)
+	}
+
+	fmt.Fprintln(w, "# HELP reflector_http_requests_in_flight HTTP requests currently being served.")
+	fmt.Fprintln(w, "# TYPE reflector_http_requests_in_flight gauge")
+	fmt.Fprintf(w, "reflector_http_requests_in_flight %d\n", m.inFlight)
+
+	fmt.Fprintln(w, "# HELP reflector_request_body_captured_bytes_total Request body bytes captured for previews.")
+	fmt.Fprintln(w, "# TYPE reflector_request_body_captured_bytes_total counter")
+	fmt.Fprintf(w, "reflector_request_body_captured_bytes_total %d\n", m.bodyCaptured)
+
+	fmt.Fprintln(w, "# HELP reflector_request_body_truncated_total Requests whose body exceeded the capture limit.")
+	fmt.Fprintln(w, "# TYPE reflector_request_body_truncated_total counter")
+	fmt.Fprintf(w, "reflector_request_body_truncated_total %d\n", m.bodyTruncated)
+
+	fmt.Fprintln(w, "# HELP reflector_collect_decode_failures_total Browser payloads on /collect that were not valid JSON.")
+	fmt.Fprintln(w, "# TYPE reflector_collect_decode_failures_total counter")
+	fmt.Fprintf(w, "reflector_collect_decode_failures_total %d\n", m.collectDecodeFail)
+
+	fmt.Fprintln(w, "# HELP reflector_rate_limited_total Requests rejected by the rate limiter (429) or concurrency cap (503).")
+	fmt.Fprintln(w, "# TYPE reflector_rate_limited_total counter")
+	fmt.Fprintf(w, "reflector_rate_limited_total{reason=\"concurrency\"} %d\n", m.limited["concurrency"])
+	fmt.Fprintf(w, "reflector_rate_limited_total{reason=\"rate\"} %d\n", m.limited["rate"])
+}
+
+func (l requestLabels) String() string {
+	return fmt.Sprintf(`method="%s",path="%s",proto="%s",tls="%s",status="%s"`,
+		escapeLabel(l.method), escapeLabel(l.path), escapeLabel(l.proto), escapeLabel(l.tls), escapeLabel(l.status))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// methodClass folds non-standard methods together to bound label cardinality.
+func methodClass(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+// pathClass maps a request path onto the route that served it. Anything not
+// matching a fixed endpoint is served by the reflection handler.
+func pathClass(path string) string {
+	switch {
+	case path == "/healthz":
+		return "healthz"
+	case path == "/collect":
+		return "collect"
+	case path == "/ws":
+		return "ws"
+	case path == "/cookies":
+		return "cookies"
+	case path == "/metrics":
+		return "metrics"
+	case strings.HasPrefix(path, "/export/"):
+		return "export"
+	case strings.HasPrefix(path, "/replay/"):
+		return "replay"
+	case path == "/diff":
+		return "diff"
+	case path == "/share" || strings.HasPrefix(path, "/s/"):
+		return "share"
+	case strings.HasPrefix(path, assetPrefix):
+		return "assets"
+	case path == "/history" || strings.HasPrefix(path, "/history/"):
+		return "history"
+	default:
+		return "reflect"
+	}
+}
+
+// proxiedPathClass is pathClass for tee mode, where every path is forwarded
+// and the upstream's paths say nothing about reflector's routes.
+func proxiedPathClass(string) string {
+	return "reflect"
+}
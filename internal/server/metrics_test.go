@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrapeMetrics fetches /metrics from h and returns the samples by series
+// name and labels, checking every line against the text exposition format.
+func scrapeMetrics(t *testing.T, h http.Handler) map[string]string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("/metrics: %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type %q", ct)
+	}
+	sample := regexp.MustCompile(`^([a-z_]+)(\{[^}]*\})? (\S+)$`)
+	typed := map[string]string{}
+	samples := map[string]string{}
+	sc := bufio.NewScanner(w.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			name, kind, _ := strings.Cut(rest, " ")
+			typed[name] = kind
+			continue
+		}
+		m := sample.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("malformed line %q", line)
+			continue
+		}
+		family := m[1]
+		if typed[family] == "" {
+			for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+				if base := strings.TrimSuffix(family, suffix); base != family && typed[base] == "histogram" {
+					family = base
+				}
+			}
+		}
+		if typed[family] == "" {
+			t.Errorf("sample %q before its # TYPE line", line)
+		}
+		if _, err := strconv.ParseFloat(m[3], 64); err != nil {
+			t.Errorf("value in %q: %v", line, err)
+		}
+		samples[m[1]+m[2]] = m[3]
+	}
+	return samples
+}
+
+func TestMetricsExposition(t *testing.T) {
+	s := New(4096, WithLogger(discardLogger()))
+	h := s.Handler()
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/some/page", nil))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/healthz", nil))
+	bad := httptest.NewRequest(http.MethodPost, "/collect", strings.NewReader("{not json"))
+	bad.Header.Set("Content-Type", "application/json")
+	h.ServeHTTP(httptest.NewRecorder(), bad)
+
+	samples := scrapeMetrics(t, h)
+	reflect := `method="GET",path="reflect",proto="HTTP/1.1",tls="none",status="200"`
+	want := map[string]string{
+		`reflector_http_requests_total{` + reflect + `}`:                                                        "2",
+		`reflector_http_requests_total{method="GET",path="healthz",proto="HTTP/1.1",tls="none",status="200"}`:   "1",
+		`reflector_http_requests_total{method="OTHER",path="healthz",proto="HTTP/1.1",tls="none",status="200"}`: "1",
+		`reflector_http_requests_total{method="POST",path="collect",proto="HTTP/1.1",tls="none",status="400"}`:  "1",
+		`reflector_http_request_duration_seconds_count{` + reflect + `}`:                                        "2",
+		`reflector_http_request_duration_seconds_bucket{` + reflect + `,le="+Inf"}`:                             "2",
+		`reflector_http_request_duration_seconds_bucket{` + reflect + `,le="10"}`:                               "2",
+		`reflector_collect_decode_failures_total`:                                                               "1",
+		`reflector_rate_limited_total{reason="rate"}`:                                                           "0",
+		`reflector_http_requests_in_flight`:                                                                     "1",
+	}
+	for series, value := range want {
+		if got := samples[series]; got != value {
+			t.Errorf("%s = %q, want %s", series, got, value)
+		}
+	}
+
+	// Buckets are cumulative.
+	prev := 0.0
+	for _, le := range latencyBuckets {
+		v, err := strconv.ParseFloat(samples[`reflector_http_request_duration_seconds_bucket{`+reflect+`,le="`+formatFloat(le)+`"}`], 64)
+		if err != nil || v < prev {
+			t.Errorf("bucket le=%v: %v (previous %v, %v)", le, v, prev, err)
+		}
+		prev = v
+	}
+	if sum, _ := strconv.ParseFloat(samples[`reflector_http_request_duration_seconds_sum{`+reflect+`}`], 64); sum <= 0 {
+		t.Errorf("duration sum %v", sum)
+	}
+}
+
+func TestMetricsObserve(t *testing.T) {
+	m := newMetrics()
+	labels := requestLabels{method: "GET", path: "reflect", proto: "HTTP/2.0", tls: "TLS 1.3", status: "200"}
+	m.observeRequest(labels, 30*time.Millisecond)
+	m.observeRequest(labels, 3*time.Second)
+	series := m.requests[labels]
+	// 30ms falls in every bucket from 0.05 up, 3s only in 5 and 10.
+	want := []uint64{0, 0, 0, 1, 1, 1, 1, 1, 1, 2, 2}
+	for i, n := range want {
+		if series.buckets[i] != n {
+			t.Errorf("bucket le=%v: %d, want %d", latencyBuckets[i], series.buckets[i], n)
+		}
+	}
+	if series.count != 2 || series.sum < 3.029 || series.sum > 3.031 {
+		t.Errorf("count %d sum %v", series.count, series.sum)
+	}
+}
+
+func TestMetricsLabelEscaping(t *testing.T) {
+	l := requestLabels{method: "a\"b", path: `c\d`, proto: "e\nf", tls: "none", status: "200"}
+	want := `method="a\"b",path="c\\d",proto="e\nf",tls="none",status="200"`
+	if got := l.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestMetricsPathClassInTeeMode(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer upstream.Close()
+	s, front, _ := teeServer(t, upstream)
+	for _, path := range []string{"/history", "/metrics", "/export/x", "/app"} {
+		getURL(t, front+path)
+	}
+	waitForCaptures(t, s, 4)
+
+	samples := scrapeMetrics(t, s.AdminHandler())
+	for series := range samples {
+		if strings.HasPrefix(series, "reflector_http_requests_total{") && !strings.Contains(series, `path="reflect"`) {
+			t.Errorf("forwarded request labelled %s", series)
+		}
+	}
+	if got := samples[`reflector_http_requests_total{method="GET",path="reflect",proto="HTTP/1.1",tls="none",status="200"}`]; got != "4" {
+		t.Errorf("forwarded requests counted %q, want 4", got)
+	}
+}
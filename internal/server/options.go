@@ -0,0 +1,183 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Option customises a Server created with New.
+type Option func(*Server)
+
+// WithHistorySize sets how many captures are kept in memory. Zero disables
+// the history entirely.
+func WithHistorySize(n int) Option {
+	return func(s *Server) {
+		if n < 0 {
+			n = 0
+		}
+		s.historySize = n
+	}
+}
+
+// WithProxyProtocol accepts PROXY protocol headers on the TCP and UDP echo
+// listeners from peers in sources. Anyone else's header is echoed as data.
+func WithProxyProtocol(sources []*net.IPNet) Option {
+	return func(s *Server) {
+		s.proxyProtoFrom = sources
+	}
+}
+
+// WithLogger sets the structured logger used for access and error logs.
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Server) {
+		if l != nil {
+			s.logger = l
+		}
+	}
+}
+
+// WithLogFields adds the named request headers to every access log line.
+func WithLogFields(headers []string) Option {
+	return func(s *Server) {
+		for _, h := range headers {
+			if h = strings.TrimSpace(h); h != "" {
+				s.logFields = append(s.logFields, h)
+			}
+		}
+	}
+}
+
+// WithOTLPEndpoint exports a server span for every request to the given
+// OTLP/HTTP collector URL, e.g. http://localhost:4318.
+func WithOTLPEndpoint(endpoint string) Option {
+	return func(s *Server) {
+		s.otlpURL = strings.TrimSpace(endpoint)
+	}
+}
+
+// WithRequestIDHeader changes the header used to accept and echo request IDs.
+func WithRequestIDHeader(name string) Option {
+	return func(s *Server) {
+		if name = strings.TrimSpace(name); name != "" {
+			s.requestIDHeader = http.CanonicalHeaderKey(name)
+		}
+	}
+}
+
+// WithRedactionPolicy replaces the default redaction policy; nil disables
+// redaction entirely. A policy built as a literal is validated here and
+// panics if invalid, like regexp.MustCompile; ParseRedactionPolicy returns
+// the same errors instead.
+func WithRedactionPolicy(p *RedactionPolicy) Option {
+	return func(s *Server) {
+		if p == nil {
+			s.redaction = nil
+			return
+		}
+		compiled := *p
+		compiled.patterns = nil
+		if err := compiled.compile(); err != nil {
+			panic(err)
+		}
+		s.redaction = &compiled
+	}
+}
+
+// WithAuth requires authentication on the history and metrics endpoints. The
+// reflection, browser collection, WebSocket and health endpoints stay open.
+func WithAuth(cfg AuthConfig) Option {
+	return func(s *Server) {
+		s.auth = &cfg
+	}
+}
+
+// WithRateLimit enables per-client token bucket limits and a global
+// concurrency cap.
+func WithRateLimit(cfg RateLimitConfig) Option {
+	return func(s *Server) {
+		s.rateLimit = cfg
+	}
+}
+
+// WithClientHints sends Accept-CH for the given hints so browsers include
+// them in later requests. Critical hints are also sent as Critical-CH, which
+// makes the browser retry the first request with them.
+func WithClientHints(accept, critical []string) Option {
+	return func(s *Server) {
+		seen := make(map[string]bool)
+		add := func(h string) {
+			if h = strings.TrimSpace(h); h != "" && !seen[strings.ToLower(h)] {
+				seen[strings.ToLower(h)] = true
+				s.acceptCH = append(s.acceptCH, hintDisplayName(h))
+			}
+		}
+		for _, h := range accept {
+			add(h)
+		}
+		for _, h := range critical {
+			if h = strings.TrimSpace(h); h != "" {
+				add(h)
+				s.criticalCH = append(s.criticalCH, hintDisplayName(h))
+			}
+		}
+	}
+}
+
+// WithGeoIP annotates client addresses with location and network details.
+func WithGeoIP(g *GeoIP) Option {
+	return func(s *Server) {
+		s.geoip = g
+	}
+}
+
+// WithIPRanges tags client addresses that fall into the given named ranges.
+func WithIPRanges(r *IPRanges) Option {
+	return func(s *Server) {
+		s.ipRanges = r
+	}
+}
+
+// WithReverseDNS looks up PTR records for the peer and confirms them with a
+// forward lookup. When the peer is in trustedProxies, the client it names in
+// X-Forwarded-For is looked up instead. A nil resolver uses the system one.
+func WithReverseDNS(r Resolver, timeout time.Duration, trustedProxies []*net.IPNet) Option {
+	return func(s *Server) {
+		s.rdns = newReverseDNS(r, timeout, trustedProxies)
+	}
+}
+
+// WithTemplates renders the HTML pages with custom templates. A nil value
+// keeps the built-in ones.
+func WithTemplates(t *Templates) Option {
+	return func(s *Server) {
+		if t != nil {
+			s.templates = t
+		}
+	}
+}
+
+// WithShareTTL sets how long shared permalinks stay valid. Zero disables
+// sharing.
+func WithShareTTL(ttl time.Duration) Option {
+	return func(s *Server) {
+		s.shareTTL = ttl
+	}
+}
+
+// WithReplay lets captured requests be sent again to the configured targets.
+func WithReplay(cfg ReplayConfig) Option {
+	return func(s *Server) {
+		s.replay = cfg
+	}
+}
+
+// WithProxy forwards requests to cfg.Upstream instead of rendering the
+// reflection page, capturing both sides of each exchange.
+func WithProxy(cfg ProxyConfig) Option {
+	return func(s *Server) {
+		s.proxy = cfg
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	otlpQueueSize     = 1024
+	otlpBatchSize     = 100
+	otlpFlushInterval = 5 * time.Second
+	otlpTimeout       = 5 * time.Second
+	otlpServiceName   = "reflector"
+	otlpSpanKindSrv   = 2
+	otlpStatusError   = 2
+)
+
+// otlpSpan is the data recorded for one request before it is encoded.
+type otlpSpan struct {
+	span      serverSpan
+	name      string
+	start     time.Time
+	end       time.Time
+	status    int
+	method    string
+	path      string
+	pathClass string
+	proto     string
+	host      string
+	clientIP  string
+	userAgent string
+	requestID string
+}
+
+// spanExporter batches spans and POSTs them to an OTLP/HTTP endpoint using
+// the JSON encoding, which keeps reflector free of protobuf dependencies.
+type spanExporter struct {
+	endpoint string
+	client   *http.Client
+	queue    chan otlpSpan
+	dropped  atomic.Uint64
+	logger   *slog.Logger
+	stop     chan context.Context
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func newSpanExporter(endpoint string, logger *slog.Logger) *spanExporter {
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	e := &spanExporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: otlpTimeout},
+		queue:    make(chan otlpSpan, otlpQueueSize),
+		logger:   logger,
+		stop:     make(chan context.Context, 1),
+		stopped:  make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// export queues a span without blocking; spans are dropped when the
+// collector cannot keep up.
+func (e *spanExporter) export(span otlpSpan) {
+	select {
+	case e.queue <- span:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// shutdown sends every queued span and stops the exporter. Spans exported
+// afterwards are dropped. It gives up when ctx is done.
+func (e *spanExporter) shutdown(ctx context.Context) error {
+	e.stopOnce.Do(func() { e.stop <- ctx })
+	select {
+	case <-e.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *spanExporter) run() {
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+	batch := make([]otlpSpan, 0, otlpBatchSize)
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) < otlpBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case ctx := <-e.stop:
+			e.drain(ctx, batch)
+			close(e.stopped)
+			return
+		}
+		e.send(context.Background(), batch)
+		batch = batch[:0]
+	}
+}
+
+// drain sends batch and whatever is still queued.
+func (e *spanExporter) drain(ctx context.Context, batch []otlpSpan) {
+	for ctx.Err() == nil {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) < otlpBatchSize {
+				continue
+			}
+		default:
+			if len(batch) > 0 {
+				e.send(ctx, batch)
+			}
+			return
+		}
+		e.send(ctx, batch)
+		batch = batch[:0]
+	}
+}
+
+func (e *spanExporter) send(ctx context.Context, batch []otlpSpan) {
+	payload, err := json.Marshal(encodeOTLP(batch))
+	if err != nil {
+		e.logger.Error("encode otlp spans", "err", err)
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		e.logger.Error("export otlp spans", "err", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		e.logger.Warn("export otlp spans", "endpoint", e.endpoint, "spans", len(batch), "err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		e.logger.Warn("export otlp spans", "endpoint", e.endpoint, "spans", len(batch), "status", resp.StatusCode)
+	}
+	if dropped := e.dropped.Swap(0); dropped > 0 {
+		e.logger.Warn("dropped otlp spans", "count", dropped)
+	}
+}
+
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func stringAttr(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]any{"stringValue": value}}
+}
+
+func intAttr(key string, value int) otlpAttribute {
+	// OTLP JSON encodes 64-bit integers as strings.
+	return otlpAttribute{Key: key, Value: map[string]any{"intValue": strconv.Itoa(value)}}
+}
+
+// encodeOTLP builds an ExportTraceServiceRequest in its JSON form.
+func encodeOTLP(batch []otlpSpan) map[string]any {
+	spans := make([]map[string]any, 0, len(batch))
+	for _, s := range batch {
+		attrs := []otlpAttribute{
+			stringAttr("http.request.method", s.method),
+			stringAttr("url.path", s.path),
+			stringAttr("reflector.path_class", s.pathClass),
+			intAttr("http.response.status_code", s.status),
+			stringAttr("network.protocol.version", strings.TrimPrefix(s.proto, "HTTP/")),
+			stringAttr("server.address", s.host),
+			stringAttr("client.address", s.clientIP),
+		}
+		if s.userAgent != "" {
+			attrs = append(attrs, stringAttr("user_agent.original", s.userAgent))
+		}
+		if s.requestID != "" {
+			attrs = append(attrs, stringAttr("reflector.request_id", s.requestID))
+		}
+		span := map[string]any{
+			"traceId":           s.span.TraceID,
+			"spanId":            s.span.SpanID,
+			"name":              s.name,
+			"kind":              otlpSpanKindSrv,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        attrs,
+			"status":            map[string]any{},
+		}
+		if s.span.ParentSpanID != "" {
+			span["parentSpanId"] = s.span.ParentSpanID
+		}
+		if s.status >= 500 {
+			span["status"] = map[string]any{"code": otlpStatusError}
+		}
+		spans = append(spans, span)
+	}
+	return map[string]any{
+		"resourceSpans": []any{
+			map[string]any{
+				"resource": map[string]any{
+					"attributes": []otlpAttribute{stringAttr("service.name", otlpServiceName)},
+				},
+				"scopeSpans": []any{
+					map[string]any{
+						"scope": map[string]any{"name": "github.com/byteherder/reflector"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type receivedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+}
+
+// otlpReceiver stands in for a collector's OTLP/HTTP traces endpoint.
+type otlpReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests int
+	spans    []receivedSpan
+}
+
+func newOTLPReceiver(t *testing.T) *otlpReceiver {
+	rcv := &otlpReceiver{}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected %s %s (%s)", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []receivedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode export request: %v", err)
+		}
+		rcv.mu.Lock()
+		defer rcv.mu.Unlock()
+		rcv.requests++
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				rcv.spans = append(rcv.spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(rcv.Close)
+	return rcv
+}
+
+func (rcv *otlpReceiver) received() (int, []receivedSpan) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return rcv.requests, append([]receivedSpan(nil), rcv.spans...)
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestSpanExporterShutdownFlushes(t *testing.T) {
+	rcv := newOTLPReceiver(t)
+	e := newSpanExporter(rcv.URL, discardLogger())
+	const total = otlpBatchSize + 5
+	for i := 0; i < total; i++ {
+		e.export(otlpSpan{span: serverSpan{TraceID: randomHex(16), SpanID: randomHex(8)}, name: "GET /", start: time.Now(), end: time.Now()})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	requests, spans := rcv.received()
+	if len(spans) != total || requests != 2 {
+		t.Errorf("collector got %d spans in %d requests, want %d in 2", len(spans), requests, total)
+	}
+	// Later spans are dropped rather than queued forever.
+	e.export(otlpSpan{name: "late"})
+	if err := e.shutdown(ctx); err != nil {
+		t.Errorf("second shutdown: %v", err)
+	}
+	if _, spans := rcv.received(); len(spans) != total {
+		t.Errorf("collector got %d spans after shutdown", len(spans)-total)
+	}
+}
+
+func TestSpanExporterShutdownDeadline(t *testing.T) {
+	release := make(chan struct{})
+	stuck := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer stuck.Close()
+	defer close(release)
+
+	e := newSpanExporter(stuck.URL, discardLogger())
+	e.export(otlpSpan{name: "GET /"})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := e.shutdown(ctx); err == nil {
+		t.Error("shutdown returned before the collector answered")
+	}
+}
+
+func TestServerExportsRequestSpans(t *testing.T) {
+	rcv := newOTLPReceiver(t)
+	s := New(4096, WithOTLPEndpoint(rcv.URL), WithLogger(discardLogger()))
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	s.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	_, spans := rcv.received()
+	if len(spans) != 1 {
+		t.Fatalf("collector got %d spans, want 1", len(spans))
+	}
+	got := spans[0]
+	if got.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || got.ParentSpanID != "00f067aa0ba902b7" || len(got.SpanID) != 16 {
+		t.Errorf("span %+v does not continue the incoming trace", got)
+	}
+}
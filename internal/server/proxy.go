@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProxyConfig puts reflector in tee mode: requests are forwarded to
+// Upstream, its response is returned to the client, and both sides are
+// captured. Reflector's own endpoints other than the reflection page keep
+// being served locally.
+type ProxyConfig struct {
+	Upstream *url.URL
+	// RewriteHost sends the upstream's host in the Host header instead of
+	// the one the client used.
+	RewriteHost bool
+}
+
+// ParseUpstream parses a base URL such as http://127.0.0.1:8081 or
+// https://origin.internal/app. An empty value disables tee mode.
+func ParseUpstream(value string) (*url.URL, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	return parseBaseURL("upstream", value)
+}
+
+// forwardedHeaders are removed by httputil.ReverseProxy before Rewrite runs.
+// Tee mode puts them back so the upstream sees what the CDN sent, with the
+// peer appended to X-Forwarded-For.
+var forwardedHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"}
+
+// upstreamExchange is the upstream side of a proxied request.
+type upstreamExchange struct {
+	URL              string              `json:"url"`
+	Host             string              `json:"host"`
+	Proto            string              `json:"proto,omitempty"`
+	Status           int                 `json:"status,omitempty"`
+	StatusText       string              `json:"status_text,omitempty"`
+	Headers          map[string][]string `json:"headers,omitempty"`
+	BodyPreview      string              `json:"body_preview,omitempty"`
+	BodyBytes        int64               `json:"body_bytes"`
+	BodyTruncated    bool                `json:"body_truncated,omitempty"`
+	BodyDecompressed bool                `json:"body_decompressed,omitempty"`
+	HeadersMS        float64             `json:"headers_ms,omitempty"`
+	DurationMS       float64             `json:"duration_ms"`
+	Error            string              `json:"error,omitempty"`
+	// Redactions lists what the redaction policy hid in the response.
+	Redactions []string `json:"redactions,omitempty"`
+}
+
+// teeBody passes a body through unchanged while keeping its first limit
+// bytes. size is the declared length, or -1 when unknown.
+type teeBody struct {
+	io.ReadCloser
+	limit int
+	size  int64
+	buf   []byte
+	n     int64
+	eof   bool
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	t.n += int64(n)
+	if room := t.limit - len(t.buf); room > 0 {
+		t.buf = append(t.buf, p[:min(n, room)]...)
+	}
+	if err == io.EOF {
+		t.eof = true
+	}
+	return n, err
+}
+
+// truncated reports whether buf is less than the whole body: either the
+// body went past the limit, or the reader stopped before the end, as an
+// upstream that answers without reading the request does.
+func (t *teeBody) truncated() bool {
+	if t.n > int64(len(t.buf)) {
+		return true
+	}
+	return !t.eof && t.n != t.size
+}
+
+// proxyHandler forwards r to the upstream and records the exchange once the
+// response has been relayed, or the attempt failed.
+func (s *Server) proxyHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody := &teeBody{ReadCloser: r.Body, limit: s.bodyCap, size: r.ContentLength}
+	r.Body = reqBody
+	up := &upstreamExchange{}
+	var respBody *teeBody
+	start := time.Now()
+
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			s.rewriteUpstream(pr)
+			up.URL = pr.Out.URL.String()
+			up.Host = pr.Out.Host
+			if up.Host == "" {
+				up.Host = pr.Out.URL.Host
+			}
+		},
+		Transport: s.proxyTransport,
+		ModifyResponse: func(resp *http.Response) error {
+			up.HeadersMS = float64(time.Since(start).Microseconds()) / 1000
+			up.Proto = resp.Proto
+			up.Status = resp.StatusCode
+			up.StatusText = strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode)))
+			up.Headers = cloneHeader(resp.Header)
+			// A 101 body is the upgraded connection and must stay as it is.
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				respBody = &teeBody{ReadCloser: resp.Body, limit: s.bodyCap, size: resp.ContentLength}
+				resp.Body = respBody
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			up.Error = err.Error()
+			s.logger.Warn("proxy upstream", "url", up.URL, "err", err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+		ErrorLog: slog.NewLogLogger(s.logger.Handler(), slog.LevelWarn),
+	}
+
+	// ReverseProxy aborts the handler with a panic when the client goes away
+	// mid-body; record what was relayed up to that point as well.
+	defer func() {
+		up.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+		if respBody != nil {
+			up.BodyBytes, up.BodyTruncated = respBody.n, respBody.truncated()
+			data, decoded := gunzipPreview(http.Header(up.Headers), respBody.buf, s.bodyCap)
+			up.BodyDecompressed = decoded
+			// Keep compressed bytes other than gzip out of the record.
+			if decoded || http.Header(up.Headers).Get("Content-Encoding") == "" {
+				up.BodyPreview = string(data)
+			}
+		}
+		s.metrics.observeBody(len(reqBody.buf), reqBody.truncated())
+		data := s.describeRequest(r, reqBody.buf, reqBody.truncated(), nil)
+		data.Upstream = up
+		// The client already has its response, so reverse DNS must not hold
+		// up the handler, and r's context is cancelled once it returns.
+		go s.storeProxyCapture(context.WithoutCancel(r.Context()), data, s.rdns.subject(r))
+	}()
+	rp.ServeHTTP(w, r)
+}
+
+func (s *Server) storeProxyCapture(ctx context.Context, data reflection, rdnsSubject string) {
+	s.annotateAddresses(ctx, &data, rdnsSubject)
+	s.redaction.apply(&data)
+	s.history.add(capture{Kind: captureHTTP, Timestamp: data.Timestamp, HTTP: &data})
+}
+
+// rewriteUpstream points the outbound request at the upstream, keeping the
+// client's Host and forwarding headers unless told otherwise.
+func (s *Server) rewriteUpstream(pr *httputil.ProxyRequest) {
+	target := s.proxy.Upstream
+	pr.Out.URL.Scheme = target.Scheme
+	pr.Out.URL.Host = target.Host
+	pr.Out.URL.Path = target.Path + pr.In.URL.Path
+	if pr.In.URL.RawPath != "" {
+		pr.Out.URL.RawPath = target.EscapedPath() + pr.In.URL.RawPath
+	}
+	if s.proxy.RewriteHost {
+		pr.Out.Host = ""
+	}
+	for _, name := range forwardedHeaders {
+		if values, ok := pr.In.Header[name]; ok {
+			pr.Out.Header[name] = append([]string(nil), values...)
+		}
+	}
+	// Add reflector as a hop, as any proxy would, so the upstream can still
+	// tell the CDN's address from its own.
+	if ip, _, err := net.SplitHostPort(pr.In.RemoteAddr); err == nil {
+		prior := strings.Join(pr.In.Header.Values("X-Forwarded-For"), ", ")
+		if prior != "" {
+			ip = prior + ", " + ip
+		}
+		pr.Out.Header.Set("X-Forwarded-For", ip)
+	}
+}
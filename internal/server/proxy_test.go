@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// teeServer starts reflector in tee mode in front of upstream and returns
+// its front and admin URLs.
+func teeServer(t *testing.T, upstream *httptest.Server, opts ...Option) (*Server, string, string) {
+	t.Helper()
+	u, err := ParseUpstream(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]Option{WithProxy(ProxyConfig{Upstream: u}), WithLogger(discardLogger())}, opts...)
+	s := New(4096, opts...)
+	front := httptest.NewServer(s.Handler())
+	t.Cleanup(front.Close)
+	admin := httptest.NewServer(s.AdminHandler())
+	t.Cleanup(admin.Close)
+	return s, front.URL, admin.URL
+}
+
+func getBody(t *testing.T, req *http.Request) (int, string) {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func getURL(t *testing.T, rawURL string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return getBody(t, req)
+}
+
+// waitForCaptures polls history until it holds n HTTP captures; tee mode
+// stores them after the response has been relayed.
+func waitForCaptures(t *testing.T, s *Server, n int) []capture {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c := s.history.list(captureHTTP)
+		if len(c) >= n {
+			return c
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("history has %d captures, want %d", len(c), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTeeForwardsEveryPath(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "upstream "+r.URL.Path)
+	}))
+	defer upstream.Close()
+	s, front, admin := teeServer(t, upstream)
+
+	paths := []string{"/", "/healthz", "/metrics", "/history", "/export/x.curl", "/diff", "/assets/app.css", "/s/abc"}
+	for _, path := range paths {
+		if code, body := getURL(t, front+path); code != http.StatusOK || body != "upstream "+path {
+			t.Errorf("GET %s: %d %q, want it forwarded", path, code, body)
+		}
+	}
+	waitForCaptures(t, s, len(paths))
+
+	if code, body := getURL(t, admin+"/healthz"); code != http.StatusOK || body != "ok" {
+		t.Errorf("admin /healthz: %d %q", code, body)
+	}
+	if code, _ := getURL(t, admin+"/history"); code != http.StatusOK {
+		t.Errorf("admin /history: %d", code)
+	}
+	if code, _ := getURL(t, admin+"/reflect-me"); code != http.StatusNotFound {
+		t.Errorf("admin listener served a non-admin path: %d", code)
+	}
+}
+
+func TestTeeAppendsPeerToForwardedFor(t *testing.T) {
+	seen := make(chan []string, 2)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header.Values("X-Forwarded-For")
+	}))
+	defer upstream.Close()
+	_, front, _ := teeServer(t, upstream)
+
+	req, _ := http.NewRequest(http.MethodGet, front+"/", nil)
+	getBody(t, req)
+	if got := <-seen; len(got) != 1 || got[0] != "127.0.0.1" {
+		t.Errorf("without a prior hop: X-Forwarded-For %q", got)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, front+"/", nil)
+	req.Header.Add("X-Forwarded-For", "192.0.2.1")
+	req.Header.Add("X-Forwarded-For", "198.51.100.2")
+	getBody(t, req)
+	if got := <-seen; len(got) != 1 || got[0] != "192.0.2.1, 198.51.100.2, 127.0.0.1" {
+		t.Errorf("with prior hops: X-Forwarded-For %q", got)
+	}
+}
+
+// blockingResolver holds PTR lookups until release is closed.
+type blockingResolver struct {
+	release chan struct{}
+}
+
+func (b blockingResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	select {
+	case <-b.release:
+		return []string{"peer.example."}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (b blockingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+}
+
+func TestTeeAnnotatesAfterResponding(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "done")
+	}))
+	defer upstream.Close()
+	res := blockingResolver{release: make(chan struct{})}
+	s, front, _ := teeServer(t, upstream, WithReverseDNS(res, 5*time.Second, nil))
+
+	// The response arrives while the lookup is still blocked.
+	if code, body := getURL(t, front+"/slow-dns"); code != http.StatusOK || body != "done" {
+		t.Fatalf("got %d %q", code, body)
+	}
+	if c := s.history.list(captureHTTP); len(c) != 0 {
+		t.Fatalf("capture stored before reverse DNS finished: %+v", c)
+	}
+	close(res.release)
+
+	// The request's context is gone by now; the lookup must not have been
+	// cancelled with it.
+	ref := waitForCaptures(t, s, 1)[0].HTTP
+	if ref.Upstream == nil || ref.Upstream.BodyPreview != "done" {
+		t.Errorf("upstream %+v", ref.Upstream)
+	}
+	var rdns *reverseDNSResult
+	for _, a := range ref.Addresses {
+		if a.ReverseDNS != nil {
+			rdns = a.ReverseDNS
+		}
+	}
+	if rdns == nil || rdns.Error != "" || rdns.Hostname != "peer.example" || !rdns.ForwardConfirmed {
+		t.Errorf("reverse DNS %+v", rdns)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestTeeRequestPreviewCompleteness(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	defer upstream.Close()
+	s, front, _ := teeServer(t, upstream)
+	// The upstream reads a few bytes of the request body and answers without
+	// the rest.
+	s.proxyTransport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Body != nil {
+			if _, err := io.ReadFull(r.Body, make([]byte, 3)); err != nil {
+				return nil, err
+			}
+		}
+		return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("ok")), ContentLength: 2, Request: r}, nil
+	})
+
+	tests := []struct {
+		name      string
+		body      string
+		chunked   bool
+		preview   string
+		truncated bool
+	}{
+		{name: "stopped early", body: "abcdef", preview: "abc", truncated: true},
+		{name: "stopped early, chunked", body: "abcdef", chunked: true, preview: "abc", truncated: true},
+		{name: "read exactly", body: "abc", preview: "abc"},
+	}
+	for i, tt := range tests {
+		req, _ := http.NewRequest(http.MethodPost, front+"/upload", strings.NewReader(tt.body))
+		if tt.chunked {
+			req.ContentLength = -1
+		}
+		if code, _ := getBody(t, req); code != http.StatusOK {
+			t.Fatalf("%s: status %d", tt.name, code)
+		}
+		ref := waitForCaptures(t, s, i+1)[0].HTTP
+		if ref.BodyPreview != tt.preview || ref.BodyTruncated != tt.truncated {
+			t.Errorf("%s: preview %q, truncated %v", tt.name, ref.BodyPreview, ref.BodyTruncated)
+		}
+		if ref.Upstream.BodyTruncated {
+			t.Errorf("%s: relayed response marked truncated", tt.name)
+		}
+	}
+
+	// A request without a body has nothing to miss.
+	getURL(t, front+"/")
+	if ref := waitForCaptures(t, s, len(tests)+1)[0].HTTP; ref.BodyTruncated {
+		t.Error("GET marked truncated")
+	}
+}
+
+func TestParseUpstream(t *testing.T) {
+	for _, bad := range []string{"ftp://origin", "http://", "http://user:pw@origin", "http://origin/?q=1"} {
+		if _, err := ParseUpstream(bad); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+	u, err := ParseUpstream(" https://origin.internal/app/ ")
+	if err != nil || u.String() != (&url.URL{Scheme: "https", Host: "origin.internal", Path: "/app"}).String() {
+		t.Errorf("got %v, %v", u, err)
+	}
+	if u, err := ParseUpstream(""); u != nil || err != nil {
+		t.Errorf("empty value: %v, %v", u, err)
+	}
+}
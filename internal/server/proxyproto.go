@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// proxyV2Signature prefixes every binary PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const proxyV1MaxLength = 107
+
+// proxyHeader is the decoded HAProxy PROXY protocol header sent by a load
+// balancer ahead of the client's own bytes.
+type proxyHeader struct {
+	Version    int        `json:"version"`
+	Command    string     `json:"command"`
+	Transport  string     `json:"transport"`
+	SourceAddr string     `json:"source_addr,omitempty"`
+	DestAddr   string     `json:"dest_addr,omitempty"`
+	TLVs       []proxyTLV `json:"tlvs,omitempty"`
+}
+
+type proxyTLV struct {
+	Type   string `json:"type"`
+	Length int    `json:"length"`
+}
+
+// readProxyHeader consumes a PROXY header from br if one is present. It
+// returns nil without consuming anything when the stream does not start with
+// a PROXY signature.
+func readProxyHeader(br *bufio.Reader) (*proxyHeader, error) {
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		prefix, err := br.Peek(6)
+		if err != nil || string(prefix) != "PROXY " {
+			return nil, nil
+		}
+		return readProxyV1(br)
+	case '\r':
+		prefix, err := br.Peek(len(proxyV2Signature))
+		if err != nil || !bytes.Equal(prefix, proxyV2Signature) {
+			return nil, nil
+		}
+		header, err := br.Peek(16)
+		if err != nil {
+			return nil, err
+		}
+		total := 16 + int(binary.BigEndian.Uint16(header[14:16]))
+		raw := make([]byte, total)
+		if _, err := io.ReadFull(br, raw); err != nil {
+			return nil, err
+		}
+		hdr, _, err := parseProxyV2(raw)
+		return hdr, err
+	}
+	return nil, nil
+}
+
+func readProxyV1(br *bufio.Reader) (*proxyHeader, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLength {
+			return nil, errors.New("proxy v1 header too long")
+		}
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(strings.TrimSuffix(string(line), "\r\n"))
+	hdr := &proxyHeader{Version: 1, Command: "PROXY"}
+	if len(fields) < 2 {
+		return nil, errors.New("malformed proxy v1 header")
+	}
+	hdr.Transport = fields[1]
+	if hdr.Transport == "UNKNOWN" {
+		return hdr, nil
+	}
+	if len(fields) != 6 {
+		return nil, errors.New("malformed proxy v1 header")
+	}
+	for _, port := range fields[4:] {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return nil, fmt.Errorf("malformed proxy v1 port %q", port)
+		}
+	}
+	hdr.SourceAddr = net.JoinHostPort(fields[2], fields[4])
+	hdr.DestAddr = net.JoinHostPort(fields[3], fields[5])
+	return hdr, nil
+}
+
+// parseProxyV2 decodes a binary header at the start of raw and returns it
+// together with the number of bytes it occupied.
+func parseProxyV2(raw []byte) (*proxyHeader, int, error) {
+	if len(raw) < 16 || !bytes.HasPrefix(raw, proxyV2Signature) {
+		return nil, 0, errors.New("missing proxy v2 signature")
+	}
+	if raw[12]>>4 != 2 {
+		return nil, 0, fmt.Errorf("unsupported proxy protocol version %d", raw[12]>>4)
+	}
+	length := int(binary.BigEndian.Uint16(raw[14:16]))
+	if len(raw) < 16+length {
+		return nil, 0, errors.New("truncated proxy v2 header")
+	}
+	body := raw[16 : 16+length]
+
+	hdr := &proxyHeader{Version: 2, Command: "LOCAL"}
+	if raw[12]&0x0f == 0x1 {
+		hdr.Command = "PROXY"
+	}
+
+	family, proto := raw[13]>>4, raw[13]&0x0f
+	var addrLen int
+	switch family {
+	case 0x1:
+		hdr.Transport, addrLen = "TCP4", 12
+	case 0x2:
+		hdr.Transport, addrLen = "TCP6", 36
+	case 0x3:
+		hdr.Transport, addrLen = "UNIX", 216
+	default:
+		hdr.Transport = "UNSPEC"
+	}
+	if proto == 0x2 && (family == 0x1 || family == 0x2) {
+		hdr.Transport = strings.Replace(hdr.Transport, "TCP", "UDP", 1)
+	}
+	if len(body) < addrLen {
+		return nil, 0, errors.New("truncated proxy v2 address block")
+	}
+
+	switch family {
+	case 0x1, 0x2:
+		ipLen := addrLen/2 - 2
+		src := net.IP(body[:ipLen])
+		dst := net.IP(body[ipLen : 2*ipLen])
+		sport := binary.BigEndian.Uint16(body[2*ipLen:])
+		dport := binary.BigEndian.Uint16(body[2*ipLen+2:])
+		hdr.SourceAddr = net.JoinHostPort(src.String(), strconv.Itoa(int(sport)))
+		hdr.DestAddr = net.JoinHostPort(dst.String(), strconv.Itoa(int(dport)))
+	case 0x3:
+		hdr.SourceAddr = string(bytes.TrimRight(body[:108], "\x00"))
+		hdr.DestAddr = string(bytes.TrimRight(body[108:216], "\x00"))
+	}
+
+	tlvs := body[addrLen:]
+	for len(tlvs) >= 3 {
+		n := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+n {
+			break
+		}
+		hdr.TLVs = append(hdr.TLVs, proxyTLV{Type: proxyTLVName(tlvs[0]), Length: n})
+		tlvs = tlvs[3+n:]
+	}
+	return hdr, 16 + length, nil
+}
+
+func proxyTLVName(t byte) string {
+	switch t {
+	case 0x01:
+		return "ALPN"
+	case 0x02:
+		return "AUTHORITY"
+	case 0x03:
+		return "CRC32C"
+	case 0x04:
+		return "NOOP"
+	case 0x05:
+		return "UNIQUE_ID"
+	case 0x20:
+		return "SSL"
+	case 0x30:
+		return "NETNS"
+	default:
+		return fmt.Sprintf("0x%02x", t)
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// proxyV2 builds a binary PROXY header with the given command nibble,
+// family/protocol byte and address block.
+func proxyV2(cmd, famProto byte, body []byte) []byte {
+	raw := append([]byte(nil), proxyV2Signature...)
+	raw = append(raw, 0x20|cmd, famProto)
+	raw = binary.BigEndian.AppendUint16(raw, uint16(len(body)))
+	return append(raw, body...)
+}
+
+func ipv4Block(src, dst string, sport, dport uint16) []byte {
+	b := append(net.ParseIP(src).To4(), net.ParseIP(dst).To4()...)
+	b = binary.BigEndian.AppendUint16(b, sport)
+	return binary.BigEndian.AppendUint16(b, dport)
+}
+
+func TestReadProxyHeaderV1(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    *proxyHeader
+		wantErr bool
+		rest    string
+	}{
+		{
+			name:  "tcp4",
+			input: "PROXY TCP4 192.0.2.1 198.51.100.2 5000 80\r\nhello",
+			want:  &proxyHeader{Version: 1, Command: "PROXY", Transport: "TCP4", SourceAddr: "192.0.2.1:5000", DestAddr: "198.51.100.2:80"},
+			rest:  "hello",
+		},
+		{
+			name:  "tcp6",
+			input: "PROXY TCP6 2001:db8::1 2001:db8::2 5000 443\r\n",
+			want:  &proxyHeader{Version: 1, Command: "PROXY", Transport: "TCP6", SourceAddr: "[2001:db8::1]:5000", DestAddr: "[2001:db8::2]:443"},
+		},
+		{
+			name:  "unknown",
+			input: "PROXY UNKNOWN\r\nx",
+			want:  &proxyHeader{Version: 1, Command: "PROXY", Transport: "UNKNOWN"},
+			rest:  "x",
+		},
+		{name: "no header", input: "GET / HTTP/1.1\r\n", rest: "GET / HTTP/1.1\r\n"},
+		{name: "looks like P", input: "PING\r\n", rest: "PING\r\n"},
+		{name: "missing fields", input: "PROXY TCP4 192.0.2.1\r\n", wantErr: true},
+		{name: "only keyword", input: "PROXY \r\n", wantErr: true},
+		{name: "bad port", input: "PROXY TCP4 192.0.2.1 198.51.100.2 70000 80\r\n", wantErr: true},
+		{name: "non-numeric port", input: "PROXY TCP4 192.0.2.1 198.51.100.2 x 80\r\n", wantErr: true},
+		{name: "no terminator", input: "PROXY TCP4 192.0.2.1 198.51.100.2 5000 80", wantErr: true},
+		{name: "too long", input: "PROXY " + strings.Repeat("A", 200) + "\r\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br := bufio.NewReader(strings.NewReader(tt.input))
+			got, err := readProxyHeader(br)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readProxyHeader: %v", err)
+			}
+			if !equalProxyHeaders(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			rest, _ := io.ReadAll(br)
+			if string(rest) != tt.rest {
+				t.Errorf("left %q unread, want %q", rest, tt.rest)
+			}
+		})
+	}
+}
+
+func TestParseProxyV2(t *testing.T) {
+	tcp4 := ipv4Block("192.0.2.1", "198.51.100.2", 5000, 443)
+	tcp6 := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0x13, 0x88, 0x01, 0xbb)
+	unix := make([]byte, 216)
+	copy(unix, "/run/src.sock")
+	copy(unix[108:], "/run/dst.sock")
+	withTLVs := append(append([]byte(nil), tcp4...), 0x01, 0x00, 0x02, 'h', '2', 0x05, 0x00, 0x01, 'x')
+
+	tests := []struct {
+		name string
+		raw  []byte
+		want *proxyHeader
+	}{
+		{"tcp4", proxyV2(0x1, 0x11, tcp4), &proxyHeader{Version: 2, Command: "PROXY", Transport: "TCP4", SourceAddr: "192.0.2.1:5000", DestAddr: "198.51.100.2:443"}},
+		{"udp4", proxyV2(0x1, 0x12, tcp4), &proxyHeader{Version: 2, Command: "PROXY", Transport: "UDP4", SourceAddr: "192.0.2.1:5000", DestAddr: "198.51.100.2:443"}},
+		{"tcp6", proxyV2(0x1, 0x21, tcp6), &proxyHeader{Version: 2, Command: "PROXY", Transport: "TCP6", SourceAddr: "[2001:db8::1]:5000", DestAddr: "[2001:db8::2]:443"}},
+		{"unix", proxyV2(0x1, 0x31, unix), &proxyHeader{Version: 2, Command: "PROXY", Transport: "UNIX", SourceAddr: "/run/src.sock", DestAddr: "/run/dst.sock"}},
+		{"local", proxyV2(0x0, 0x00, nil), &proxyHeader{Version: 2, Command: "LOCAL", Transport: "UNSPEC"}},
+		{"tlvs", proxyV2(0x1, 0x11, withTLVs), &proxyHeader{Version: 2, Command: "PROXY", Transport: "TCP4", SourceAddr: "192.0.2.1:5000", DestAddr: "198.51.100.2:443",
+			TLVs: []proxyTLV{{Type: "ALPN", Length: 2}, {Type: "UNIQUE_ID", Length: 1}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, n, err := parseProxyV2(append(tt.raw, "payload"...))
+			if err != nil {
+				t.Fatalf("parseProxyV2: %v", err)
+			}
+			if n != len(tt.raw) {
+				t.Errorf("consumed %d bytes, want %d", n, len(tt.raw))
+			}
+			if !equalProxyHeaders(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProxyV2Malformed(t *testing.T) {
+	tcp4 := proxyV2(0x1, 0x11, ipv4Block("192.0.2.1", "198.51.100.2", 5000, 443))
+	badVersion := append([]byte(nil), tcp4...)
+	badVersion[12] = 0x11
+	tests := []struct {
+		name string
+		raw  []byte
+	}{
+		{"empty", nil},
+		{"signature only", proxyV2Signature},
+		{"wrong signature", append([]byte("\r\n\r\n\x00\r\nQUIT!"), tcp4[12:]...)},
+		{"version 1 nibble", badVersion},
+		{"length past end", tcp4[:len(tcp4)-1]},
+		{"short address block", proxyV2(0x1, 0x21, make([]byte, 12))},
+		{"short unix block", proxyV2(0x1, 0x31, make([]byte, 100))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _, err := parseProxyV2(tt.raw); err == nil {
+				t.Errorf("got %+v, want an error", got)
+			}
+		})
+	}
+}
+
+func TestReadProxyHeaderV2Truncated(t *testing.T) {
+	full := proxyV2(0x1, 0x11, ipv4Block("192.0.2.1", "198.51.100.2", 5000, 443))
+	for _, n := range []int{13, 15, len(full) - 1} {
+		if _, err := readProxyHeader(bufio.NewReader(bytes.NewReader(full[:n]))); err == nil {
+			t.Errorf("accepted a header cut to %d bytes", n)
+		}
+	}
+	// A truncated TLV is ignored rather than read past the header.
+	withTLV := proxyV2(0x1, 0x11, append(ipv4Block("192.0.2.1", "198.51.100.2", 5000, 443), 0x01, 0x00, 0x09, 'h'))
+	hdr, err := readProxyHeader(bufio.NewReader(bytes.NewReader(withTLV)))
+	if err != nil || len(hdr.TLVs) != 0 {
+		t.Errorf("got %+v, %v", hdr, err)
+	}
+}
+
+func FuzzReadProxyHeader(f *testing.F) {
+	f.Add([]byte("PROXY TCP4 192.0.2.1 198.51.100.2 5000 80\r\n"))
+	f.Add([]byte("PROXY UNKNOWN\r\n"))
+	f.Add(proxyV2(0x1, 0x11, ipv4Block("192.0.2.1", "198.51.100.2", 5000, 443)))
+	f.Add(proxyV2(0x1, 0x31, make([]byte, 216)))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		hdr, err := readProxyHeader(bufio.NewReader(bytes.NewReader(data)))
+		if err == nil && hdr != nil && hdr.Version != 1 && hdr.Version != 2 {
+			t.Fatalf("unexpected version in %+v", hdr)
+		}
+		if hdr, n, err := parseProxyV2(data); err == nil && (hdr == nil || n > len(data)) {
+			t.Fatalf("parseProxyV2 consumed %d of %d bytes", n, len(data))
+		}
+	})
+}
+
+func equalProxyHeaders(a, b *proxyHeader) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Version != b.Version || a.Command != b.Command || a.Transport != b.Transport ||
+		a.SourceAddr != b.SourceAddr || a.DestAddr != b.DestAddr || len(a.TLVs) != len(b.TLVs) {
+		return false
+	}
+	for i := range a.TLVs {
+		if a.TLVs[i] != b.TLVs[i] {
+			return false
+		}
+	}
+	return true
+}
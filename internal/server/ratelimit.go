@@ -0,0 +1,157 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	limiterSweepInterval = time.Minute
+	// limiterMaxBuckets bounds the bucket map. Once it is full, clients
+	// without a bucket share limiterOverflowKey until a sweep frees room.
+	limiterMaxBuckets  = 100_000
+	limiterOverflowKey = "overflow"
+)
+
+// RateLimitConfig throttles clients by their IP address. A zero Rate
+// disables per-client limits and a zero MaxConcurrent disables the global
+// concurrency cap.
+type RateLimitConfig struct {
+	// Rate is the sustained number of requests per second per client.
+	Rate float64
+	// Burst is the number of requests a client may make at once.
+	Burst int
+	// PerPath keeps a separate bucket for each route a client uses.
+	PerPath bool
+	// MaxConcurrent caps the requests being served at any time.
+	MaxConcurrent int
+	// Exempt clients are never limited.
+	Exempt []*net.IPNet
+	// TrustedProxies are peers whose X-Forwarded-For and X-Real-Ip headers
+	// identify the client. Anyone else is keyed on the connection's address.
+	TrustedProxies []*net.IPNet
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps one token bucket per key. Buckets that have been idle
+// long enough to refill completely are indistinguishable from new ones, so
+// they are swept periodically to bound memory.
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &rateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token for key. When none is left it reports how long until
+// the next one becomes available.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > limiterSweepInterval {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok && len(l.buckets) >= limiterMaxBuckets {
+		if now.Sub(l.lastSweep) > time.Second {
+			l.sweep(now)
+		}
+		if len(l.buckets) >= limiterMaxBuckets {
+			key = limiterOverflowKey
+			b, ok = l.buckets[key]
+		}
+	}
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+func (l *rateLimiter) sweep(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// limitRequests rejects clients that exceed their rate with 429 and sheds
+// load with 503 once MaxConcurrent requests are in flight. Health checks are
+// never limited.
+func (s *Server) limitRequests(next http.Handler) http.Handler {
+	cfg := s.rateLimit
+	var limiter *rateLimiter
+	if cfg.Rate > 0 {
+		limiter = newRateLimiter(cfg.Rate, cfg.Burst)
+	}
+	var slots chan struct{}
+	if cfg.MaxConcurrent > 0 {
+		slots = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	if limiter == nil && slots == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := trustedClientIP(r, cfg.TrustedProxies)
+		if r.URL.Path == "/healthz" || strings.HasPrefix(r.URL.Path, assetPrefix) || containsIP(cfg.Exempt, net.ParseIP(ip)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if limiter != nil {
+			key := ip
+			if cfg.PerPath {
+				key += " " + pathClass(r.URL.Path)
+			}
+			if ok, wait := limiter.allow(key, time.Now()); !ok {
+				s.metrics.rateLimited("rate")
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			default:
+				s.metrics.rateLimited("concurrency")
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "server busy", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
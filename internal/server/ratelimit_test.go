@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func mustCIDRs(t *testing.T, cidrs ...string) []*net.IPNet {
+	t.Helper()
+	nets, err := ParseCIDRs(cidrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nets
+}
+
+func TestTrustedClientIP(t *testing.T) {
+	trusted := mustCIDRs(t, "10.0.0.0/8")
+	tests := []struct {
+		name   string
+		peer   string
+		xff    []string
+		realIP string
+		want   string
+	}{
+		{name: "untrusted peer ignores headers", peer: "203.0.113.5:1234", xff: []string{"192.0.2.1"}, realIP: "192.0.2.2", want: "203.0.113.5"},
+		{name: "trusted peer without headers", peer: "10.0.0.1:1234", want: "10.0.0.1"},
+		{name: "single hop", peer: "10.0.0.1:1234", xff: []string{"192.0.2.1"}, want: "192.0.2.1"},
+		{name: "spoofed leftmost entry", peer: "10.0.0.1:1234", xff: []string{"198.51.100.7, 192.0.2.1"}, want: "192.0.2.1"},
+		{name: "trusted hops skipped", peer: "10.0.0.1:1234", xff: []string{"192.0.2.1, 10.1.1.1", "10.2.2.2"}, want: "192.0.2.1"},
+		{name: "all hops trusted", peer: "10.0.0.1:1234", xff: []string{"10.1.1.1, 10.2.2.2"}, want: "10.1.1.1"},
+		{name: "malformed hop", peer: "10.0.0.1:1234", xff: []string{"junk, 10.1.1.1"}, want: "10.1.1.1"},
+		{name: "x-real-ip", peer: "10.0.0.1:1234", realIP: "192.0.2.9", want: "192.0.2.9"},
+		{name: "bad x-real-ip", peer: "10.0.0.1:1234", realIP: "nope", want: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.peer
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-Ip", tt.realIP)
+			}
+			if got := trustedClientIP(r, trusted); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// limitedStatuses sends n requests through the rate limiter, calling edit on
+// each one first, and returns the response codes.
+func limitedStatuses(t *testing.T, cfg RateLimitConfig, n int, edit func(i int, r *http.Request)) []int {
+	t.Helper()
+	h := New(4096, WithRateLimit(cfg), WithLogger(discardLogger())).Handler()
+	codes := make([]int, n)
+	for i := range codes {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "203.0.113.5:4000"
+		edit(i, r)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		codes[i] = w.Code
+	}
+	return codes
+}
+
+func TestRateLimitIgnoresSpoofedHeaders(t *testing.T) {
+	cfg := RateLimitConfig{Rate: 0.001, Burst: 2, Exempt: mustCIDRs(t, "192.0.2.0/24")}
+	codes := limitedStatuses(t, cfg, 4, func(i int, r *http.Request) {
+		r.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		r.Header.Set("X-Real-Ip", "192.0.2.1")
+	})
+	want := []int{200, 200, 429, 429}
+	if fmt.Sprint(codes) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", codes, want)
+	}
+}
+
+func TestRateLimitTrustedProxy(t *testing.T) {
+	cfg := RateLimitConfig{Rate: 0.001, Burst: 1, TrustedProxies: mustCIDRs(t, "203.0.113.0/24")}
+	codes := limitedStatuses(t, cfg, 3, func(i int, r *http.Request) {
+		r.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i%2))
+	})
+	want := []int{200, 200, 429}
+	if fmt.Sprint(codes) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v: clients behind a trusted proxy should get their own buckets", codes, want)
+	}
+}
+
+func TestRateLimiterBucketCap(t *testing.T) {
+	l := newRateLimiter(1, 1)
+	now := time.Now()
+	for i := 0; i < limiterMaxBuckets; i++ {
+		l.allow(fmt.Sprint(i), now)
+	}
+	if ok, _ := l.allow("new client", now); !ok {
+		t.Fatal("first client beyond the cap was refused")
+	}
+	if ok, _ := l.allow("another client", now); ok {
+		t.Error("clients beyond the cap did not share the overflow bucket")
+	}
+	if len(l.buckets) > limiterMaxBuckets+1 {
+		t.Errorf("%d buckets, want at most %d", len(l.buckets), limiterMaxBuckets+1)
+	}
+	if ok, _ := l.allow("0", now.Add(2*time.Second)); !ok {
+		t.Error("existing client lost its bucket")
+	}
+	// Once idle buckets can be swept, new clients get their own again.
+	later := now.Add(limiterSweepInterval + time.Second)
+	if l.allow("new client", later); l.buckets["new client"] == nil {
+		t.Error("sweep did not make room for new clients")
+	}
+}
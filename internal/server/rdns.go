@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReverseDNSTimeout = 500 * time.Millisecond
+	reverseDNSPositiveTTL    = 10 * time.Minute
+	reverseDNSNegativeTTL    = time.Minute
+	reverseDNSCacheSize      = 4096
+)
+
+// Resolver performs the lookups used for reverse DNS. *net.Resolver
+// satisfies it; tests and embedders can supply their own.
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// reverseDNSResult is the PTR lookup for one address. Hostname is the first
+// name whose forward lookup leads back to the address (FCrDNS), or the first
+// PTR name when none does.
+type reverseDNSResult struct {
+	Names            []string `json:"names,omitempty"`
+	Hostname         string   `json:"hostname,omitempty"`
+	ForwardConfirmed bool     `json:"forward_confirmed"`
+	Error            string   `json:"error,omitempty"`
+	Cached           bool     `json:"cached,omitempty"`
+}
+
+type reverseDNSEntry struct {
+	result  reverseDNSResult
+	expires time.Time
+}
+
+// reverseDNS resolves and caches PTR records. Lookups are bounded by timeout
+// so a slow resolver delays a reflection by at most that much.
+type reverseDNS struct {
+	resolver Resolver
+	timeout  time.Duration
+	trusted  []*net.IPNet
+
+	mu    sync.Mutex
+	cache map[string]reverseDNSEntry
+}
+
+func newReverseDNS(resolver Resolver, timeout time.Duration, trusted []*net.IPNet) *reverseDNS {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	if timeout <= 0 {
+		timeout = defaultReverseDNSTimeout
+	}
+	return &reverseDNS{resolver: resolver, timeout: timeout, trusted: trusted, cache: make(map[string]reverseDNSEntry)}
+}
+
+// subject is the one address looked up for r: the peer, or the client a
+// trusted proxy forwarded for. Addresses from headers anyone can set are
+// never looked up, so they can neither pass as a verified crawler nor make
+// reflector query DNS on a client's behalf.
+func (d *reverseDNS) subject(r *http.Request) string {
+	if d == nil {
+		return ""
+	}
+	return trustedClientIP(r, d.trusted)
+}
+
+// annotate looks up ip and attaches the result to the matching entries of
+// addrs. It returns nil when reverse DNS is off or ip is not an address.
+func (d *reverseDNS) annotate(ctx context.Context, addrs []addressDetails, ip string) *reverseDNSResult {
+	target := net.ParseIP(ip)
+	if d == nil || target == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	result := d.lookup(ctx, target.String())
+	for i := range addrs {
+		if addrs[i].ReverseDNS == nil && target.Equal(net.ParseIP(addrs[i].IP)) {
+			addrs[i].ReverseDNS = result
+		}
+	}
+	return result
+}
+
+func (d *reverseDNS) lookup(ctx context.Context, ip string) *reverseDNSResult {
+	now := time.Now()
+	d.mu.Lock()
+	entry, ok := d.cache[ip]
+	d.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		result := entry.result
+		result.Cached = true
+		return &result
+	}
+
+	result := d.resolve(ctx, ip)
+	// A lookup cut short by the deadline or a client going away says
+	// nothing about the address, so it is not remembered.
+	if ctx.Err() != nil {
+		return &result
+	}
+	ttl := reverseDNSPositiveTTL
+	if result.Error != "" {
+		ttl = reverseDNSNegativeTTL
+	}
+	d.store(ip, reverseDNSEntry{result: result, expires: now.Add(ttl)})
+	return &result
+}
+
+func (d *reverseDNS) resolve(ctx context.Context, ip string) reverseDNSResult {
+	var result reverseDNSResult
+	names, err := d.resolver.LookupAddr(ctx, ip)
+	if err != nil {
+		result.Error = dnsErrorText(err)
+		return result
+	}
+	target := net.ParseIP(ip)
+	for _, name := range names {
+		name = strings.TrimSuffix(name, ".")
+		result.Names = append(result.Names, name)
+		if result.ForwardConfirmed {
+			continue
+		}
+		addrs, err := d.resolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if addr.IP.Equal(target) {
+				result.Hostname = name
+				result.ForwardConfirmed = true
+				break
+			}
+		}
+	}
+	if result.Hostname == "" && len(result.Names) > 0 {
+		result.Hostname = result.Names[0]
+	}
+	return result
+}
+
+// store adds an entry, evicting expired ones (or, failing that, arbitrary
+// ones) once the cache is full.
+func (d *reverseDNS) store(ip string, entry reverseDNSEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.cache) >= reverseDNSCacheSize {
+		now := time.Now()
+		for k, e := range d.cache {
+			if now.After(e.expires) {
+				delete(d.cache, k)
+			}
+		}
+		for k := range d.cache {
+			if len(d.cache) < reverseDNSCacheSize {
+				break
+			}
+			delete(d.cache, k)
+		}
+	}
+	d.cache[ip] = entry
+}
+
+func dnsErrorText(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		switch {
+		case dnsErr.IsNotFound:
+			return "no PTR record"
+		case dnsErr.IsTimeout:
+			return "timed out"
+		}
+	}
+	return err.Error()
+}
+
+// verifyBot checks a claimed crawler against the domains its operator
+// publishes for reverse DNS verification. rdns must be the lookup of the
+// address returned by subject.
+func verifyBot(ua *userAgentDetails, rdns *reverseDNSResult) {
+	if ua == nil || len(ua.botDomains) == 0 || rdns == nil {
+		return
+	}
+	verified := false
+	if rdns.ForwardConfirmed {
+		host := strings.ToLower(rdns.Hostname)
+		for _, domain := range ua.botDomains {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				verified = true
+				break
+			}
+		}
+	}
+	ua.BotVerified = &verified
+}
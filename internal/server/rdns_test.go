@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeResolver answers from fixed PTR and address tables and records every
+// PTR query it receives.
+type fakeResolver struct {
+	ptr   map[string][]string
+	hosts map[string][]string
+
+	mu      sync.Mutex
+	queries []string
+}
+
+func (f *fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	f.mu.Lock()
+	f.queries = append(f.queries, addr)
+	f.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	names, ok := f.ptr[addr]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+	return names, nil
+}
+
+func (f *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var addrs []net.IPAddr
+	for _, ip := range f.hosts[host] {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	if addrs == nil {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+func (f *fakeResolver) lookedUp(ip string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, q := range f.queries {
+		if q == ip {
+			n++
+		}
+	}
+	return n
+}
+
+func testDNSResolver() *fakeResolver {
+	return &fakeResolver{
+		ptr: map[string][]string{
+			"66.249.66.1":  {"crawl-66-249-66-1.googlebot.com."},
+			"192.0.2.7":    {"crawl-192-0-2-7.googlebot.com."},
+			"203.0.113.50": {"spoofed.example.", "host-50.example.net."},
+		},
+		hosts: map[string][]string{
+			"crawl-66-249-66-1.googlebot.com": {"66.249.66.1"},
+			"host-50.example.net":             {"203.0.113.50"},
+		},
+	}
+}
+
+func TestReverseDNSLookup(t *testing.T) {
+	res := testDNSResolver()
+	d := newReverseDNS(res, 0, nil)
+	tests := []struct {
+		ip   string
+		want reverseDNSResult
+	}{
+		{"66.249.66.1", reverseDNSResult{Names: []string{"crawl-66-249-66-1.googlebot.com"}, Hostname: "crawl-66-249-66-1.googlebot.com", ForwardConfirmed: true}},
+		{"192.0.2.7", reverseDNSResult{Names: []string{"crawl-192-0-2-7.googlebot.com"}, Hostname: "crawl-192-0-2-7.googlebot.com"}},
+		{"203.0.113.50", reverseDNSResult{Names: []string{"spoofed.example", "host-50.example.net"}, Hostname: "host-50.example.net", ForwardConfirmed: true}},
+		{"198.51.100.9", reverseDNSResult{Error: "no PTR record"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			addrs := []addressDetails{{IP: tt.ip}, {IP: "192.0.2.200"}}
+			got := d.annotate(context.Background(), addrs, tt.ip)
+			if !equalReverseDNS(got, &tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if addrs[0].ReverseDNS != got || addrs[1].ReverseDNS != nil {
+				t.Errorf("attached to %+v", addrs)
+			}
+			again := d.annotate(context.Background(), nil, tt.ip)
+			if !again.Cached || res.lookedUp(tt.ip) != 1 {
+				t.Errorf("second lookup: cached=%v after %d queries", again.Cached, res.lookedUp(tt.ip))
+			}
+		})
+	}
+}
+
+func TestReverseDNSCancelledNotCached(t *testing.T) {
+	res := testDNSResolver()
+	d := newReverseDNS(res, 0, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got := d.annotate(ctx, nil, "66.249.66.1"); got.Error == "" {
+		t.Fatalf("cancelled lookup returned %+v", got)
+	}
+	got := d.annotate(context.Background(), nil, "66.249.66.1")
+	if got.Cached || !got.ForwardConfirmed || res.lookedUp("66.249.66.1") != 2 {
+		t.Errorf("got %+v after %d queries, want a fresh lookup", got, res.lookedUp("66.249.66.1"))
+	}
+}
+
+func TestVerifyBotSpoofedForwarding(t *testing.T) {
+	googlebot := "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "127.0.0.1:40000"
+		r.Header.Set("User-Agent", googlebot)
+		r.Header.Set("X-Forwarded-For", "66.249.66.1")
+		return r
+	}
+
+	res := testDNSResolver()
+	s := New(4096, WithReverseDNS(res, 0, nil))
+	data := s.newReflection(newRequest(), nil, false, nil)
+	if v := data.UserAgent.BotVerified; v == nil || *v {
+		t.Errorf("untrusted peer: BotVerified = %v, want false", v)
+	}
+	if n := res.lookedUp("66.249.66.1"); n != 0 {
+		t.Errorf("looked up the forwarded address %d times", n)
+	}
+	if n := res.lookedUp("127.0.0.1"); n != 1 {
+		t.Errorf("looked up the peer %d times, want 1", n)
+	}
+
+	res = testDNSResolver()
+	s = New(4096, WithReverseDNS(res, 0, loopback(t)))
+	data = s.newReflection(newRequest(), nil, false, nil)
+	if v := data.UserAgent.BotVerified; v == nil || !*v {
+		t.Errorf("trusted proxy: BotVerified = %v, want true", v)
+	}
+	if n := res.lookedUp("127.0.0.1"); n != 0 {
+		t.Errorf("looked up the trusted proxy %d times", n)
+	}
+}
+
+func equalReverseDNS(a, b *reverseDNSResult) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Hostname != b.Hostname || a.ForwardConfirmed != b.ForwardConfirmed || a.Error != b.Error ||
+		a.Cached != b.Cached || len(a.Names) != len(b.Names) {
+		return false
+	}
+	for i := range a.Names {
+		if a.Names[i] != b.Names[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,475 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Redaction modes.
+const (
+	RedactMask   = "mask"
+	RedactPrefix = "prefix"
+	RedactHash   = "hash"
+
+	redactedMarker         = "[redacted]"
+	redactedTruncatedBody  = "[redacted: truncated JSON body could not be checked for body_paths]"
+	defaultRedactPrefixLen = 6
+)
+
+// redactHashKey keys hash mode. It is generated per process, so equal
+// values compare equal within one run but a short secret cannot be found by
+// hashing guesses.
+var redactHashKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("reflector: read random bytes: " + err.Error())
+	}
+	return key
+}()
+
+// uriHeaders carry URLs whose query strings are redacted like the request
+// URI, so a same-site Referer does not repeat what the query table hides.
+var uriHeaders = map[string]bool{"Referer": true, "Origin": true, "Location": true, "Content-Location": true}
+
+// RedactionPolicy lists the values that are replaced before a reflection is
+// rendered, logged or stored. Names may use path.Match wildcards; header and
+// query names are matched case-insensitively.
+type RedactionPolicy struct {
+	// Mode is one of mask, prefix or hash.
+	Mode string `json:"mode"`
+	// PrefixLength is the number of characters kept in prefix mode.
+	PrefixLength int      `json:"prefix_length"`
+	Headers      []string `json:"headers"`
+	Cookies      []string `json:"cookies"`
+	Query        []string `json:"query"`
+	// BodyPaths are dotted paths into JSON request bodies, e.g.
+	// "user.password" or "items.*.token".
+	BodyPaths []string `json:"body_paths"`
+	// Patterns are regular expressions whose matches are redacted wherever
+	// they appear in header, cookie, query or body values.
+	Patterns []string `json:"patterns"`
+
+	patterns []*regexp.Regexp
+}
+
+// DefaultRedactionPolicy hides credentials carried in standard headers while
+// keeping a short prefix so the auth scheme stays visible.
+func DefaultRedactionPolicy() *RedactionPolicy {
+	return &RedactionPolicy{
+		Mode:         RedactPrefix,
+		PrefixLength: defaultRedactPrefixLen,
+		Headers:      []string{"Authorization", "Proxy-Authorization", "X-Api-Key"},
+	}
+}
+
+// ParseRedactionPolicy decodes and validates a JSON redaction policy.
+func ParseRedactionPolicy(data []byte) (*RedactionPolicy, error) {
+	p := &RedactionPolicy{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(p); err != nil {
+		return nil, fmt.Errorf("parse redaction policy: %w", err)
+	}
+	if err := p.compile(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *RedactionPolicy) compile() error {
+	switch p.Mode {
+	case "":
+		p.Mode = RedactMask
+	case RedactMask, RedactPrefix, RedactHash:
+	default:
+		return fmt.Errorf("redaction policy: unknown mode %q (want mask, prefix or hash)", p.Mode)
+	}
+	if p.PrefixLength <= 0 {
+		p.PrefixLength = defaultRedactPrefixLen
+	}
+	for _, names := range [][]string{p.Headers, p.Cookies, p.Query} {
+		for _, name := range names {
+			if _, err := path.Match(name, ""); err != nil {
+				return fmt.Errorf("redaction policy: bad name pattern %q: %w", name, err)
+			}
+		}
+	}
+	p.patterns = p.patterns[:0]
+	for _, expr := range p.Patterns {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("redaction policy: bad pattern %q: %w", expr, err)
+		}
+		p.patterns = append(p.patterns, re)
+	}
+	return nil
+}
+
+func (p *RedactionPolicy) empty() bool {
+	return p == nil || len(p.Headers)+len(p.Cookies)+len(p.Query)+len(p.BodyPaths)+len(p.patterns) == 0
+}
+
+// value replaces v according to the policy mode.
+func (p *RedactionPolicy) value(v string) string {
+	switch p.Mode {
+	case RedactHash:
+		mac := hmac.New(sha256.New, redactHashKey)
+		mac.Write([]byte(v))
+		return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:6])
+	case RedactPrefix:
+		runes := []rune(v)
+		// Short values would be mostly revealed by their prefix.
+		if len(runes) <= p.PrefixLength*2 {
+			return redactedMarker
+		}
+		return string(runes[:p.PrefixLength]) + "…" + redactedMarker
+	default:
+		return redactedMarker
+	}
+}
+
+// scrub redacts pattern matches inside v, reporting whether any were found.
+func (p *RedactionPolicy) scrub(v string) (string, bool) {
+	changed := false
+	for _, re := range p.patterns {
+		v = re.ReplaceAllStringFunc(v, func(m string) string {
+			changed = true
+			return p.value(m)
+		})
+	}
+	return v, changed
+}
+
+func matchName(patterns []string, name string, fold bool) bool {
+	if fold {
+		name = strings.ToLower(name)
+	}
+	// path.Match never lets * cross a '/', so a query name like "a/b" would
+	// slip past a "*" rule. Slashes are swapped for NUL on both sides.
+	name = strings.ReplaceAll(name, "/", "\x00")
+	for _, pattern := range patterns {
+		if fold {
+			pattern = strings.ToLower(pattern)
+		}
+		pattern = strings.ReplaceAll(pattern, "/", "\x00")
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// redactor applies a policy to one reflection and records what it changed.
+type redactor struct {
+	policy *RedactionPolicy
+	seen   map[string]bool
+}
+
+func (rd *redactor) note(what string) {
+	if rd.seen == nil {
+		rd.seen = make(map[string]bool)
+	}
+	rd.seen[what] = true
+}
+
+func (rd *redactor) redactions() []string {
+	out := make([]string, 0, len(rd.seen))
+	for what := range rd.seen {
+		out = append(out, what)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// apply redacts data in place before it is rendered or stored.
+func (p *RedactionPolicy) apply(data *reflection) {
+	if p.empty() {
+		return
+	}
+	rd := &redactor{policy: p}
+	for name, values := range data.Headers {
+		for i, v := range values {
+			values[i] = rd.header(name, v)
+		}
+	}
+	for name, values := range data.Query {
+		for i, v := range values {
+			values[i] = rd.field("query", name, v, matchName(p.Query, name, true))
+		}
+	}
+	for i, c := range data.Cookies {
+		data.Cookies[i].Value = rd.field("cookie", c.Name, c.Value, matchName(p.Cookies, c.Name, false))
+	}
+	data.RequestURI = rd.uri(data.RequestURI)
+	if data.BodyPreview != "" {
+		data.BodyPreview = rd.body(data.BodyPreview, data.BodyTruncated)
+	}
+	if data.ClientData != nil {
+		rd.clientData(data.ClientData)
+	}
+	data.Redactions = rd.redactions()
+	// The upstream response is not part of the request, so what was hidden
+	// in it does not stop a replay or need filling in for an export.
+	if up := data.Upstream; up != nil {
+		urd := &redactor{policy: p}
+		for name, values := range up.Headers {
+			for i, v := range values {
+				values[i] = urd.responseHeader(name, v)
+			}
+		}
+		if up.BodyPreview != "" {
+			up.BodyPreview = urd.body(up.BodyPreview, up.BodyTruncated)
+		}
+		up.Redactions = urd.redactions()
+	}
+}
+
+// redactURI and redactHeader apply the policy to values that are logged or
+// reported outside a reflection.
+func (p *RedactionPolicy) redactURI(raw string) string {
+	if p.empty() {
+		return raw
+	}
+	return (&redactor{policy: p}).uri(raw)
+}
+
+func (p *RedactionPolicy) redactHeader(name, v string) string {
+	if p.empty() {
+		return v
+	}
+	return (&redactor{policy: p}).header(http.CanonicalHeaderKey(name), v)
+}
+
+func (p *RedactionPolicy) redactCookie(name, v string) string {
+	if p.empty() {
+		return v
+	}
+	return (&redactor{policy: p}).field("cookie", name, v, matchName(p.Cookies, name, false))
+}
+
+func (p *RedactionPolicy) redactSetCookie(v string) string {
+	if p.empty() {
+		return v
+	}
+	return (&redactor{policy: p}).responseHeader("Set-Cookie", v)
+}
+
+func (rd *redactor) field(kind, name, v string, matched bool) string {
+	if matched {
+		rd.note(kind + ":" + name)
+		return rd.policy.value(v)
+	}
+	if scrubbed, ok := rd.policy.scrub(v); ok {
+		rd.note("pattern:" + kind + ":" + name)
+		return scrubbed
+	}
+	return v
+}
+
+func (rd *redactor) header(name, v string) string {
+	matched := matchName(rd.policy.Headers, name, true)
+	if name == "Cookie" && !matched {
+		return rd.cookieHeader(v)
+	}
+	if uriHeaders[name] && !matched {
+		v = rd.uri(v)
+	}
+	return rd.field("header", name, v, matched)
+}
+
+// responseHeader is header for an upstream response, where cookies arrive
+// one per Set-Cookie header.
+func (rd *redactor) responseHeader(name, v string) string {
+	if name == "Set-Cookie" && !matchName(rd.policy.Headers, name, true) {
+		pair, attrs, _ := strings.Cut(v, ";")
+		cookie, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return v
+		}
+		trimmed := strings.TrimSpace(cookie)
+		v = cookie + "=" + rd.field("cookie", trimmed, value, matchName(rd.policy.Cookies, trimmed, false))
+		if attrs != "" {
+			v += ";" + attrs
+		}
+		return v
+	}
+	return rd.header(name, v)
+}
+
+// cookieHeader redacts individual cookie values inside a raw Cookie header so
+// the header table does not leak what the cookie table hides.
+func (rd *redactor) cookieHeader(v string) string {
+	parts := strings.Split(v, ";")
+	for i, part := range parts {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		trimmed := strings.TrimSpace(name)
+		parts[i] = name + "=" + rd.field("cookie", trimmed, value, matchName(rd.policy.Cookies, trimmed, false))
+	}
+	return strings.Join(parts, ";")
+}
+
+// uri redacts query values in a raw request URI without re-encoding the
+// parameters that are left alone.
+func (rd *redactor) uri(raw string) string {
+	base, query, ok := strings.Cut(raw, "?")
+	if !ok || query == "" {
+		return raw
+	}
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			continue
+		}
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		decoded, err := url.QueryUnescape(value)
+		if err != nil {
+			decoded = value
+		}
+		if redacted := rd.field("query", name, decoded, matchName(rd.policy.Query, name, true)); redacted != decoded {
+			params[i] = key + "=" + url.QueryEscape(redacted)
+		}
+	}
+	return base + "?" + strings.Join(params, "&")
+}
+
+// body redacts JSON body paths when the preview is a complete JSON document
+// and then applies the value patterns to whatever text remains. A truncated
+// JSON preview cannot be parsed, so it is hidden entirely rather than stored
+// with the configured paths intact.
+func (rd *redactor) body(preview string, truncated bool) string {
+	if len(rd.policy.BodyPaths) > 0 {
+		if truncated && looksLikeJSON(preview) {
+			rd.note("body:truncated")
+			return redactedTruncatedBody
+		}
+		preview = rd.jsonBody(preview)
+	}
+	if scrubbed, ok := rd.policy.scrub(preview); ok {
+		rd.note("pattern:body")
+		return scrubbed
+	}
+	return preview
+}
+
+func looksLikeJSON(preview string) bool {
+	preview = strings.TrimSpace(preview)
+	return strings.HasPrefix(preview, "{") || strings.HasPrefix(preview, "[")
+}
+
+// clientData redacts the decoded /collect payload the same way as the body
+// it came from, and the page URLs in it like a request URI.
+func (rd *redactor) clientData(doc map[string]any) {
+	changed := false
+	for _, p := range rd.policy.BodyPaths {
+		rd.redactPath(doc, strings.Split(p, "."), p, &changed)
+	}
+	for _, key := range []string{"location", "referrer"} {
+		if v, ok := doc[key].(string); ok {
+			doc[key] = rd.uri(v)
+		}
+	}
+	rd.scrubValues(doc)
+}
+
+// scrubValues applies the value patterns to every string in a decoded JSON
+// document.
+func (rd *redactor) scrubValues(node any) any {
+	switch n := node.(type) {
+	case string:
+		if scrubbed, ok := rd.policy.scrub(n); ok {
+			rd.note("pattern:client_data")
+			return scrubbed
+		}
+	case map[string]any:
+		for key, child := range n {
+			n[key] = rd.scrubValues(child)
+		}
+	case []any:
+		for i, child := range n {
+			n[i] = rd.scrubValues(child)
+		}
+	}
+	return node
+}
+
+func (rd *redactor) jsonBody(preview string) string {
+	dec := json.NewDecoder(strings.NewReader(preview))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil || dec.More() {
+		return preview
+	}
+	changed := false
+	for _, p := range rd.policy.BodyPaths {
+		doc = rd.redactPath(doc, strings.Split(p, "."), p, &changed)
+	}
+	if !changed {
+		return preview
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if strings.Contains(preview, "\n") {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(doc); err != nil {
+		return preview
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func (rd *redactor) redactPath(node any, segments []string, full string, changed *bool) any {
+	if len(segments) == 0 {
+		*changed = true
+		rd.note("body:" + full)
+		return rd.policy.value(jsonScalar(node))
+	}
+	seg, rest := segments[0], segments[1:]
+	switch n := node.(type) {
+	case map[string]any:
+		for key, child := range n {
+			if seg == "*" || key == seg {
+				n[key] = rd.redactPath(child, rest, full, changed)
+			}
+		}
+	case []any:
+		for i, child := range n {
+			if seg == "*" || seg == fmt.Sprint(i) {
+				n[i] = rd.redactPath(child, rest, full, changed)
+			}
+		}
+	}
+	return node
+}
+
+// jsonScalar turns a JSON value into the text that gets redacted; objects and
+// arrays are hashed or masked as their compact encoding.
+func jsonScalar(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	default:
+		b, _ := json.Marshal(t)
+		return string(b)
+	}
+}
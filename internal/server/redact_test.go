@@ -0,0 +1,271 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseRedactionPolicy(t *testing.T) {
+	p, err := ParseRedactionPolicy([]byte(`{"headers": ["X-Secret-*"], "patterns": ["tok_[a-z0-9]+"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Mode != RedactMask || p.PrefixLength != defaultRedactPrefixLen || len(p.patterns) != 1 {
+		t.Errorf("defaults not applied: %+v", p)
+	}
+	for _, bad := range []string{
+		`{"mode": "rot13"}`,
+		`{"patterns": ["("]}`,
+		`{"headers": ["["]}`,
+		`{"header": ["Authorization"]}`,
+		`[]`,
+	} {
+		if _, err := ParseRedactionPolicy([]byte(bad)); err == nil {
+			t.Errorf("ParseRedactionPolicy(%s) succeeded", bad)
+		}
+	}
+}
+
+func TestRedactionPolicyValue(t *testing.T) {
+	tests := []struct {
+		mode  string
+		value string
+		want  string
+	}{
+		{RedactMask, "secret", redactedMarker},
+		{RedactPrefix, "Bearer abcdefghijkl", "Bearer…" + redactedMarker},
+		{RedactPrefix, "short", redactedMarker},
+	}
+	for _, tt := range tests {
+		p := &RedactionPolicy{Mode: tt.mode, PrefixLength: 6}
+		if got := p.value(tt.value); got != tt.want {
+			t.Errorf("%s(%q) = %q, want %q", tt.mode, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRedactionHashIsKeyed(t *testing.T) {
+	p := &RedactionPolicy{Mode: RedactHash}
+	got := p.value("1234")
+	if got != p.value("1234") || got == p.value("1235") || len(got) != len("hmac:")+12 {
+		t.Errorf("hash of 1234 = %q, want a stable 12 digit HMAC", got)
+	}
+	// An unkeyed digest of a guessed value must not match.
+	plain := sha256.Sum256([]byte("1234"))
+	if strings.HasSuffix(got, hex.EncodeToString(plain[:6])) {
+		t.Errorf("hash %q is an unkeyed SHA-256", got)
+	}
+}
+
+func TestRedactionURIHeaders(t *testing.T) {
+	data := reflection{
+		RequestURI: "/next?token=abc123",
+		Headers: map[string][]string{
+			"Referer": {"https://example.com/login?token=abc123&page=2"},
+			"Origin":  {"https://example.com"},
+		},
+		Upstream: &upstreamExchange{Headers: map[string][]string{
+			"Location": {"/welcome?token=abc123"},
+		}},
+	}
+	testPolicy().apply(&data)
+	if got := data.Headers["Referer"][0]; strings.Contains(got, "abc123") || !strings.Contains(got, "page=2") {
+		t.Errorf("Referer %q, want the token redacted and page kept", got)
+	}
+	if got := data.Headers["Origin"][0]; got != "https://example.com" {
+		t.Errorf("Origin %q", got)
+	}
+	if got := data.Upstream.Headers["Location"][0]; strings.Contains(got, "abc123") {
+		t.Errorf("Location %q", got)
+	}
+}
+
+func TestMatchNameWildcards(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		fold          bool
+		want          bool
+	}{
+		{"*", "token", false, true},
+		{"*", "redirect/to", false, true},
+		{"*", "/", false, true},
+		{"x-*-token", "X-Api-Token", true, true},
+		{"x-*-token", "X-Api-Token", false, false},
+		{"api/*", "api/v1/key", false, true},
+		{"api/key", "api/key", false, true},
+		{"session?", "session1", false, true},
+		{"session?", "session", false, false},
+	}
+	for _, tt := range tests {
+		if got := matchName([]string{tt.pattern}, tt.name, tt.fold); got != tt.want {
+			t.Errorf("matchName(%q, %q, %v) = %v, want %v", tt.pattern, tt.name, tt.fold, got, tt.want)
+		}
+	}
+
+	// A "*" query rule also covers names containing a slash.
+	p, err := ParseRedactionPolicy([]byte(`{"query": ["*"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := reflection{RequestURI: "/?a%2Fb=s3cr3t", Query: map[string][]string{"a/b": {"s3cr3t"}}}
+	p.apply(&data)
+	if data.Query["a/b"][0] != redactedMarker || strings.Contains(data.RequestURI, "s3cr3t") {
+		t.Errorf("query %v, URI %q", data.Query, data.RequestURI)
+	}
+}
+
+func testReflection() reflection {
+	return reflection{
+		RequestURI: "/login?next=%2Fhome&token=abc123",
+		Headers: map[string][]string{
+			"Authorization": {"Bearer abcdefghijkl"},
+			"Cookie":        {"session=s3cr3t; theme=dark"},
+			"X-Trace":       {"tok_deadbeef"},
+		},
+		Query:       map[string][]string{"token": {"abc123"}, "next": {"/home"}},
+		Cookies:     []cookieDetails{{Name: "session", Value: "s3cr3t"}, {Name: "theme", Value: "dark"}},
+		BodyPreview: `{"user":{"name":"ann","password":"hunter2"}}`,
+	}
+}
+
+func testPolicy() *RedactionPolicy {
+	p, err := ParseRedactionPolicy([]byte(`{
+		"headers": ["Authorization"],
+		"cookies": ["session"],
+		"query": ["token"],
+		"body_paths": ["user.password"],
+		"patterns": ["tok_[a-z0-9]+"]
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func TestRedactionApply(t *testing.T) {
+	data := testReflection()
+	testPolicy().apply(&data)
+
+	checks := []struct {
+		name, got, want string
+	}{
+		{"authorization", data.Headers["Authorization"][0], redactedMarker},
+		{"cookie header", data.Headers["Cookie"][0], "session=" + redactedMarker + "; theme=dark"},
+		{"pattern in header", data.Headers["X-Trace"][0], redactedMarker},
+		{"query", data.Query["token"][0], redactedMarker},
+		{"untouched query", data.Query["next"][0], "/home"},
+		{"request uri", data.RequestURI, "/login?next=%2Fhome&token=%5Bredacted%5D"},
+		{"cookie", data.Cookies[0].Value, redactedMarker},
+		{"untouched cookie", data.Cookies[1].Value, "dark"},
+		{"body", data.BodyPreview, `{"user":{"name":"ann","password":"[redacted]"}}`},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, c.got, c.want)
+		}
+	}
+	want := []string{"body:user.password", "cookie:session", "header:Authorization", "pattern:header:X-Trace", "query:token"}
+	if !slices.Equal(data.Redactions, want) {
+		t.Errorf("redactions %q, want %q", data.Redactions, want)
+	}
+}
+
+func TestRedactionTruncatedBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		preview string
+		want    string
+	}{
+		{"json object", `{"user":{"password":"hunt`, redactedTruncatedBody},
+		{"json array", ` [{"password":"hunt`, redactedTruncatedBody},
+		{"plain text", "hello world, this body goes on", "hello world, this body goes on"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := reflection{BodyPreview: tt.preview, BodyTruncated: true}
+			testPolicy().apply(&data)
+			if data.BodyPreview != tt.want {
+				t.Errorf("got %q, want %q", data.BodyPreview, tt.want)
+			}
+		})
+	}
+
+	// Without body paths there is nothing to parse, so patterns still run on
+	// the partial preview.
+	p, _ := ParseRedactionPolicy([]byte(`{"patterns": ["tok_[a-z0-9]+"]}`))
+	data := reflection{BodyPreview: `{"a":"tok_abc","b":"x`, BodyTruncated: true}
+	p.apply(&data)
+	if data.BodyPreview != `{"a":"[redacted]","b":"x` {
+		t.Errorf("got %q", data.BodyPreview)
+	}
+}
+
+func TestRedactionClientData(t *testing.T) {
+	data := reflection{ClientData: map[string]any{
+		"location": "https://example.test/app?token=abc123&page=2",
+		"referrer": "https://example.test/?token=xyz",
+		"user":     map[string]any{"password": "hunter2"},
+		"notes":    []any{"see tok_cafe", 42.0},
+	}}
+	testPolicy().apply(&data)
+
+	if got := data.ClientData["location"]; got != "https://example.test/app?token=%5Bredacted%5D&page=2" {
+		t.Errorf("location %q", got)
+	}
+	if got := data.ClientData["referrer"]; got != "https://example.test/?token=%5Bredacted%5D" {
+		t.Errorf("referrer %q", got)
+	}
+	if got := data.ClientData["user"].(map[string]any)["password"]; got != redactedMarker {
+		t.Errorf("password %q", got)
+	}
+	if got := data.ClientData["notes"].([]any)[0]; got != "see "+redactedMarker {
+		t.Errorf("notes %q", got)
+	}
+	if !slices.Contains(data.Redactions, "pattern:client_data") || !slices.Contains(data.Redactions, "body:user.password") {
+		t.Errorf("redactions %q", data.Redactions)
+	}
+}
+
+func TestRedactionUpstream(t *testing.T) {
+	data := reflection{Upstream: &upstreamExchange{
+		Headers: map[string][]string{
+			"Set-Cookie": {"session=s3cr3t; Path=/; HttpOnly", "theme=dark"},
+		},
+		BodyPreview: `{"user":{"password":"hunter2"}}`,
+	}}
+	testPolicy().apply(&data)
+	if got := data.Upstream.Headers["Set-Cookie"]; got[0] != "session="+redactedMarker+"; Path=/; HttpOnly" || got[1] != "theme=dark" {
+		t.Errorf("Set-Cookie %q", got)
+	}
+	if !strings.Contains(data.Upstream.BodyPreview, redactedMarker) {
+		t.Errorf("upstream body %q", data.Upstream.BodyPreview)
+	}
+	// The request itself was left alone, so exports and replay see nothing
+	// to fill in.
+	if len(data.Redactions) != 0 || len(exportNotes(&data)) != 0 {
+		t.Errorf("request redactions %q", data.Redactions)
+	}
+	if !slices.Contains(data.Upstream.Redactions, "cookie:session") || !slices.Contains(data.Upstream.Redactions, "body:user.password") {
+		t.Errorf("upstream redactions %q", data.Upstream.Redactions)
+	}
+}
+
+func TestWithRedactionPolicyCompilesLiteral(t *testing.T) {
+	literal := &RedactionPolicy{Patterns: []string{"tok_[a-z0-9]+"}}
+	s := New(4096, WithRedactionPolicy(literal))
+	data := reflection{Headers: map[string][]string{"X-Trace": {"tok_deadbeef"}}}
+	s.redaction.apply(&data)
+	if got := data.Headers["X-Trace"][0]; got != redactedMarker {
+		t.Errorf("literal policy patterns ignored: got %q", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("invalid literal policy was accepted")
+		}
+	}()
+	New(4096, WithRedactionPolicy(&RedactionPolicy{Patterns: []string{"("}}))
+}
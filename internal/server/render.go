@@ -1,179 +1,891 @@
 package server
 
-import "html/template"
+// layoutTemplateHTML holds the partials shared by every page. "head-extra"
+// is empty so custom templates can add stylesheets or scripts without
+// restating the built-in ones.
+const layoutTemplateHTML = `{{define "head"}}
+	<link rel="stylesheet" href="{{asset "bootstrap.min.css"}}">
+	<link rel="stylesheet" href="{{asset "reflector.css"}}">
+	<script src="{{asset "theme.js"}}"></script>
+	{{template "head-extra" .}}
+{{end}}
 
-var reflectionTemplate = template.Must(template.New("page").Parse(pageTemplateHTML))
+{{define "head-extra"}}{{end}}`
 
-const pageTemplateHTML = `<!DOCTYPE html>
+const pageTemplateHTML = `{{define "reflection"}}<!DOCTYPE html>
 <html lang="en">
 <head>
 	<meta charset="utf-8">
 	<meta name="viewport" content="width=device-width, initial-scale=1">
 	<title>HTTP Reflector</title>
-	<link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.2/dist/css/bootstrap.min.css">
-	<style>
-		body { background-color: #f8f9fa; }
-		pre { background-color: #0f172a; color: #e2e8f0; padding: 1rem; border-radius: 0.5rem; }
-		code { font-size: 0.875rem; }
-	</style>
+	{{template "head" .}}
 </head>
-<body>
+<body data-has-client-data="{{.HasClientData}}">
 	<div class="container py-4">
-		<header class="mb-4">
-			<div class="d-flex flex-wrap justify-content-between align-items-center gap-3">
-				<div>
-					<h1 class="h3 mb-1">HTTP Reflector</h1>
-					<p class="text-muted mb-0">Observing request from <code>{{.Reflection.RemoteAddr}}</code></p>
+		{{template "header" .}}
+		{{template "user-agent" .}}
+		{{template "status" .}}
+		{{template "redactions" .}}
+		{{template "card-overview" .}}
+		{{template "card-timing" .}}
+		{{template "card-tracing" .}}
+		<section class="mb-4">
+			<div class="row g-4">
+				<div class="col-lg-6">
+					{{template "card-headers" .}}
+				</div>
+				<div class="col-lg-6">
+					{{template "card-query" .}}
+				</div>
+			</div>
+		</section>
+		<section class="mb-4">
+			<div class="row g-4">
+				<div class="col-lg-6">
+					{{template "card-cookies" .}}
+				</div>
+				<div class="col-lg-6">
+					{{template "card-tls" .}}
+				</div>
+			</div>
+		</section>
+		{{template "card-body" .}}
+		{{template "card-upstream" .}}
+		{{template "card-replay" .}}
+		{{template "card-addresses" .}}
+		{{template "card-client-hints" .}}
+		{{template "card-browser" .}}
+		{{template "footer" .}}
+	</div>
+
+	{{if not .Share}}<script src="{{asset "collector.js"}}"></script>{{end}}
+</body>
+</html>{{end}}
+
+{{define "header"}}
+<header class="mb-4">
+	<div class="d-flex flex-wrap justify-content-between align-items-center gap-3">
+		<div>
+			<h1 class="h3 mb-1">HTTP Reflector</h1>
+			<p class="text-muted mb-0">Observing request from <code>{{.Reflection.RemoteAddr}}</code></p>
+			{{range .Reflection.Addresses}}{{if .Client}}{{if or .Location .Network}}
+				<p id="client-location" class="small mb-0">
+					{{with .Location}}<span title="{{.TimeZone}}">{{if .City}}{{.City}}, {{end}}{{if .Region}}{{.Region}}, {{end}}{{.Country}}{{if .CountryCode}} ({{.CountryCode}}){{end}}</span>{{end}}
+					{{with .Network}}<span class="text-muted ms-2">AS{{.ASN}} {{.Organization}}</span>{{end}}
+				</p>
+			{{end}}{{end}}{{end}}
+		</div>
+		<div class="text-end">
+			{{if .Reflection.RequestID}}<div class="small mb-1"><span class="text-muted">Request ID</span> <code id="request-id" class="user-select-all">{{.Reflection.RequestID}}</code></div>{{end}}
+			<span class="badge text-bg-secondary">{{.Reflection.Timestamp}}</span>
+			{{with .Share}}
+				<div class="small mt-1"><span class="badge text-bg-info">shared</span> <a href="/s/{{.ID}}.json">JSON</a></div>
+			{{else}}{{if and .CaptureID .Sharing}}
+				<form id="share-form" method="post" action="/share" class="mt-2">
+					<input type="hidden" name="capture" value="{{.CaptureID}}">
+					<button type="submit" class="btn btn-sm btn-outline-primary">Share</button>
+				</form>
+			{{end}}{{end}}
+			{{template "exports" .}}
+		</div>
+	</div>
+</header>
+{{end}}
+
+{{define "exports"}}
+{{with .ExportPath}}
+<div id="exports" class="small mt-1">
+	<span class="text-muted">Export as</span>
+	<a href="{{.}}.curl">curl</a> ·
+	<a href="{{.}}.har" download>HAR</a> ·
+	<a href="{{.}}.http">raw HTTP</a> ·
+	<a href="{{.}}.go">Go</a> ·
+	<a href="{{.}}.py">Python</a>
+</div>
+{{end}}
+{{with .CaptureID}}
+<div id="compare" class="small mt-1">
+	<a href="/diff?a={{.}}">Compare with another request</a>
+</div>
+{{end}}
+{{end}}
+
+{{define "user-agent"}}
+{{with .Reflection.UserAgent}}
+	<div id="user-agent-summary" class="d-flex flex-wrap align-items-center gap-2 mb-3" title="{{.Raw}}">
+		{{if .Bot}}<span class="badge text-bg-danger">bot</span>{{end}}
+		{{with .BotVerified}}{{if .}}<span class="badge text-bg-success">verified by reverse DNS</span>{{else}}<span class="badge text-bg-warning">unverified: hostname does not belong to this crawler</span>{{end}}{{end}}
+		<span class="fw-semibold">{{if .Browser}}{{.Browser}}{{if .BrowserVersion}} {{.BrowserVersion}}{{end}}{{else}}Unrecognised client{{end}}</span>
+		{{if .OS}}<span class="text-muted">on</span> <span class="fw-semibold">{{.OS}}{{if .OSVersion}} {{.OSVersion}}{{end}}</span>{{end}}
+		<span class="badge text-bg-light border">{{.Device}}{{if .Model}} · {{.Model}}{{end}}</span>
+		<span class="badge text-bg-light border">{{.Kind}}</span>
+		{{if .ScriptMatch}}
+			{{if .Script}}<span class="badge text-bg-warning">script reports a different user agent</span>{{else}}<span class="badge text-bg-success">matches navigator.userAgent</span>{{end}}
+		{{end}}
+	</div>
+	{{if .Script}}
+		<div class="alert alert-warning small mb-4" role="alert">
+			<div><span class="text-muted">Header:</span> <code>{{.Raw}}</code></div>
+			<div><span class="text-muted">navigator.userAgent:</span> <code>{{.Script}}</code></div>
+			{{if .Differences}}<ul class="mb-0 mt-2">{{range .Differences}}<li>{{.}}</li>{{end}}</ul>{{end}}
+		</div>
+	{{end}}
+{{end}}
+{{end}}
+
+{{define "status"}}
+<div id="status-message" class="alert alert-{{.StatusVariant}} mb-4" role="alert">
+	{{.StatusMessage}}
+</div>
+{{end}}
+
+{{define "redactions"}}
+{{with .Reflection.Redactions}}
+	<div id="redaction-notice" class="alert alert-warning mb-4" role="alert">
+		<strong>Some values were redacted</strong> by this server's redaction policy before display and storage:
+		{{range $i, $r := .}}{{if $i}}, {{end}}<code>{{$r}}</code>{{end}}
+	</div>
+{{end}}
+{{end}}
+
+{{define "card-overview"}}
+<section class="mb-4">
+	<div class="card shadow-sm">
+		<div class="card-header fw-semibold">Request Overview</div>
+		<div class="card-body">
+			<div class="row gy-3">
+				<div class="col-md-6">
+					<dl class="row mb-0 small">
+						<dt class="col-sm-4 text-muted">Method</dt>
+						<dd class="col-sm-8">{{.Reflection.Method}}</dd>
+						<dt class="col-sm-4 text-muted">Protocol</dt>
+						<dd class="col-sm-8">{{.Reflection.Proto}}</dd>
+						<dt class="col-sm-4 text-muted">Scheme</dt>
+						<dd class="col-sm-8">{{.Reflection.Scheme}}</dd>
+						<dt class="col-sm-4 text-muted">Host</dt>
+						<dd class="col-sm-8">{{.Reflection.Host}}</dd>
+						<dt class="col-sm-4 text-muted">Request URI</dt>
+						<dd class="col-sm-8"><code>{{.Reflection.RequestURI}}</code></dd>
+					</dl>
+				</div>
+				<div class="col-md-6">
+					<dl class="row mb-0 small">
+						<dt class="col-sm-5 text-muted">Remote Address</dt>
+						<dd class="col-sm-7"><code>{{.Reflection.RemoteAddr}}</code></dd>
+						<dt class="col-sm-5 text-muted">Remote IP</dt>
+						<dd class="col-sm-7"><code>{{.Reflection.RemoteIP}}</code></dd>
+						<dt class="col-sm-5 text-muted">Remote Port</dt>
+						<dd class="col-sm-7">{{.Reflection.RemotePort}}</dd>
+						{{range .Reflection.Addresses}}{{if .Client}}{{with .ReverseDNS}}
+						<dt class="col-sm-5 text-muted">Client Hostname</dt>
+						<dd class="col-sm-7">
+							{{if .Hostname}}<code>{{.Hostname}}</code> {{if .ForwardConfirmed}}<span class="badge text-bg-success">forward-confirmed</span>{{else}}<span class="badge text-bg-warning" title="none of the PTR names resolve back to this address">not confirmed</span>{{end}}{{else}}<span class="text-muted">{{.Error}}</span>{{end}}
+						</dd>
+						{{end}}{{end}}{{end}}
+						<dt class="col-sm-5 text-muted">Content Length</dt>
+						<dd class="col-sm-7">{{.Reflection.ContentLength}}</dd>
+						<dt class="col-sm-5 text-muted">Transfer Encoding</dt>
+						<dd class="col-sm-7">
+							{{if .Reflection.TransferEncoding}}
+								{{range .Reflection.TransferEncoding}}<span class="badge text-bg-secondary me-1">{{.}}</span>{{end}}
+							{{else}}
+								<span class="text-muted">none</span>
+							{{end}}
+						</dd>
+					</dl>
+				</div>
+			</div>
+			<hr>
+			{{with .Reflection.Connection}}
+				<dl class="row mb-0 small">
+					<dt class="col-sm-2 text-muted">Connection</dt>
+					<dd class="col-sm-4"><code>#{{.ID}}</code></dd>
+					<dt class="col-sm-2 text-muted">Local Address</dt>
+					<dd class="col-sm-4"><code>{{.LocalAddr}}</code></dd>
+					<dt class="col-sm-2 text-muted">Request on Connection</dt>
+					<dd class="col-sm-4">
+						{{.RequestNumber}}
+						{{if .Reused}}<span class="badge text-bg-success ms-1">keep-alive reused</span>{{else}}<span class="badge text-bg-secondary ms-1">new connection</span>{{end}}
+					</dd>
+					<dt class="col-sm-2 text-muted">Connection Age</dt>
+					<dd class="col-sm-4">{{printf "%.1f" .AgeMS}} ms{{if .IdleMS}} <span class="text-muted">(idle {{printf "%.1f" .IdleMS}} ms before this request)</span>{{end}}</dd>
+					<dt class="col-sm-2 text-muted">Open Connections</dt>
+					<dd class="col-sm-4">{{.OpenConnections}}</dd>
+				</dl>
+			{{else}}
+				<p class="text-muted small mb-0">Connection tracking is not enabled for this listener.</p>
+			{{end}}
+		</div>
+	</div>
+</section>
+{{end}}
+
+{{define "card-timing"}}
+{{with .Reflection.Timing}}
+<section class="mb-4">
+	<div class="card shadow-sm">
+		<div class="card-header fw-semibold d-flex justify-content-between align-items-center">
+			<span>Server Timing</span>
+			<span class="text-muted small">
+				{{if .TLSHandshakeMS}}TLS {{printf "%.2f" .TLSHandshakeMS}} ms · {{end}}
+				{{if .FirstByteWaitMS}}wait {{printf "%.2f" .FirstByteWaitMS}} ms · {{end}}
+				{{if .HeaderParseMS}}headers {{printf "%.2f" .HeaderParseMS}} ms · {{end}}
+				body {{printf "%.2f" .BodyReadMS}} ms
+			</span>
+		</div>
+		<div class="card-body">
+			<div class="table-responsive">
+				<table class="table table-sm align-middle mb-0">
+					<thead>
+						<tr>
+							<th scope="col">Event</th>
+							<th scope="col" class="text-end">Offset</th>
+							<th scope="col">Timestamp</th>
+						</tr>
+					</thead>
+					<tbody>
+						{{range .Events}}
+						<tr>
+							<th scope="row" class="text-nowrap fw-normal">{{.Name}}</th>
+							<td class="text-end text-nowrap"><code>+{{printf "%.3f" .OffsetMS}} ms</code></td>
+							<td class="text-muted small">{{.At.Format "15:04:05.000000"}}</td>
+						</tr>
+						{{end}}
+					</tbody>
+				</table>
+			</div>
+		</div>
+	</div>
+</section>
+{{end}}
+{{end}}
+
+{{define "card-tracing"}}
+{{with .Reflection.Tracing}}
+<section class="mb-4">
+	<div class="card shadow-sm">
+		<div class="card-header fw-semibold d-flex justify-content-between align-items-center">
+			<span>Tracing</span>
+			{{if .Disagreements}}
+				<span class="badge text-bg-warning">propagators disagree</span>
+			{{else if .Contexts}}
+				<span class="badge text-bg-success">propagators agree</span>
+			{{end}}
+		</div>
+		<div class="card-body">
+			{{if .Disagreements}}
+				<div class="alert alert-warning small">
+					{{range .Disagreements}}<div><code>{{.}}</code></div>{{end}}
+				</div>
+			{{end}}
+			{{if .Contexts}}
+				<div class="table-responsive">
+					<table class="table table-sm align-middle mb-0">
+						<thead>
+							<tr>
+								<th scope="col">Propagator</th>
+								<th scope="col">Trace ID</th>
+								<th scope="col">Span ID</th>
+								<th scope="col">Parent</th>
+								<th scope="col">Sampled</th>
+							</tr>
+						</thead>
+						<tbody>
+							{{range .Contexts}}
+							<tr>
+								<th scope="row" class="text-nowrap" title="{{.Header}}">{{.Propagator}}</th>
+								{{if .Error}}
+									<td colspan="4"><span class="badge text-bg-danger me-1">invalid</span>{{.Error}} <code class="ms-1">{{.Header}}</code></td>
+								{{else}}
+									<td><code>{{or .TraceID "–"}}</code></td>
+									<td><code>{{or .SpanID "–"}}</code></td>
+									<td><code>{{or .ParentSpanID "–"}}</code></td>
+									<td>
+										{{with .Sampled}}{{if .}}<span class="badge text-bg-success">yes</span>{{else}}<span class="badge text-bg-secondary">no</span>{{end}}{{else}}<span class="text-muted">deferred</span>{{end}}
+										{{if .Debug}}<span class="badge text-bg-info ms-1">debug</span>{{end}}
+									</td>
+								{{end}}
+							</tr>
+							{{end}}
+						</tbody>
+					</table>
+				</div>
+			{{else}}
+				<p class="text-muted small mb-0">No trace propagation headers were received.</p>
+			{{end}}
+			{{if .TraceState}}
+				<p class="small mt-3 mb-0"><span class="text-muted">tracestate:</span> {{range .TraceState}}<span class="badge text-bg-light border me-1">{{.}}</span>{{end}}</p>
+			{{end}}
+			{{with .ServerSpan}}
+				<p class="small mt-3 mb-0"><span class="text-muted">Reflector span:</span> trace <code>{{.TraceID}}</code> span <code>{{.SpanID}}</code>{{if .ParentSpanID}} parent <code>{{.ParentSpanID}}</code>{{end}}</p>
+			{{end}}
+		</div>
+	</div>
+</section>
+{{end}}
+{{end}}
+
+{{define "card-headers"}}
+<div class="card h-100 shadow-sm">
+	<div class="card-header fw-semibold">Headers</div>
+	<div class="card-body">
+		{{if .Headers}}
+			<div class="table-responsive">
+				<table class="table table-sm align-middle mb-0">
+					<tbody>
+						{{range .Headers}}
+						<tr>
+							<th scope="row" class="text-nowrap">{{.Key}}</th>
+							<td>
+								{{range .Values}}<span class="badge text-bg-primary me-1">{{.}}</span>{{end}}
+							</td>
+						</tr>
+						{{end}}
+					</tbody>
+				</table>
+			</div>
+		{{else}}
+			<p class="text-muted mb-0">No headers were supplied.</p>
+		{{end}}
+	</div>
+</div>
+{{end}}
+
+{{define "card-query"}}
+<div class="card h-100 shadow-sm">
+	<div class="card-header fw-semibold">Query Parameters</div>
+	<div class="card-body">
+		{{if .Query}}
+			<div class="table-responsive">
+				<table class="table table-sm align-middle mb-0">
+					<tbody>
+						{{range .Query}}
+						<tr>
+							<th scope="row" class="text-nowrap">{{.Key}}</th>
+							<td>
+								{{range .Values}}<span class="badge text-bg-info me-1">{{.}}</span>{{end}}
+							</td>
+						</tr>
+						{{end}}
+					</tbody>
+				</table>
+			</div>
+		{{else}}
+			<p class="text-muted mb-0">No query parameters detected.</p>
+		{{end}}
+	</div>
+</div>
+{{end}}
+
+{{define "card-cookies"}}
+<div class="card h-100 shadow-sm">
+	<div class="card-header fw-semibold d-flex justify-content-between align-items-center">
+		<span>Cookies</span>
+		<span class="small fw-normal">{{if .Reflection.CookieBytes}}<span class="text-muted">{{.Reflection.CookieBytes}} bytes · </span>{{end}}<a href="/cookies">cookie tester</a></span>
+	</div>
+	<div class="card-body">
+		{{if .Reflection.Cookies}}
+			<div class="table-responsive">
+				<table class="table table-sm align-middle mb-0">
+					<tbody>
+						{{range .Reflection.Cookies}}
+						<tr>
+							<th scope="row" class="text-nowrap">{{.Name}}</th>
+							<td>
+								<code>{{.Value}}</code>
+								{{if .Duplicate}}<span class="badge text-bg-warning ms-1">duplicate</span>{{end}}
+								{{range .Problems}}<span class="badge text-bg-danger ms-1">{{.}}</span>{{end}}
+							</td>
+							<td class="text-muted small text-end text-nowrap">{{.Size}} B</td>
+						</tr>
+						{{end}}
+					</tbody>
+				</table>
+			</div>
+		{{else}}
+			<p class="text-muted mb-0">No cookies were provided.</p>
+		{{end}}
+	</div>
+</div>
+{{end}}
+
+{{define "card-tls"}}
+<div class="card h-100 shadow-sm">
+	<div class="card-header fw-semibold">TLS</div>
+	<div class="card-body">
+		{{with .Reflection.TLS}}
+			<dl class="row mb-0 small">
+				<dt class="col-sm-4 text-muted">Version</dt>
+				<dd class="col-sm-8">{{.Version}}</dd>
+				<dt class="col-sm-4 text-muted">Cipher Suite</dt>
+				<dd class="col-sm-8">{{.CipherSuite}}</dd>
+				<dt class="col-sm-4 text-muted">Server Name</dt>
+				<dd class="col-sm-8">{{if .ServerName}}{{.ServerName}}{{else}}<span class="text-muted">n/a</span>{{end}}</dd>
+				<dt class="col-sm-4 text-muted">ALPN</dt>
+				<dd class="col-sm-8">{{if .Negotiated}}{{.Negotiated}}{{else}}<span class="text-muted">n/a</span>{{end}}</dd>
+			</dl>
+		{{else}}
+			<p class="text-muted mb-0">Connection is not using TLS.</p>
+		{{end}}
+	</div>
+</div>
+{{end}}
+
+{{define "card-body"}}
+<section class="mb-4">
+	<div class="card shadow-sm">
+		<div class="card-header fw-semibold d-flex justify-content-between align-items-center">
+			<span>Request Body</span>
+			<span class="text-muted small">{{len .Reflection.BodyPreview}} bytes shown{{if .Reflection.BodyTruncated}} <span class="badge text-bg-warning ms-1">truncated</span>{{end}}</span>
+		</div>
+		<div class="card-body">
+			{{if .Reflection.BodyPreview}}
+				<pre class="mb-0">{{.Reflection.BodyPreview}}</pre>
+			{{else}}
+				<p class="text-muted mb-0">No request body captured.</p>
+			{{end}}
+		</div>
+	</div>
+</section>
+{{end}}
+
+{{define "card-upstream"}}
+{{with .Reflection.Upstream}}
+<section class="mb-4">
+	<div id="upstream" class="card shadow-sm">
+		<div class="card-header fw-semibold d-flex justify-content-between align-items-center">
+			<span>Upstream Response</span>
+			<span class="text-muted small">{{printf "%.1f" .DurationMS}} ms</span>
+		</div>
+		<div class="card-body">
+			<p class="small"><span class="text-muted">Forwarded to</span> <code>{{.URL}}</code> <span class="text-muted">with Host</span> <code>{{.Host}}</code></p>
+			{{if .Error}}
+				<div class="alert alert-danger small" role="alert">The upstream could not be reached: {{.Error}}. The client got 502 Bad Gateway.</div>
+			{{end}}
+			{{if .Status}}
+				<p class="small"><code>{{.Proto}} {{.Status}} {{.StatusText}}</code> <span class="text-muted">after {{printf "%.1f" .HeadersMS}} ms</span></p>
+				{{template "replay-headers" .Headers}}
+				{{with .Redactions}}<p class="small text-muted mt-3 mb-1">Redacted: {{range $i, $r := .}}{{if $i}}, {{end}}<code>{{$r}}</code>{{end}}</p>{{end}}
+				<p class="small text-muted mt-3 mb-1">{{.BodyBytes}} bytes relayed{{if .BodyDecompressed}}, shown decompressed{{end}}{{if .BodyTruncated}} <span class="badge text-bg-warning">truncated</span>{{end}}</p>
+				{{if .BodyPreview}}<pre class="mb-0">{{.BodyPreview}}</pre>{{end}}
+			{{end}}
+		</div>
+	</div>
+</section>
+{{end}}
+{{end}}
+
+{{define "card-replay"}}
+{{if .ReplayTargets}}
+<section class="mb-4">
+	<div class="card shadow-sm">
+		<div class="card-header fw-semibold">Replay</div>
+		<div class="card-body">
+			<form id="replay-form" method="post" action="/replay/{{.CaptureID}}" class="row g-2 align-items-end">
+				<div class="col-md-6">
+					<label for="replay-target" class="form-label small text-muted">Target</label>
+					<select id="replay-target" name="target" class="form-select form-select-sm">
+						{{range .ReplayTargets}}<option>{{.}}</option>{{end}}
+					</select>
+				</div>
+				<div class="col-md-6">
+					<div class="form-check mb-1">
+						<input id="replay-keep-host" name="keep_host" type="checkbox" value="1" class="form-check-input">
+						<label for="replay-keep-host" class="form-check-label small">Send the original <code>Host</code> header ({{.Reflection.Host}})</label>
+					</div>
 				</div>
-				<span class="badge text-bg-secondary">{{.Reflection.Timestamp}}</span>
+				<div class="col-12">
+					<label for="replay-headers" class="form-label small text-muted">Header edits, one per line: <code>Name: value</code> replaces a header, <code>-Name</code> removes it</label>
+					<textarea id="replay-headers" name="headers" rows="3" class="form-control form-control-sm font-monospace"></textarea>
+				</div>
+				{{with .ReplayNotes}}
+				<div class="col-12">
+					<div class="form-check">
+						<input id="replay-confirm" name="confirm" type="checkbox" value="1" class="form-check-input" required>
+						<label for="replay-confirm" class="form-check-label small">Replay the incomplete capture anyway: {{range $i, $n := .}}{{if $i}}; {{end}}{{$n}}{{end}}</label>
+					</div>
+				</div>
+				{{end}}
+				<div class="col-12">
+					<button type="submit" class="btn btn-sm btn-primary">Replay request</button>
+				</div>
+			</form>
+		</div>
+	</div>
+</section>
+{{end}}
+{{end}}
+
+{{define "card-addresses"}}
+{{with .Reflection.Addresses}}
+<section class="mb-4">
+	<div class="card shadow-sm">
+		<div class="card-header fw-semibold">Client Addresses</div>
+		<div class="card-body">
+			<div class="table-responsive">
+				<table class="table table-sm align-middle mb-0">
+					<thead>
+						<tr><th scope="col">Address</th><th scope="col">Source</th><th scope="col">Classification</th><th scope="col">Location</th><th scope="col">Network</th></tr>
+					</thead>
+					<tbody>
+						{{range .}}
+						<tr>
+							<td class="text-nowrap">
+								<code>{{.IP}}</code>
+								{{if .Client}}<span class="badge text-bg-primary ms-1">client</span>{{end}}
+								{{if .Invalid}}<span class="badge text-bg-warning ms-1">not an IP</span>{{end}}
+								{{with .ReverseDNS}}{{if .Hostname}}<div class="small text-muted" title="{{range $i, $n := .Names}}{{if $i}}, {{end}}{{$n}}{{end}}">{{.Hostname}}{{if .ForwardConfirmed}} ✓{{end}}</div>{{end}}{{end}}
+							</td>
+							<td class="small text-muted text-nowrap">{{.Source}}{{if ne .Source "peer"}} #{{.Hop}}{{end}}</td>
+							<td class="small">
+								{{range .Classes}}<span class="badge {{if eq . "public"}}text-bg-light border{{else}}text-bg-secondary{{end}} me-1">{{.}}</span>{{end}}
+								{{range .Ranges}}<span class="badge {{if eq .Kind "tor"}}text-bg-danger{{else if eq .Kind "cdn"}}text-bg-info{{else}}text-bg-primary{{end}} me-1" title="{{.Prefix}}{{if .Detail}} · {{.Detail}}{{end}}">{{.Name}} {{.Kind}}</span>{{end}}
+							</td>
+							<td class="small">{{with .Location}}{{if .City}}{{.City}}, {{end}}{{.Country}}{{if .CountryCode}} ({{.CountryCode}}){{end}}{{else}}<span class="text-muted">–</span>{{end}}</td>
+							<td class="small">{{with .Network}}AS{{.ASN}} {{.Organization}} <span class="text-muted">{{.Prefix}}</span>{{else}}<span class="text-muted">–</span>{{end}}</td>
+						</tr>
+						{{end}}
+					</tbody>
+				</table>
 			</div>
+		</div>
+	</div>
+</section>
+{{end}}
+{{end}}
+
+{{define "card-client-hints"}}
+{{with .Reflection.ClientHints}}
+<section class="mb-4">
+	<div class="card shadow-sm">
+		<div class="card-header fw-semibold">Client Hints</div>
+		<div class="card-body">
+			<div class="table-responsive">
+				<table class="table table-sm align-middle mb-0">
+					<thead>
+						<tr><th scope="col">Hint</th><th scope="col">Request header</th><th scope="col">JavaScript (<code>navigator.userAgentData</code>)</th></tr>
+					</thead>
+					<tbody>
+						{{range .}}
+						<tr>
+							<th scope="row" class="text-nowrap">
+								{{.Header}}
+								{{if .Critical}}<span class="badge text-bg-primary ms-1">critical</span>{{else if .Requested}}<span class="badge text-bg-secondary ms-1">requested</span>{{end}}
+							</th>
+							<td>{{if .Received}}<code>{{.Value}}</code>{{else}}<span class="text-muted">not sent</span>{{end}}</td>
+							<td>
+								{{if .Script}}<code>{{.Script}}</code>{{else if .Field}}<span class="text-muted">n/a</span>{{end}}
+								{{if .Mismatch}}<span class="badge text-bg-warning ms-1">differs</span>{{end}}
+							</td>
+						</tr>
+						{{end}}
+					</tbody>
+				</table>
+			</div>
+		</div>
+	</div>
+</section>
+{{end}}
+{{end}}
+
+{{define "card-browser"}}
+<section class="mb-5">
+	<div class="card shadow-sm">
+		<div class="card-header fw-semibold">Browser Metadata</div>
+		<div class="card-body">
+			{{if .ClientJSON}}
+				<pre class="mb-0">{{.ClientJSON}}</pre>
+			{{else if .Share}}
+				<p class="text-muted mb-0">No browser metadata was collected for this request.</p>
+			{{else}}
+				<p class="text-muted mb-0">Waiting for the browser script to provide additional context...</p>
+			{{end}}
+		</div>
+	</div>
+</section>
+{{end}}
+
+{{define "footer"}}
+<footer class="text-muted small">
+	HTTP Reflector · helpful for CDN debugging and origin verification. · <a href="/ws">WebSocket tester</a>
+</footer>
+{{end}}
+`
+
+const websocketTemplateHTML = `{{define "websocket"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>WebSocket Reflector</title>
+	{{template "head" .}}
+</head>
+<body>
+	<div class="container py-4">
+		<header class="mb-4">
+			<h1 class="h3 mb-1">WebSocket Reflector</h1>
+			<p class="text-muted mb-0">Upgrades to <code>/ws</code>, reports the handshake, then echoes every message with its frame metadata.</p>
 		</header>
 
-		<div id="status-message" class="alert alert-{{.StatusVariant}} mb-4" role="alert">
-			{{.StatusMessage}}
+		<div id="ws-status" class="alert alert-secondary mb-4" role="alert">
+			Disconnected.
 		</div>
 
 		<section class="mb-4">
 			<div class="card shadow-sm">
-				<div class="card-header fw-semibold">Request Overview</div>
+				<div class="card-header fw-semibold">Connection</div>
 				<div class="card-body">
-					<div class="row gy-3">
+					<form id="ws-connect" class="row g-2 align-items-end">
 						<div class="col-md-6">
-							<dl class="row mb-0 small">
-								<dt class="col-sm-4 text-muted">Method</dt>
-								<dd class="col-sm-8">{{.Reflection.Method}}</dd>
-								<dt class="col-sm-4 text-muted">Protocol</dt>
-								<dd class="col-sm-8">{{.Reflection.Proto}}</dd>
-								<dt class="col-sm-4 text-muted">Scheme</dt>
-								<dd class="col-sm-8">{{.Reflection.Scheme}}</dd>
-								<dt class="col-sm-4 text-muted">Host</dt>
-								<dd class="col-sm-8">{{.Reflection.Host}}</dd>
-								<dt class="col-sm-4 text-muted">Request URI</dt>
-								<dd class="col-sm-8"><code>{{.Reflection.RequestURI}}</code></dd>
-							</dl>
+							<label for="ws-protocols" class="form-label small text-muted">Subprotocols (comma separated)</label>
+							<input id="ws-protocols" class="form-control form-control-sm" placeholder="chat, superchat">
 						</div>
-						<div class="col-md-6">
-							<dl class="row mb-0 small">
-								<dt class="col-sm-5 text-muted">Remote Address</dt>
-								<dd class="col-sm-7"><code>{{.Reflection.RemoteAddr}}</code></dd>
-								<dt class="col-sm-5 text-muted">Remote IP</dt>
-								<dd class="col-sm-7"><code>{{.Reflection.RemoteIP}}</code></dd>
-								<dt class="col-sm-5 text-muted">Remote Port</dt>
-								<dd class="col-sm-7">{{.Reflection.RemotePort}}</dd>
-								<dt class="col-sm-5 text-muted">Content Length</dt>
-								<dd class="col-sm-7">{{.Reflection.ContentLength}}</dd>
-								<dt class="col-sm-5 text-muted">Transfer Encoding</dt>
-								<dd class="col-sm-7">
-									{{if .Reflection.TransferEncoding}}
-										{{range .Reflection.TransferEncoding}}<span class="badge text-bg-secondary me-1">{{.}}</span>{{end}}
-									{{else}}
-										<span class="text-muted">none</span>
-									{{end}}
-								</dd>
-							</dl>
+						<div class="col-md-6 d-flex gap-2">
+							<button type="submit" class="btn btn-sm btn-primary">Connect</button>
+							<button type="button" id="ws-disconnect" class="btn btn-sm btn-outline-secondary" disabled>Disconnect</button>
 						</div>
-					</div>
+					</form>
 				</div>
 			</div>
 		</section>
 
 		<section class="mb-4">
-			<div class="row g-4">
-				<div class="col-lg-6">
-					<div class="card h-100 shadow-sm">
-						<div class="card-header fw-semibold">Headers</div>
-						<div class="card-body">
-							{{if .Headers}}
-								<div class="table-responsive">
-									<table class="table table-sm align-middle mb-0">
-										<tbody>
-											{{range .Headers}}
-											<tr>
-												<th scope="row" class="text-nowrap">{{.Key}}</th>
-												<td>
-													{{range .Values}}<span class="badge text-bg-primary me-1">{{.}}</span>{{end}}
-												</td>
-											</tr>
-											{{end}}
-										</tbody>
-									</table>
-								</div>
-							{{else}}
-								<p class="text-muted mb-0">No headers were supplied.</p>
-							{{end}}
+			<div class="card shadow-sm">
+				<div class="card-header fw-semibold">Send</div>
+				<div class="card-body">
+					<form id="ws-send" class="row g-2 align-items-end">
+						<div class="col-md-8">
+							<label for="ws-message" class="form-label small text-muted">Message</label>
+							<input id="ws-message" class="form-control form-control-sm" value="hello reflector">
+						</div>
+						<div class="col-md-4 d-flex gap-2">
+							<button type="submit" class="btn btn-sm btn-primary" disabled>Send text</button>
+							<button type="button" id="ws-send-binary" class="btn btn-sm btn-outline-primary" disabled>Send binary</button>
 						</div>
+					</form>
+				</div>
+			</div>
+		</section>
+
+		<section class="mb-5">
+			<div class="card shadow-sm">
+				<div class="card-header fw-semibold">Messages</div>
+				<div class="card-body">
+					<pre id="ws-log" class="mb-0"></pre>
+				</div>
+			</div>
+		</section>
+
+		<footer class="text-muted small">
+			HTTP Reflector · <a href="/">back to request reflection</a>
+		</footer>
+	</div>
+
+	<script src="{{asset "websocket.js"}}"></script>
+</body>
+</html>{{end}}`
+
+const cookiesTemplateHTML = `{{define "cookies"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Cookie Tester</title>
+	{{template "head" .}}
+</head>
+<body class="cookies-page">
+	<div class="container py-4">
+		<header class="mb-4">
+			<h1 class="h3 mb-1">Cookie Tester</h1>
+			<p class="text-muted mb-0">Sets cookies with the attributes you choose, redirects, and reports which ones your browser sent back.</p>
+		</header>
+
+		{{if .Error}}
+			<div class="alert alert-danger mb-4" role="alert">{{.Error}}</div>
+		{{end}}
+		{{if not .Secure}}
+			<div class="alert alert-secondary mb-4" role="alert">
+				This page was loaded over plain HTTP, so browsers will refuse cookies marked <code>Secure</code>.
+			</div>
+		{{end}}
+
+		{{if .Results}}
+		<section class="mb-4">
+			<div class="card shadow-sm">
+				<div class="card-header fw-semibold">Round Trip</div>
+				<div class="card-body">
+					<div class="table-responsive">
+						<table class="table table-sm align-middle mb-0">
+							<thead>
+								<tr><th scope="col">Set-Cookie</th><th scope="col">Sent back</th><th scope="col">Notes</th></tr>
+							</thead>
+							<tbody>
+								{{range .Results}}
+								<tr>
+									<td><code>{{.SetCookie}}</code></td>
+									<td class="text-nowrap">
+										{{if not .Returned}}<span class="badge text-bg-danger">missing</span>
+										{{else if .Matches}}<span class="badge text-bg-success">yes</span>
+										{{else}}<span class="badge text-bg-warning">different value</span> <code>{{.ReturnedValue}}</code>{{end}}
+									</td>
+									<td class="small text-muted">{{range $i, $w := .Warnings}}{{if $i}}<br>{{end}}{{$w}}{{end}}</td>
+								</tr>
+								{{end}}
+							</tbody>
+						</table>
 					</div>
 				</div>
-				<div class="col-lg-6">
-					<div class="card h-100 shadow-sm">
-						<div class="card-header fw-semibold">Query Parameters</div>
-						<div class="card-body">
-							{{if .Query}}
-								<div class="table-responsive">
-									<table class="table table-sm align-middle mb-0">
-										<tbody>
-											{{range .Query}}
-											<tr>
-												<th scope="row" class="text-nowrap">{{.Key}}</th>
-												<td>
-													{{range .Values}}<span class="badge text-bg-info me-1">{{.}}</span>{{end}}
-												</td>
-											</tr>
-											{{end}}
-										</tbody>
-									</table>
-								</div>
-							{{else}}
-								<p class="text-muted mb-0">No query parameters detected.</p>
-							{{end}}
+			</div>
+		</section>
+		{{end}}
+
+		<section class="mb-4">
+			<div class="card shadow-sm">
+				<div class="card-header fw-semibold">Set a Cookie</div>
+				<div class="card-body">
+					<form method="post" action="/cookies" class="row g-2 align-items-end">
+						<div class="col-md-3">
+							<label for="cookie-name" class="form-label small text-muted">Name</label>
+							<input id="cookie-name" name="name" class="form-control form-control-sm" value="rf_test" required>
 						</div>
-					</div>
+						<div class="col-md-3">
+							<label for="cookie-value" class="form-label small text-muted">Value</label>
+							<input id="cookie-value" name="value" class="form-control form-control-sm" value="hello">
+						</div>
+						<div class="col-md-3">
+							<label for="cookie-domain" class="form-label small text-muted">Domain</label>
+							<input id="cookie-domain" name="domain" class="form-control form-control-sm" placeholder="host-only">
+						</div>
+						<div class="col-md-3">
+							<label for="cookie-path" class="form-label small text-muted">Path</label>
+							<input id="cookie-path" name="path" class="form-control form-control-sm" value="/">
+						</div>
+						<div class="col-md-3">
+							<label for="cookie-samesite" class="form-label small text-muted">SameSite</label>
+							<select id="cookie-samesite" name="samesite" class="form-select form-select-sm">
+								<option value="">(not set)</option>
+								<option>Lax</option>
+								<option>Strict</option>
+								<option>None</option>
+							</select>
+						</div>
+						<div class="col-md-3">
+							<label for="cookie-max-age" class="form-label small text-muted">Max-Age (seconds)</label>
+							<input id="cookie-max-age" name="max_age" class="form-control form-control-sm" placeholder="session">
+						</div>
+						<div class="col-md-6 d-flex flex-wrap gap-3">
+							<div class="form-check"><input class="form-check-input" type="checkbox" id="cookie-secure" name="secure"{{if .Secure}} checked{{end}}><label class="form-check-label small" for="cookie-secure">Secure</label></div>
+							<div class="form-check"><input class="form-check-input" type="checkbox" id="cookie-httponly" name="httponly"><label class="form-check-label small" for="cookie-httponly">HttpOnly</label></div>
+							<div class="form-check"><input class="form-check-input" type="checkbox" id="cookie-partitioned" name="partitioned"><label class="form-check-label small" for="cookie-partitioned">Partitioned</label></div>
+						</div>
+						<div class="col-12 d-flex gap-2">
+							<button type="submit" class="btn btn-sm btn-primary">Set cookie</button>
+							<button type="submit" name="action" value="matrix" class="btn btn-sm btn-outline-primary" formnovalidate>Set test matrix</button>
+							<button type="submit" name="action" value="clear" class="btn btn-sm btn-outline-danger" formnovalidate>Clear received cookies</button>
+						</div>
+					</form>
+				</div>
+			</div>
+		</section>
+
+		<section class="mb-5">
+			<div class="card shadow-sm">
+				<div class="card-header fw-semibold d-flex justify-content-between align-items-center">
+					<span>Cookies Received on This Request</span>
+					<span class="text-muted small">{{.HeaderBytes}} bytes in Cookie header</span>
+				</div>
+				<div class="card-body">
+					{{if .Received}}
+						<div class="table-responsive">
+							<table class="table table-sm align-middle mb-0">
+								<tbody>
+									{{range .Received}}
+									<tr>
+										<th scope="row" class="text-nowrap">{{.Name}}</th>
+										<td>
+											<code>{{.Value}}</code>
+											{{if .Duplicate}}<span class="badge text-bg-warning ms-1">duplicate</span>{{end}}
+											{{range .Problems}}<span class="badge text-bg-danger ms-1">{{.}}</span>{{end}}
+										</td>
+										<td class="text-muted small text-end text-nowrap">{{.Size}} B</td>
+									</tr>
+									{{end}}
+								</tbody>
+							</table>
+						</div>
+					{{else}}
+						<p class="text-muted mb-0">No cookies were sent with this request.</p>
+					{{end}}
 				</div>
 			</div>
 		</section>
 
+		<footer class="text-muted small">
+			HTTP Reflector · <a href="/">back to request reflection</a>
+		</footer>
+	</div>
+</body>
+</html>{{end}}`
+
+const replayTemplateHTML = `{{define "replay"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Replay · HTTP Reflector</title>
+	{{template "head" .}}
+</head>
+<body>
+	<div class="container py-4">
+		<header class="mb-4">
+			<h1 class="h3 mb-1">Replay</h1>
+			<p class="text-muted mb-0"><code>{{.Result.Request.Method}} {{.Result.Request.URL}}</code></p>
+		</header>
+
+		{{with .Result}}
+			{{if .Error}}
+				<div id="replay-error" class="alert alert-danger mb-4" role="alert">{{.Error}}</div>
+			{{else}}{{with .Response}}
+				<div id="replay-status" class="alert {{if lt .Status 400}}alert-success{{else}}alert-warning{{end}} mb-4" role="alert">
+					Target answered <strong>{{.Status}} {{.StatusText}}</strong> over {{.Proto}} in {{printf "%.1f" .DurationMS}} ms.
+				</div>
+			{{end}}{{end}}
+			{{range .Notes}}
+				<div class="alert alert-secondary small mb-2" role="alert">The capture was replayed as stored: {{.}}.</div>
+			{{end}}
+			<p class="small mb-4">
+				<span class="text-muted">Host sent:</span> <code>{{.Request.Host}}</code>
+				{{range .Request.Modified}}<span class="badge text-bg-info ms-1">{{.}}</span>{{end}}
+			</p>
+		{{end}}
+
 		<section class="mb-4">
 			<div class="row g-4">
 				<div class="col-lg-6">
 					<div class="card h-100 shadow-sm">
-						<div class="card-header fw-semibold">Cookies</div>
+						<div class="card-header fw-semibold">Captured request</div>
 						<div class="card-body">
-							{{if .Reflection.Cookies}}
-								<div class="table-responsive">
-									<table class="table table-sm align-middle mb-0">
-										<tbody>
-											{{range .Reflection.Cookies}}
-											<tr>
-												<th scope="row" class="text-nowrap">{{.Name}}</th>
-												<td><code>{{.Value}}</code></td>
-											</tr>
-											{{end}}
-										</tbody>
-									</table>
-								</div>
-							{{else}}
-								<p class="text-muted mb-0">No cookies were provided.</p>
+							{{with .Original}}
+								<p class="small"><code>{{.Method}} {{.RequestURI}} {{.Proto}}</code><br><span class="text-muted">Host:</span> <code>{{.Host}}</code></p>
+								{{template "replay-headers" .Headers}}
+								{{if .BodyPreview}}<pre class="mt-3 mb-0">{{.BodyPreview}}</pre>{{end}}
 							{{end}}
 						</div>
 					</div>
 				</div>
 				<div class="col-lg-6">
 					<div class="card h-100 shadow-sm">
-						<div class="card-header fw-semibold">TLS</div>
+						<div class="card-header fw-semibold">Target response</div>
 						<div class="card-body">
-							{{with .Reflection.TLS}}
-								<dl class="row mb-0 small">
-									<dt class="col-sm-4 text-muted">Version</dt>
-									<dd class="col-sm-8">{{.Version}}</dd>
-									<dt class="col-sm-4 text-muted">Cipher Suite</dt>
-									<dd class="col-sm-8">{{.CipherSuite}}</dd>
-									<dt class="col-sm-4 text-muted">Server Name</dt>
-									<dd class="col-sm-8">{{if .ServerName}}{{.ServerName}}{{else}}<span class="text-muted">n/a</span>{{end}}</dd>
-									<dt class="col-sm-4 text-muted">ALPN</dt>
-									<dd class="col-sm-8">{{if .Negotiated}}{{.Negotiated}}{{else}}<span class="text-muted">n/a</span>{{end}}</dd>
-								</dl>
+							{{with .Result.Response}}
+								<p class="small"><code>{{.Proto}} {{.Status}} {{.StatusText}}</code></p>
+								{{template "replay-headers" .Headers}}
+								{{if .Body}}
+									<p class="small text-muted mt-3 mb-1">{{.BodyBytes}} bytes{{if .BodyDecompressed}}, shown decompressed{{end}}{{if .BodyTruncated}} <span class="badge text-bg-warning">truncated</span>{{end}}</p>
+									<pre class="mb-0">{{.Body}}</pre>
+								{{end}}
 							{{else}}
-								<p class="text-muted mb-0">Connection is not using TLS.</p>
+								<p class="text-muted mb-0">No response was received.</p>
 							{{end}}
 						</div>
 					</div>
@@ -181,205 +893,158 @@ const pageTemplateHTML = `<!DOCTYPE html>
 			</div>
 		</section>
 
-		<section class="mb-4">
-			<div class="card shadow-sm">
-				<div class="card-header fw-semibold d-flex justify-content-between align-items-center">
-					<span>Request Body</span>
-					<span class="text-muted small">{{len .Reflection.BodyPreview}} bytes shown</span>
-				</div>
-				<div class="card-body">
-					{{if .Reflection.BodyPreview}}
-						<pre class="mb-0">{{.Reflection.BodyPreview}}</pre>
-					{{else}}
-						<p class="text-muted mb-0">No request body captured.</p>
-					{{end}}
-				</div>
-			</div>
-		</section>
-
 		<section class="mb-5">
 			<div class="card shadow-sm">
-				<div class="card-header fw-semibold">Browser Metadata</div>
+				<div class="card-header fw-semibold">Sent request headers</div>
 				<div class="card-body">
-					{{if .ClientJSON}}
-						<pre class="mb-0">{{.ClientJSON}}</pre>
-					{{else}}
-						<p class="text-muted mb-0">Waiting for the browser script to provide additional context...</p>
-					{{end}}
+					{{template "replay-headers" .Result.Request.Headers}}
 				</div>
 			</div>
 		</section>
 
 		<footer class="text-muted small">
-			HTTP Reflector · helpful for CDN debugging and origin verification.
+			HTTP Reflector · <a href="/">back to request reflection</a>
 		</footer>
 	</div>
+</body>
+</html>{{end}}
+
+{{define "replay-headers"}}
+{{if .}}
+<div class="table-responsive">
+	<table class="table table-sm align-middle mb-0">
+		<tbody>
+			{{range $name, $values := .}}
+			<tr>
+				<th scope="row" class="text-nowrap">{{$name}}</th>
+				<td>{{range $values}}<span class="badge text-bg-primary me-1 text-wrap text-break">{{.}}</span>{{end}}</td>
+			</tr>
+			{{end}}
+		</tbody>
+	</table>
+</div>
+{{else}}
+<p class="text-muted small mb-0">No headers.</p>
+{{end}}
+{{end}}`
+
+const diffTemplateHTML = `{{define "diff"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Compare requests · HTTP Reflector</title>
+	{{template "head" .}}
+</head>
+<body>
+	<div class="container py-4">
+		<header class="mb-4">
+			<h1 class="h3 mb-1">Compare requests</h1>
+			<p class="text-muted mb-0">Pick two captured requests to see which headers, query parameters, cookies, TLS fields and body lines differ.</p>
+		</header>
+
+		<form id="diff-form" method="get" action="/diff" class="row g-2 align-items-end mb-4">
+			{{template "diff-picker" .Picker "a"}}
+			{{template "diff-picker" .Picker "b"}}
+			<div class="col-md-2">
+				<button type="submit" class="btn btn-primary w-100">Compare</button>
+			</div>
+		</form>
+
+		{{with .Diff}}
+			<div id="diff-summary" class="alert {{if .Changes}}alert-warning{{else}}alert-success{{end}} mb-4" role="alert">
+				{{if .Changes}}<strong>{{.Changes}}</strong> difference{{if ne .Changes 1}}s{{end}} between the two requests.{{else}}The two requests are identical.{{end}}
+				<a class="ms-2" href="/diff?a={{.A.ID}}&b={{.B.ID}}&format=json">JSON</a>
+			</div>
+
+			<div class="row g-2 small mb-4">
+				<div class="col-md-6"><span class="badge text-bg-secondary">A</span> <code>{{.A.Method}} {{.A.RequestURI}}</code> <span class="text-muted">{{.A.Timestamp.Format "2006-01-02 15:04:05 MST"}}</span></div>
+				<div class="col-md-6"><span class="badge text-bg-secondary">B</span> <code>{{.B.Method}} {{.B.RequestURI}}</code> <span class="text-muted">{{.B.Timestamp.Format "2006-01-02 15:04:05 MST"}}</span></div>
+			</div>
 
-	<script>
-		window.__reflectorHasClientData = {{if .HasClientData}}true{{else}}false{{end}};
-		{{.ClientScript}}
-	</script>
+			{{range .Sections}}
+			<section class="mb-4">
+				<div class="card shadow-sm">
+					<div class="card-header fw-semibold">{{.Name}}</div>
+					<div class="card-body">
+						{{with .Changed}}
+						<div class="table-responsive">
+							<table class="table table-sm align-middle mb-0">
+								<thead>
+									<tr><th scope="col">Name</th><th scope="col"></th><th scope="col" class="w-50">A</th><th scope="col" class="w-50">B</th></tr>
+								</thead>
+								<tbody>
+									{{range .}}{{template "diff-row" .}}{{end}}
+								</tbody>
+							</table>
+						</div>
+						{{else}}
+						<p class="text-muted small mb-0">No differences.</p>
+						{{end}}
+						{{with .Same}}
+						<details class="mt-2 small">
+							<summary class="text-muted">{{len .}} unchanged</summary>
+							<table class="table table-sm align-middle mb-0 mt-2">
+								<tbody>
+									{{range .}}{{template "diff-row" .}}{{end}}
+								</tbody>
+							</table>
+						</details>
+						{{end}}
+					</div>
+				</div>
+			</section>
+			{{end}}
+
+			<section class="mb-5">
+				<div class="card shadow-sm">
+					<div class="card-header fw-semibold">Body</div>
+					<div class="card-body">
+						{{with .Body}}
+							{{if or .ATruncated .BTruncated}}
+								<p class="small text-muted">Only the captured preview is compared; {{if and .ATruncated .BTruncated}}both bodies were{{else if .ATruncated}}body A was{{else}}body B was{{end}} truncated.</p>
+							{{end}}
+							{{if .Equal}}
+								<p class="text-muted small mb-0">No differences.</p>
+							{{else}}
+								<pre id="diff-body" class="diff-body mb-0">{{range .Lines}}<span class="{{if eq .Op "+"}}text-success{{else if eq .Op "-"}}text-danger{{end}}">{{.Op}} {{.Text}}</span>{{end}}</pre>
+							{{end}}
+						{{end}}
+					</div>
+				</div>
+			</section>
+		{{end}}
+
+		<footer class="text-muted small">
+			HTTP Reflector · <a href="/">back to request reflection</a>
+		</footer>
+	</div>
 </body>
-</html>`
-
-const clientCollectorScript = `(function () {
-	"use strict";
-	const hasClientData = !!window.__reflectorHasClientData;
-	const statusEl = document.getElementById("status-message");
-	const endpoint = "/collect";
-
-	function updateStatus(message, variant) {
-		if (!statusEl) {
-			return;
-		}
-		statusEl.textContent = message;
-		statusEl.className = "alert alert-" + variant;
-	}
-
-	if (!window.fetch) {
-		updateStatus("Browser fetch API is unavailable in this environment.", "warning");
-		return;
-	}
-
-	if (hasClientData) {
-		return;
-	}
-
-	function storageKeys(storage) {
-		if (!storage) {
-			return [];
-		}
-		const keys = [];
-		try {
-			for (let i = 0; i < storage.length; i += 1) {
-				const key = storage.key(i);
-				if (key !== null) {
-					keys.push(key);
-				}
-			}
-		} catch (err) {
-			return ["unavailable"];
-		}
-		return keys;
-	}
-
-	function boolOrNull(value) {
-		return typeof value === "boolean" ? value : null;
-	}
-
-	function gatherClientData() {
-		const nav = typeof navigator === "undefined" ? {} : navigator;
-		const scr = typeof window === "undefined" || !window.screen ? {} : window.screen;
-		const connection = nav.connection || nav.mozConnection || nav.webkitConnection;
-		const colorScheme = typeof window !== "undefined" && window.matchMedia
-			? (window.matchMedia("(prefers-color-scheme: dark)").matches
-				? "dark"
-				: (window.matchMedia("(prefers-color-scheme: light)").matches ? "light" : "no-preference"))
-			: null;
-		const touchPoints = typeof nav.maxTouchPoints === "number" ? nav.maxTouchPoints : null;
-		let timezone = null;
-		try {
-			if (typeof Intl !== "undefined" && Intl.DateTimeFormat) {
-				timezone = Intl.DateTimeFormat().resolvedOptions().timeZone || null;
-			}
-		} catch (_) {
-			timezone = null;
-		}
-
-		const connectionInfo = connection
-			? {
-				type: connection.type || null,
-				effectiveType: connection.effectiveType || null,
-				downlink: connection.downlink || null,
-				rtt: connection.rtt || null,
-				saveData: boolOrNull(connection.saveData),
-			}
-			: null;
-
-		const performanceInfo = typeof performance !== "undefined" && performance.memory
-			? {
-				jsHeapSizeLimit: performance.memory.jsHeapSizeLimit,
-				totalJSHeapSize: performance.memory.totalJSHeapSize,
-				usedJSHeapSize: performance.memory.usedJSHeapSize,
-			}
-			: null;
-
-		return {
-			timestamp: new Date().toISOString(),
-			location: window.location.href,
-			referrer: document.referrer || null,
-			userAgent: nav.userAgent || null,
-			language: nav.language || null,
-			languages: Array.isArray(nav.languages) ? nav.languages : [],
-			platform: nav.platform || null,
-			deviceMemory: typeof nav.deviceMemory === "number" ? nav.deviceMemory : null,
-			hardwareConcurrency: typeof nav.hardwareConcurrency === "number" ? nav.hardwareConcurrency : null,
-			doNotTrack: nav.doNotTrack || window.doNotTrack || null,
-			cookieEnabled: boolOrNull(nav.cookieEnabled),
-			onLine: boolOrNull(nav.onLine),
-			timezone: timezone,
-			timezoneOffsetMinutes: new Date().getTimezoneOffset(),
-			screen: {
-				width: scr.width || null,
-				height: scr.height || null,
-				availWidth: scr.availWidth || null,
-				availHeight: scr.availHeight || null,
-				colorDepth: scr.colorDepth || null,
-				pixelDepth: scr.pixelDepth || null,
-			},
-			viewport: {
-				width: typeof window.innerWidth === "number" ? window.innerWidth : null,
-				height: typeof window.innerHeight === "number" ? window.innerHeight : null,
-			},
-			colorScheme: colorScheme,
-			historyLength: window.history ? window.history.length : null,
-			connection: connectionInfo,
-			performance: performanceInfo,
-			localStorageKeys: storageKeys(window.localStorage),
-			sessionStorageKeys: storageKeys(window.sessionStorage),
-			touchSupport: {
-				maxTouchPoints: touchPoints,
-				touchEvent: typeof window.ontouchstart !== "undefined",
-				pointerEvent: typeof window.PointerEvent !== "undefined",
-			},
-			mediaDevices: !!(nav.mediaDevices && nav.mediaDevices.enumerateDevices),
-		};
-	}
-
-	function onReady(callback) {
-		if (document.readyState === "loading") {
-			document.addEventListener("DOMContentLoaded", callback, { once: true });
-		} else {
-			callback();
-		}
-	}
-
-	async function send() {
-		updateStatus("Collecting additional details from your browser...", "info");
-		const payload = gatherClientData();
-		try {
-			const response = await fetch(endpoint, {
-				method: "POST",
-				credentials: "same-origin",
-				headers: {
-					"Content-Type": "application/json",
-				},
-				body: JSON.stringify(payload),
-			});
-			if (!response.ok) {
-				const text = await response.text();
-				throw new Error("server responded with " + response.status + ": " + text);
-			}
-			updateStatus("Rendering browser metadata...", "info");
-			const html = await response.text();
-			document.open();
-			document.write(html);
-			document.close();
-		} catch (err) {
-			const message = err && err.message ? err.message : String(err);
-			updateStatus("Failed to capture browser details: " + message, "danger");
-		}
-	}
-
-	onReady(send);
-})()`
+</html>{{end}}
+
+{{define "diff-picker"}}
+<div class="col-md-5">
+	<label class="form-label small" for="diff-{{.Name}}">Request {{if eq .Name "a"}}A{{else}}B{{end}}</label>
+	<select class="form-select form-select-sm" id="diff-{{.Name}}" name="{{.Name}}" required>
+		<option value="">Choose a capture…</option>
+		{{$selected := .Selected}}
+		{{range .Captures}}
+		<option value="{{.ID}}"{{if eq .ID $selected}} selected{{end}}>{{.Timestamp.Format "15:04:05"}} · {{.HTTP.Method}} {{.HTTP.Host}}{{.HTTP.RequestURI}}</option>
+		{{end}}
+	</select>
+</div>
+{{end}}
+
+{{define "diff-row"}}
+<tr>
+	<th scope="row" class="text-nowrap">{{.Name}}</th>
+	<td>
+		{{if eq .Change "added"}}<span class="badge text-bg-success">added</span>
+		{{else if eq .Change "removed"}}<span class="badge text-bg-danger">removed</span>
+		{{else if eq .Change "changed"}}<span class="badge text-bg-warning">changed</span>
+		{{else}}<span class="badge text-bg-light">same</span>{{end}}
+	</td>
+	<td>{{range .A}}<code class="d-block text-break">{{.}}</code>{{else}}<span class="text-muted">—</span>{{end}}</td>
+	<td>{{range .B}}<code class="d-block text-break">{{.}}</code>{{else}}<span class="text-muted">—</span>{{end}}</td>
+</tr>
+{{end}}`
@@ -0,0 +1,344 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+const (
+	defaultReplayTimeout = 10 * time.Second
+	replayBodyLimit      = 64 << 10
+)
+
+// ReplayConfig allows captured requests to be sent again. Only the listed
+// targets can be reached, so reflector cannot be used to probe arbitrary
+// hosts.
+type ReplayConfig struct {
+	// Targets are base URLs; the captured request URI is appended to the
+	// chosen one.
+	Targets []*url.URL
+	Timeout time.Duration
+}
+
+// ParseReplayTargets parses base URLs such as https://origin.internal or
+// http://10.0.0.5:8080/app.
+func ParseReplayTargets(values []string) ([]*url.URL, error) {
+	var targets []*url.URL
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		u, err := parseBaseURL("replay target", v)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, u)
+	}
+	return targets, nil
+}
+
+// parseBaseURL accepts an http or https URL that request URIs are appended
+// to; a trailing slash on its path is dropped.
+func parseBaseURL(what, v string) (*url.URL, error) {
+	u, err := url.Parse(v)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%s %q must be an http or https URL", what, v)
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return nil, fmt.Errorf("%s %q must not have credentials, a query or a fragment", what, v)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	return u, nil
+}
+
+// replayHopHeaders are not forwarded: they describe the captured connection,
+// or the client computes them for the new one.
+var replayHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Proxy-Connection",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade", "Content-Length", "Host",
+}
+
+type replayRequest struct {
+	Method   string              `json:"method"`
+	URL      string              `json:"url"`
+	Host     string              `json:"host"`
+	Headers  map[string][]string `json:"headers"`
+	Body     string              `json:"body,omitempty"`
+	Modified []string            `json:"modified,omitempty"`
+}
+
+type replayResponse struct {
+	Proto            string              `json:"proto"`
+	Status           int                 `json:"status"`
+	StatusText       string              `json:"status_text"`
+	Headers          map[string][]string `json:"headers"`
+	Body             string              `json:"body,omitempty"`
+	BodyBytes        int                 `json:"body_bytes"`
+	BodyTruncated    bool                `json:"body_truncated,omitempty"`
+	BodyDecompressed bool                `json:"body_decompressed,omitempty"`
+	DurationMS       float64             `json:"duration_ms"`
+}
+
+// replayResult pairs what was sent with what came back.
+type replayResult struct {
+	CaptureID string          `json:"capture_id"`
+	Target    string          `json:"target"`
+	Request   replayRequest   `json:"request"`
+	Response  *replayResponse `json:"response,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Notes     []string        `json:"notes,omitempty"`
+}
+
+type replayPage struct {
+	Original *reflection
+	Result   replayResult
+}
+
+// headerEdits are applied to the captured headers before sending. Each line
+// of the form is "Name: value" to replace a header (repeat the line for
+// several values) or "-Name" to drop it.
+type headerEdits struct {
+	set    map[string][]string
+	order  []string
+	remove []string
+}
+
+func parseHeaderEdits(text string) (headerEdits, error) {
+	e := headerEdits{set: make(map[string][]string)}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if name, ok := strings.CutPrefix(line, "-"); ok {
+			e.remove = append(e.remove, http.CanonicalHeaderKey(strings.TrimSpace(name)))
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if !ok || !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+			return e, fmt.Errorf("invalid header edit %q", line)
+		}
+		if _, seen := e.set[name]; !seen {
+			e.order = append(e.order, name)
+		}
+		e.set[name] = append(e.set[name], value)
+	}
+	return e, nil
+}
+
+func (e headerEdits) apply(h http.Header) []string {
+	var modified []string
+	for _, name := range e.remove {
+		h.Del(name)
+		modified = append(modified, "-"+name)
+	}
+	for _, name := range e.order {
+		h[name] = e.set[name]
+		modified = append(modified, name)
+	}
+	return modified
+}
+
+// replayHandler serves POST /replay/{capture}. The form picks a target from
+// the allowlist, optionally edits headers and can keep the original Host.
+func (s *Server) replayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	id := strings.TrimPrefix(r.URL.Path, "/replay/")
+	c, ok := s.history.get(id)
+	if !ok || c.HTTP == nil {
+		http.Error(w, "capture not found", http.StatusNotFound)
+		return
+	}
+	target := s.replayTarget(r.FormValue("target"))
+	if target == nil {
+		http.Error(w, "target is not an allowed replay target", http.StatusBadRequest)
+		return
+	}
+	edits, err := parseHeaderEdits(r.FormValue("headers"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// A truncated body or a redacted value would be sent as stored, so the
+	// target would see something the client never sent.
+	if notes := exportNotes(c.HTTP); len(notes) > 0 && r.FormValue("confirm") == "" {
+		http.Error(w, "capture is incomplete ("+strings.Join(notes, "; ")+"); set confirm to replay it anyway", http.StatusConflict)
+		return
+	}
+
+	result := s.sendReplay(r.Context(), c.HTTP, target, edits, r.FormValue("keep_host") != "")
+	result.CaptureID = id
+	s.logger.Info("replay", "capture", id, "url", result.Request.URL, "status", statusOf(result.Response), "err", result.Error)
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		s.writeJSON(w, http.StatusOK, result)
+		return
+	}
+	s.renderPage(w, r, http.StatusOK, "replay", replayPage{Original: c.HTTP, Result: result})
+}
+
+func statusOf(resp *replayResponse) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.Status
+}
+
+// replayTarget returns the configured target matching value, or the first
+// one when value is empty.
+func (s *Server) replayTarget(value string) *url.URL {
+	value = strings.TrimSuffix(strings.TrimSpace(value), "/")
+	for _, t := range s.replay.Targets {
+		if value == "" || value == t.String() {
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *Server) sendReplay(ctx context.Context, ref *reflection, target *url.URL, edits headerEdits, keepHost bool) replayResult {
+	result := replayResult{Target: target.String(), Notes: exportNotes(ref)}
+
+	// An asterisk-form request (OPTIONS *) addresses the server rather than
+	// a path, so it goes to the target host as is.
+	asterisk := ref.RequestURI == "*"
+	dest := (&url.URL{Scheme: target.Scheme, Host: target.Host}).String()
+	if !asterisk {
+		path := ref.RequestURI
+		if u, err := url.ParseRequestURI(path); err == nil {
+			path = u.RequestURI()
+		}
+		dest = target.String() + path
+	}
+	result.Request = replayRequest{Method: ref.Method, URL: dest, Body: ref.BodyPreview}
+
+	timeout := s.replay.Timeout
+	if timeout <= 0 {
+		timeout = defaultReplayTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var body io.Reader = http.NoBody
+	if ref.BodyPreview != "" {
+		body = strings.NewReader(ref.BodyPreview)
+	}
+	req, err := http.NewRequestWithContext(ctx, ref.Method, dest, body)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if asterisk {
+		req.URL.Opaque = "*"
+	}
+	for name, values := range ref.Headers {
+		req.Header[name] = append([]string(nil), values...)
+	}
+	for _, name := range replayHopHeaders {
+		req.Header.Del(name)
+	}
+	if keepHost {
+		req.Host = ref.Host
+	}
+	result.Request.Modified = edits.apply(req.Header)
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+		req.Header.Del("Host")
+	}
+	result.Request.Host = req.Host
+	if result.Request.Host == "" {
+		result.Request.Host = req.URL.Host
+	}
+	result.Request.Headers = cloneHeader(req.Header)
+
+	start := time.Now()
+	resp, err := s.replayClient.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, replayBodyLimit+1))
+	out := &replayResponse{
+		Proto:      resp.Proto,
+		Status:     resp.StatusCode,
+		StatusText: strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode))),
+		Headers:    cloneHeader(resp.Header),
+		BodyBytes:  len(data),
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		// The timeout or the client going away ended the read; show what
+		// arrived before it.
+		out.BodyTruncated = true
+		result.Error = "response body cut short: " + ctx.Err().Error()
+	default:
+		result.Error = "read response body: " + err.Error()
+	}
+	if len(data) > replayBodyLimit {
+		data, out.BodyTruncated = data[:replayBodyLimit], true
+		out.BodyBytes = replayBodyLimit
+	}
+	// The captured Accept-Encoding is sent as is, which stops the transport
+	// from decompressing; undo gzip here so the body is readable.
+	if !out.BodyTruncated {
+		data, out.BodyDecompressed = gunzipPreview(resp.Header, data, replayBodyLimit)
+	}
+	out.Body = string(data)
+	result.Response = out
+	return result
+}
+
+// gunzipPreview decompresses a gzip body for display, returning at most
+// limit bytes. A body cut short yields whatever could be decoded. Other
+// bodies are returned unchanged.
+func gunzipPreview(h http.Header, data []byte, limit int) ([]byte, bool) {
+	if !strings.EqualFold(h.Get("Content-Encoding"), "gzip") {
+		return data, false
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return data, false
+	}
+	plain, err := io.ReadAll(io.LimitReader(zr, int64(limit)))
+	if err != nil && (!errors.Is(err, io.ErrUnexpectedEOF) || len(plain) == 0) {
+		return data, false
+	}
+	return plain, true
+}
+
+func newReplayClient() *http.Client {
+	return &http.Client{
+		Transport: http.DefaultTransport.(*http.Transport).Clone(),
+		// Show redirects instead of following them, which could also leave
+		// the allowlisted target.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
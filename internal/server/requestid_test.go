@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"abc123", true},
+		{"5f2b7c1e-9d3a-4c6b-8e0f-1a2b3c4d5e6f", true},
+		{"Root=1-67891233-abcdef012345678912345678", true},
+		{"trace/span:1+2=3_x.y", true},
+		{strings.Repeat("a", maxRequestIDLength), true},
+		{"", false},
+		{strings.Repeat("a", maxRequestIDLength+1), false},
+		{"has space", false},
+		{"line\nbreak", false},
+		{`quote"d`, false},
+		{"ünïcode", false},
+		{"semi;colon", false},
+	}
+	for _, tt := range tests {
+		if got := validRequestID(tt.id); got != tt.want {
+			t.Errorf("validRequestID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	generated := regexp.MustCompile(`^[0-9a-f]{16}$`)
+	tests := []struct {
+		name    string
+		header  string // configured header, empty for the default
+		inbound map[string]string
+		want    string // expected ID, empty when a new one must be generated
+	}{
+		{name: "valid inbound", inbound: map[string]string{"X-Request-Id": "edge-42"}, want: "edge-42"},
+		{name: "none", inbound: nil},
+		{name: "oversized", inbound: map[string]string{"X-Request-Id": strings.Repeat("x", 200)}},
+		{name: "invalid characters", inbound: map[string]string{"X-Request-Id": "a b<script>"}},
+		{name: "configured header", header: "cf-ray", inbound: map[string]string{"Cf-Ray": "8a1b2c3d4e5f-AMS", "X-Request-Id": "ignored"}, want: "8a1b2c3d4e5f-AMS"},
+		{name: "configured header missing", header: "CF-Ray", inbound: map[string]string{"X-Request-Id": "ignored"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			s := New(4096, WithLogger(jsonLogger(&buf)), WithRequestIDHeader(tt.header))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.inbound {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+
+			header := http.CanonicalHeaderKey(tt.header)
+			if header == "" {
+				header = defaultRequestIDHeader
+			}
+			id := w.Header().Get(header)
+			if tt.want != "" && id != tt.want {
+				t.Errorf("response %s = %q, want %q", header, id, tt.want)
+			}
+			if tt.want == "" && !generated.MatchString(id) {
+				t.Errorf("response %s = %q, want a generated ID", header, id)
+			}
+			if header != defaultRequestIDHeader && w.Header().Get(defaultRequestIDHeader) != "" {
+				t.Errorf("also set %s", defaultRequestIDHeader)
+			}
+			if lines := logLines(t, &buf); len(lines) != 1 || lines[0]["request_id"] != id {
+				t.Errorf("access log %v, want request_id %q", lines, id)
+			}
+			if c := s.history.list(captureHTTP); len(c) != 1 || c[0].HTTP.RequestID != id {
+				t.Errorf("capture has request ID %+v, want %q", c, id)
+			}
+		})
+	}
+}
+
+func TestRequestIDsAreUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		id := newRequestID()
+		if seen[id] {
+			t.Fatalf("duplicate ID %q", id)
+		}
+		seen[id] = true
+	}
+}
@@ -1,31 +1,149 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
-	"html/template"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"time"
 )
 
 type Server struct {
-	bodyCap int
-	mux     *http.ServeMux
+	bodyCap         int
+	historySize     int
+	shareTTL        time.Duration
+	history         *history
+	conns           *connTracker
+	proxyProtoFrom  []*net.IPNet
+	metrics         *metrics
+	logger          *slog.Logger
+	logFields       []string
+	otlpURL         string
+	requestIDHeader string
+	redaction       *RedactionPolicy
+	auth            *AuthConfig
+	rateLimit       RateLimitConfig
+	acceptCH        []string
+	criticalCH      []string
+	geoip           *GeoIP
+	ipRanges        *IPRanges
+	rdns            *reverseDNS
+	templates       *Templates
+	shares          *shareStore
+	replay          ReplayConfig
+	replayClient    *http.Client
+	proxy           ProxyConfig
+	proxyTransport  http.RoundTripper
+	spans           *spanExporter
+	mux             *http.ServeMux
+	admin           *http.ServeMux
 }
 
-func New(bodyCap int) *Server {
-	srv := &Server{bodyCap: bodyCap}
+func New(bodyCap int, opts ...Option) *Server {
+	srv := &Server{
+		bodyCap:         bodyCap,
+		historySize:     defaultHistorySize,
+		shareTTL:        defaultShareTTL,
+		logger:          slog.Default(),
+		requestIDHeader: defaultRequestIDHeader,
+		redaction:       DefaultRedactionPolicy(),
+		templates:       &Templates{set: defaultTemplateSet},
+	}
+	for _, opt := range opts {
+		opt(srv)
+	}
+	srv.history = newHistory(srv.historySize)
+	srv.conns = newConnTracker()
+	srv.metrics = newMetrics()
+	if srv.shareTTL > 0 {
+		srv.shares = newShareStore(srv.shareTTL)
+	}
+	if len(srv.replay.Targets) > 0 {
+		srv.replayClient = newReplayClient()
+	}
+	if srv.proxy.Upstream != nil {
+		srv.proxyTransport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	if srv.otlpURL != "" {
+		srv.spans = newSpanExporter(srv.otlpURL, srv.logger)
+	}
+	srv.admin = http.NewServeMux()
+	srv.handleAdmin(srv.admin)
 	mux := http.NewServeMux()
-	mux.HandleFunc("/healthz", srv.healthHandler)
-	mux.HandleFunc("/", srv.reflectionHandler)
-	mux.HandleFunc("/collect", srv.collectHandler)
+	if srv.proxy.Upstream != nil {
+		// Tee mode forwards every path, so the upstream sees exactly what the
+		// client sent; the admin endpoints are only on AdminHandler.
+		mux.HandleFunc("/", srv.proxyHandler)
+	} else {
+		srv.handleAdmin(mux)
+		mux.HandleFunc("/", srv.reflectionHandler)
+		mux.HandleFunc("/collect", srv.collectHandler)
+		mux.HandleFunc("/ws", srv.websocketHandler)
+		mux.HandleFunc("/cookies", srv.cookiesHandler)
+	}
 	srv.mux = mux
 	return srv
 }
 
+// handleAdmin registers the endpoints that serve reflector itself rather
+// than reflect the request.
+func (s *Server) handleAdmin(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", s.healthHandler)
+	mux.HandleFunc(assetPrefix, s.assetsHandler)
+	mux.HandleFunc("/history", s.requireAuth(s.historyHandler))
+	mux.HandleFunc("/history/", s.requireAuth(s.historyHandler))
+	mux.HandleFunc("/export/", s.requireAuth(s.exportHandler))
+	mux.HandleFunc("/diff", s.requireAuth(s.diffHandler))
+	if s.replayClient != nil {
+		mux.HandleFunc("/replay/", s.requireAuth(s.replayHandler))
+	}
+	mux.HandleFunc("/metrics", s.requireAuth(s.metricsHandler))
+	if s.shares != nil {
+		mux.HandleFunc("/share", s.requireAuth(s.shareCreateHandler))
+		mux.HandleFunc("/s/", s.shareHandler)
+	}
+}
+
+// AdminHandler serves only the admin endpoints: /healthz, /metrics,
+// /history, /export/, /diff, /replay/, /share, /s/ and the assets. Handler
+// serves them too, except in tee mode, where every path is forwarded and
+// AdminHandler is the only way to reach them.
+func (s *Server) AdminHandler() http.Handler {
+	var h http.Handler = s.admin
+	h = s.limitRequests(h)
+	h = s.logRequests(h)
+	h = s.assignRequestID(h)
+	h = s.instrument(h, pathClass)
+	return h
+}
+
 func (s *Server) Handler() http.Handler {
-	return logRequests(s.mux)
+	var h http.Handler = s.mux
+	h = s.recordTiming(h)
+	h = s.trackConnections(h)
+	if s.spans != nil {
+		h = s.traceRequests(h)
+	}
+	h = s.limitRequests(h)
+	h = s.logRequests(h)
+	h = s.assignRequestID(h)
+	classify := pathClass
+	if s.proxy.Upstream != nil {
+		classify = proxiedPathClass
+	}
+	h = s.instrument(h, classify)
+	return h
+}
+
+// Shutdown flushes the spans still queued for the OTLP collector. Call it
+// once the HTTP server has stopped handing requests to Handler.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.spans == nil {
+		return nil
+	}
+	return s.spans.shutdown(ctx)
 }
 
 func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -34,13 +152,14 @@ func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) reflectionHandler(w http.ResponseWriter, r *http.Request) {
-	body, err := readRequestBody(r, s.bodyCap)
+	body, truncated, err := readRequestBody(r, s.bodyCap)
 	if err != nil {
-		log.Printf("read request body: %v", err)
+		s.logger.Error("read request body", "err", err)
 		http.Error(w, "failed to read request body", http.StatusInternalServerError)
 		return
 	}
-	s.renderResponse(w, r, body, nil)
+	s.metrics.observeBody(len(body), truncated)
+	s.renderResponse(w, r, body, truncated, nil)
 }
 
 func (s *Server) collectHandler(w http.ResponseWriter, r *http.Request) {
@@ -55,27 +174,66 @@ func (s *Server) collectHandler(w http.ResponseWriter, r *http.Request) {
 		limit = 1 << 14
 	}
 
-	body, err := readRequestBody(r, limit)
+	body, truncated, err := readRequestBody(r, limit)
 	if err != nil {
-		log.Printf("read client payload: %v", err)
+		s.logger.Error("read client payload", "err", err)
 		http.Error(w, "failed to read client payload", http.StatusInternalServerError)
 		return
 	}
+	s.metrics.observeBody(len(body), truncated)
 
 	var clientData map[string]any
 	if len(body) > 0 {
 		if err := json.Unmarshal(body, &clientData); err != nil {
-			log.Printf("decode client payload: %v", err)
+			s.metrics.collectDecodeFailed()
+			s.logger.Warn("decode client payload", "err", err)
 			http.Error(w, "invalid client payload", http.StatusBadRequest)
 			return
 		}
 	}
 
-	s.renderResponse(w, r, body, clientData)
+	s.renderResponse(w, r, body, truncated, clientData)
+}
+
+func (s *Server) renderResponse(w http.ResponseWriter, r *http.Request, body []byte, truncated bool, clientData map[string]any) {
+	data := s.newReflection(r, body, truncated, clientData)
+	s.redaction.apply(&data)
+	captureID := s.history.add(capture{Kind: captureHTTP, Timestamp: data.Timestamp, HTTP: &data})
+
+	page := newPageData(data)
+	if clientData == nil {
+		page.StatusMessage = "Collecting additional details from your browser..."
+		page.StatusVariant = "info"
+	}
+	if s.historySize > 0 {
+		page.CaptureID = captureID
+		page.ExportPath = "/export/" + captureID
+	}
+	page.Sharing = s.shares != nil
+	if page.CaptureID != "" {
+		for _, t := range s.replay.Targets {
+			page.ReplayTargets = append(page.ReplayTargets, t.String())
+		}
+		page.ReplayNotes = exportNotes(&data)
+	}
+
+	s.advertiseClientHints(w)
+	s.renderPage(w, r, http.StatusOK, "reflection", page)
+}
+
+// newReflection describes r and annotates its addresses. The result is not
+// redacted yet.
+func (s *Server) newReflection(r *http.Request, body []byte, truncated bool, clientData map[string]any) reflection {
+	data := s.describeRequest(r, body, truncated, clientData)
+	s.annotateAddresses(r.Context(), &data, s.rdns.subject(r))
+	return data
 }
 
-func (s *Server) renderResponse(w http.ResponseWriter, r *http.Request, body []byte, clientData map[string]any) {
+// describeRequest is newReflection without the address annotations, which
+// may wait on DNS.
+func (s *Server) describeRequest(r *http.Request, body []byte, truncated bool, clientData map[string]any) reflection {
 	data := reflection{
+		RequestID:        requestIDFromRequest(r),
 		Timestamp:        time.Now().UTC(),
 		Method:           r.Method,
 		Proto:            r.Proto,
@@ -88,55 +246,73 @@ func (s *Server) renderResponse(w http.ResponseWriter, r *http.Request, body []b
 		Headers:          cloneHeader(r.Header),
 		Query:            queryValues(r),
 		Cookies:          cookieValues(r),
+		CookieBytes:      cookieHeaderBytes(r),
 		ContentLength:    r.ContentLength,
 		TransferEncoding: append([]string(nil), r.TransferEncoding...),
 		TLS:              tlsFromRequest(r),
+		Connection:       connFromRequest(r),
+		Timing:           timingFromRequest(r).details(),
+		Tracing:          tracingFromRequest(r),
+		ClientHints:      s.clientHintsFromRequest(r, clientData),
+		UserAgent:        userAgentFromRequest(r.UserAgent(), clientData),
+		Addresses:        addressesFromRequest(r),
 		ClientData:       clientData,
 	}
 
 	if len(body) > 0 {
 		data.BodyPreview = string(body)
+		data.BodyTruncated = truncated
 	}
+	return data
+}
 
-	var clientJSON string
-	if clientData != nil {
-		if pretty, err := json.MarshalIndent(clientData, "", "  "); err == nil {
-			clientJSON = string(pretty)
-		}
-	}
-
-	statusMessage := "Collecting additional details from your browser..."
-	statusVariant := "info"
-	if clientData != nil {
-		statusMessage = "Browser-supplied metadata is shown below."
-		statusVariant = "success"
-	}
+// annotateAddresses adds provider, GeoIP and reverse DNS details, looking up
+// rdnsSubject as returned by reverseDNS.subject.
+func (s *Server) annotateAddresses(ctx context.Context, data *reflection, rdnsSubject string) {
+	s.ipRanges.classify(data.Addresses)
+	s.geoip.annotate(data.Addresses)
+	rdns := s.rdns.annotate(ctx, data.Addresses, rdnsSubject)
+	verifyBot(data.UserAgent, rdns)
+}
 
+// newPageData prepares a reflection for the page template.
+func newPageData(data reflection) pageData {
 	page := pageData{
 		Reflection:    data,
 		Headers:       mapToPairs(data.Headers),
 		Query:         mapToPairs(data.Query),
-		ClientJSON:    clientJSON,
-		HasClientData: clientData != nil,
-		StatusMessage: statusMessage,
-		StatusVariant: statusVariant,
-		ClientScript:  template.JS(clientCollectorScript),
+		HasClientData: data.ClientData != nil,
+		StatusMessage: "Browser-supplied metadata is shown below.",
+		StatusVariant: "success",
 	}
-
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	if err := reflectionTemplate.Execute(w, page); err != nil {
-		log.Printf("render response: %v", err)
+	if data.ClientData != nil {
+		if pretty, err := json.MarshalIndent(data.ClientData, "", "  "); err == nil {
+			page.ClientJSON = string(pretty)
+		}
 	}
+	return page
 }
 
-func readRequestBody(r *http.Request, limit int) ([]byte, error) {
+// readRequestBody reads up to limit bytes of the body and reports whether
+// more data was available beyond it.
+func readRequestBody(r *http.Request, limit int) ([]byte, bool, error) {
 	if r.Body == nil || r.Body == http.NoBody {
-		return nil, nil
+		return nil, false, nil
 	}
 	defer r.Body.Close()
 	if limit <= 0 {
-		return nil, nil
+		return nil, false, nil
+	}
+	rt := timingFromRequest(r)
+	if rt != nil {
+		rt.bodyStart = time.Now()
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(limit)+1))
+	if rt != nil {
+		rt.bodyDone = time.Now()
+	}
+	if len(body) > limit {
+		return body[:limit], true, err
 	}
-	return io.ReadAll(io.LimitReader(r.Body, int64(limit)))
+	return body, false, err
 }
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultShareTTL = 7 * 24 * time.Hour
+	maxShares       = 1000
+)
+
+// share is a snapshot of an HTTP capture published under an unguessable ID.
+// It outlives the capture's slot in the history ring.
+type share struct {
+	ID        string      `json:"id"`
+	CaptureID string      `json:"capture_id"`
+	Created   time.Time   `json:"created"`
+	Expires   time.Time   `json:"expires"`
+	HTTP      *reflection `json:"http"`
+}
+
+// shareStore holds shares until they expire. Sharing a capture again while
+// its share is live returns the same link.
+type shareStore struct {
+	ttl time.Duration
+	// now is the clock, replaced in tests.
+	now func() time.Time
+
+	mu        sync.Mutex
+	shares    map[string]*share
+	byCapture map[string]string
+}
+
+func newShareStore(ttl time.Duration) *shareStore {
+	return &shareStore{ttl: ttl, now: time.Now, shares: make(map[string]*share), byCapture: make(map[string]string)}
+}
+
+// create returns the live share for c, or stores a new one. created reports
+// which of the two happened.
+func (st *shareStore) create(c capture) (sh *share, created bool) {
+	now := st.now().UTC()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if id, ok := st.byCapture[c.ID]; ok {
+		if sh := st.shares[id]; sh != nil && now.Before(sh.Expires) {
+			return sh, false
+		}
+	}
+	st.sweep(now)
+	for len(st.shares) >= maxShares {
+		st.evictOldest()
+	}
+	sh = &share{ID: newShareID(), CaptureID: c.ID, Created: now, Expires: now.Add(st.ttl), HTTP: c.HTTP}
+	st.shares[sh.ID] = sh
+	st.byCapture[c.ID] = sh.ID
+	return sh, true
+}
+
+func (st *shareStore) get(id string) (*share, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	sh, ok := st.shares[id]
+	if !ok || !st.now().Before(sh.Expires) {
+		return nil, false
+	}
+	return sh, true
+}
+
+func (st *shareStore) sweep(now time.Time) {
+	for id, sh := range st.shares {
+		if !now.Before(sh.Expires) {
+			st.remove(id)
+		}
+	}
+}
+
+func (st *shareStore) evictOldest() {
+	var oldest *share
+	for _, sh := range st.shares {
+		if oldest == nil || sh.Created.Before(oldest.Created) {
+			oldest = sh
+		}
+	}
+	if oldest != nil {
+		st.remove(oldest.ID)
+	}
+}
+
+func (st *shareStore) remove(id string) {
+	if sh, ok := st.shares[id]; ok {
+		delete(st.byCapture, sh.CaptureID)
+		delete(st.shares, id)
+	}
+}
+
+func newShareID() string {
+	return randomHex(16)
+}
+
+// shareCreateHandler publishes a captured reflection. Browsers posting the
+// Share form are redirected to the permalink; clients asking for JSON get
+// the links back instead.
+func (s *Server) shareCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 4<<10)
+	id := strings.TrimSpace(r.FormValue("capture"))
+	if id == "" {
+		http.Error(w, "missing capture id", http.StatusBadRequest)
+		return
+	}
+	c, ok := s.history.get(id)
+	if !ok {
+		http.Error(w, "capture not found", http.StatusNotFound)
+		return
+	}
+	if c.HTTP == nil {
+		http.Error(w, "only HTTP captures can be shared", http.StatusBadRequest)
+		return
+	}
+
+	sh, created := s.shares.create(c)
+	link := "/s/" + sh.ID
+	if !strings.Contains(r.Header.Get("Accept"), "application/json") {
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	}
+	base := schemeFromRequest(r) + "://" + r.Host
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	w.Header().Set("Location", link)
+	s.writeJSON(w, status, map[string]any{
+		"id":       sh.ID,
+		"url":      base + link,
+		"json_url": base + link + ".json",
+		"expires":  sh.Expires,
+	})
+}
+
+// shareHandler serves /s/{id} as a read-only reflection page, /s/{id}.json
+// as the stored snapshot and /s/{id}.{format} as an export of it.
+func (s *Server) shareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ext, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/s/"), ".")
+	if _, ok := exportFormats[ext]; !ok && ext != "" && ext != "json" {
+		http.Error(w, "unknown export format", http.StatusNotFound)
+		return
+	}
+	sh, ok := s.shares.get(id)
+	if !ok {
+		http.Error(w, "share not found or expired", http.StatusNotFound)
+		return
+	}
+
+	// The link is the only credential, so keep it out of caches and search
+	// indexes.
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	if ext == "json" {
+		s.writeJSON(w, http.StatusOK, sh)
+		return
+	}
+	if ext != "" {
+		s.writeExport(w, sh.HTTP, "request-"+sh.ID, ext)
+		return
+	}
+	page := newPageData(*sh.HTTP)
+	page.Share = sh
+	page.ExportPath = "/s/" + sh.ID
+	page.StatusMessage = "Read-only snapshot of a request captured at " + sh.HTTP.Timestamp.Format(time.RFC3339) + ". This link expires at " + sh.Expires.Format(time.RFC3339) + "."
+	page.StatusVariant = "secondary"
+	s.renderPage(w, r, http.StatusOK, "reflection", page)
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable clock for stores that take a now function.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func testShareStore(ttl time.Duration) (*shareStore, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	st := newShareStore(ttl)
+	st.now = clock.now
+	return st, clock
+}
+
+func TestShareStoreCreate(t *testing.T) {
+	st, clock := testShareStore(time.Hour)
+	c := capture{ID: "cap1", Kind: captureHTTP, HTTP: &reflection{Method: http.MethodGet}}
+
+	first, created := st.create(c)
+	if !created || len(first.ID) != 32 || first.CaptureID != "cap1" || first.HTTP != c.HTTP {
+		t.Fatalf("first share %+v, created %v", first, created)
+	}
+	if !first.Created.Equal(clock.t) || !first.Expires.Equal(clock.t.Add(time.Hour)) {
+		t.Errorf("created %v expires %v", first.Created, first.Expires)
+	}
+
+	clock.advance(30 * time.Minute)
+	again, created := st.create(c)
+	if created || again.ID != first.ID {
+		t.Errorf("sharing a live capture again made %s (created %v), want %s", again.ID, created, first.ID)
+	}
+	if _, ok := st.get(first.ID); !ok {
+		t.Error("live share not found")
+	}
+	if _, ok := st.get("unknown"); ok {
+		t.Error("unknown share found")
+	}
+
+	// At the expiry instant the share is gone, and sharing again makes a
+	// new link.
+	clock.advance(30 * time.Minute)
+	if _, ok := st.get(first.ID); ok {
+		t.Error("expired share still served")
+	}
+	renewed, created := st.create(c)
+	if !created || renewed.ID == first.ID {
+		t.Errorf("sharing an expired capture returned %s (created %v)", renewed.ID, created)
+	}
+	if _, ok := st.shares[first.ID]; ok {
+		t.Error("expired share kept in the store")
+	}
+}
+
+func TestShareStoreSweep(t *testing.T) {
+	st, clock := testShareStore(time.Hour)
+	for i := 0; i < 3; i++ {
+		st.create(capture{ID: fmt.Sprint("old", i), HTTP: &reflection{}})
+	}
+	clock.advance(40 * time.Minute)
+	fresh, _ := st.create(capture{ID: "fresh", HTTP: &reflection{}})
+	clock.advance(20 * time.Minute)
+
+	// Creating a share sweeps the expired ones, from both indexes.
+	latest, _ := st.create(capture{ID: "latest", HTTP: &reflection{}})
+	if len(st.shares) != 2 || len(st.byCapture) != 2 {
+		t.Fatalf("store holds %d shares, %d captures after the sweep", len(st.shares), len(st.byCapture))
+	}
+	for _, id := range []string{fresh.ID, latest.ID} {
+		if _, ok := st.get(id); !ok {
+			t.Errorf("share %s swept early", id)
+		}
+	}
+}
+
+func TestShareStoreEvictsOldest(t *testing.T) {
+	st, clock := testShareStore(24 * time.Hour)
+	var first, second *share
+	for i := 0; i < maxShares; i++ {
+		sh, _ := st.create(capture{ID: fmt.Sprint("cap", i), HTTP: &reflection{}})
+		switch i {
+		case 0:
+			first = sh
+		case 1:
+			second = sh
+		}
+		clock.advance(time.Second)
+	}
+	st.create(capture{ID: "one-more", HTTP: &reflection{}})
+	if len(st.shares) != maxShares {
+		t.Errorf("store holds %d shares, want %d", len(st.shares), maxShares)
+	}
+	if _, ok := st.get(first.ID); ok {
+		t.Error("oldest share not evicted")
+	}
+	if _, ok := st.byCapture["cap0"]; ok {
+		t.Error("evicted share still indexed by capture")
+	}
+	if _, ok := st.get(second.ID); !ok {
+		t.Error("second oldest share evicted")
+	}
+}
+
+func TestShareHandlers(t *testing.T) {
+	s := New(4096, WithAuth(AuthConfig{Tokens: []string{"tok"}}), WithLogger(discardLogger()))
+	h := s.Handler()
+	id := storeCapture(s, reflection{Method: http.MethodPost, RequestURI: "/app?x=1", Host: "example.com", Headers: map[string][]string{"Accept": {"*/*"}}})
+	dnsID := s.history.add(capture{Kind: captureDNS, DNS: &dnsQuery{}})
+
+	post := func(capture string, authed bool, accept string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/share", strings.NewReader("capture="+capture))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if authed {
+			r.Header.Set("Authorization", "Bearer tok")
+		}
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	if w := post(id, false, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated share: status %d", w.Code)
+	}
+	for capture, status := range map[string]int{"": http.StatusBadRequest, "nope": http.StatusNotFound, dnsID: http.StatusBadRequest} {
+		if w := post(capture, true, ""); w.Code != status {
+			t.Errorf("share %q: status %d, want %d", capture, w.Code, status)
+		}
+	}
+
+	w := post(id, true, "application/json")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("share: status %d %s", w.Code, w.Body)
+	}
+	var created struct {
+		ID      string    `json:"id"`
+		URL     string    `json:"url"`
+		JSONURL string    `json:"json_url"`
+		Expires time.Time `json:"expires"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	link := "/s/" + created.ID
+	if created.URL != "http://example.com"+link || created.JSONURL != created.URL+".json" || w.Header().Get("Location") != link {
+		t.Errorf("links %+v, Location %q", created, w.Header().Get("Location"))
+	}
+	if d := time.Until(created.Expires); d < defaultShareTTL-time.Minute || d > defaultShareTTL {
+		t.Errorf("expires in %v", d)
+	}
+	if w := post(id, true, "application/json"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), created.ID) {
+		t.Errorf("sharing again: status %d %s", w.Code, w.Body)
+	}
+	if w := post(id, true, "text/html"); w.Code != http.StatusSeeOther || w.Header().Get("Location") != link {
+		t.Errorf("form post: status %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+
+	// The share link works without credentials.
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+		contains    string
+	}{
+		{link, http.StatusOK, "text/html; charset=utf-8", "Read-only snapshot"},
+		{link + ".json", http.StatusOK, "application/json", id},
+		{link + ".curl", http.StatusOK, "text/plain; charset=utf-8", "curl"},
+		{link + ".har", http.StatusOK, "application/json", `"entries"`},
+		{link + ".exe", http.StatusNotFound, "", ""},
+		{"/s/" + strings.Repeat("0", 32), http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		w := get(tt.path)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.path, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("%s: Content-Type %q", tt.path, ct)
+		}
+		if !strings.Contains(w.Body.String(), tt.contains) {
+			t.Errorf("%s: body lacks %q", tt.path, tt.contains)
+		}
+		if w.Header().Get("Cache-Control") != "private, no-store" || w.Header().Get("X-Robots-Tag") != "noindex" {
+			t.Errorf("%s: cacheable share: %v", tt.path, w.Header())
+		}
+	}
+
+	if w := get("/share"); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /share without auth: status %d", w.Code)
+	}
+	r := httptest.NewRequest(http.MethodDelete, link, nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE share: status %d", w.Code)
+	}
+}
+
+func TestShareDisabled(t *testing.T) {
+	s := New(4096, WithShareTTL(0), WithLogger(discardLogger()))
+	id := storeCapture(s, reflection{Method: http.MethodGet})
+	r := httptest.NewRequest(http.MethodPost, "/share", strings.NewReader("capture="+id))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code == http.StatusCreated || w.Code == http.StatusSeeOther {
+		t.Errorf("share created with sharing disabled: status %d", w.Code)
+	}
+}
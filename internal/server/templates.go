@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// devReloadInterval is how often dev mode checks the template directory for
+// changes. Walking it on every request would slow down a busy page.
+const devReloadInterval = time.Second
+
+// Templates renders the HTML pages. Each page and each card of the
+// reflection page is a named template; a custom directory can replace any of
+// them.
+type Templates struct {
+	dir string
+	dev bool
+
+	mu      sync.Mutex
+	checked time.Time
+	stamp   string
+	set     *templateSet
+	err     error
+}
+
+// templateSet is one parse of the built-in templates plus any overrides,
+// together with the assets they link to.
+type templateSet struct {
+	tmpl   *template.Template
+	assets *assetSet
+}
+
+var defaultTemplateSet = mustTemplateSet(newTemplateSet(nil))
+
+// LoadTemplates parses the *.html files in dir on top of the built-in
+// templates. A file replaces the template named after it (card-tls.html
+// replaces "card-tls") and may {{define}} further ones. Files under
+// dir/assets are served next to, or instead of, the embedded assets. With dev
+// set the directory is re-read when a file in it changes.
+func LoadTemplates(dir string, dev bool) (*Templates, error) {
+	t := &Templates{dir: dir, dev: dev}
+	stamp, err := t.fingerprint()
+	if err != nil {
+		return nil, fmt.Errorf("read template dir: %w", err)
+	}
+	set, err := newTemplateSet(os.DirFS(dir))
+	if err != nil {
+		return nil, err
+	}
+	t.stamp, t.set = stamp, set
+	return t, nil
+}
+
+func newTemplateSet(dir fs.FS) (*templateSet, error) {
+	var overlay fs.FS
+	if dir != nil {
+		if info, err := fs.Stat(dir, "assets"); err == nil && info.IsDir() {
+			overlay = mustSub(dir, "assets")
+		}
+	}
+	assets := newAssetSet(overlay)
+	tmpl := template.New("reflector").Funcs(template.FuncMap{"asset": assets.path})
+	for _, text := range []string{layoutTemplateHTML, pageTemplateHTML, websocketTemplateHTML, cookiesTemplateHTML, replayTemplateHTML, diffTemplateHTML} {
+		if _, err := tmpl.Parse(text); err != nil {
+			return nil, err
+		}
+	}
+	if dir == nil {
+		return &templateSet{tmpl: tmpl, assets: assets}, nil
+	}
+
+	names, err := fs.Glob(dir, "*.html")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		data, err := fs.ReadFile(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		// A file holding only {{define}} blocks leaves the template it is
+		// named after untouched.
+		if _, err := tmpl.New(strings.TrimSuffix(name, ".html")).Parse(string(data)); err != nil {
+			return nil, fmt.Errorf("parse template %s: %w", name, err)
+		}
+	}
+	return &templateSet{tmpl: tmpl, assets: assets}, nil
+}
+
+func mustTemplateSet(set *templateSet, err error) *templateSet {
+	if err != nil {
+		panic(err)
+	}
+	return set
+}
+
+// current returns the template set to render with. In dev mode it reloads
+// the directory first if anything in it changed since the last check, at
+// most once per devReloadInterval; when that fails the error is returned
+// along with the last set that loaded.
+func (t *Templates) current() (*templateSet, error) {
+	if !t.dev {
+		return t.set, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if now.Sub(t.checked) < devReloadInterval {
+		return t.set, t.err
+	}
+	t.checked = now
+	stamp, err := t.fingerprint()
+	if err != nil {
+		return t.set, err
+	}
+	if stamp != t.stamp {
+		t.stamp = stamp
+		set, err := newTemplateSet(os.DirFS(t.dir))
+		if t.err = err; err == nil {
+			t.set = set
+		}
+	}
+	return t.set, t.err
+}
+
+// fingerprint summarises the name, size and modification time of every file
+// under the template directory.
+func (t *Templates) fingerprint() (string, error) {
+	var b strings.Builder
+	err := fs.WalkDir(os.DirFS(t.dir), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return b.String(), err
+}
+
+// renderPage executes a page into a buffer first, so a failing custom
+// template produces a clean 500 rather than half a page.
+func (s *Server) renderPage(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	set, err := s.templates.current()
+	var buf bytes.Buffer
+	if err == nil {
+		err = set.tmpl.ExecuteTemplate(&buf, name, data)
+	}
+	if err != nil {
+		s.logger.Error("render page", "page", name, "err", err)
+		msg := "failed to render page"
+		if s.templates.dev {
+			msg += ": " + err.Error()
+		}
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+	setPageSecurity(w, r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, _ = buf.WriteTo(w)
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTemplates(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func renderReflection(t *testing.T, h http.Handler) (int, string) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code, w.Body.String()
+}
+
+func TestTemplateOverrides(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplates(t, dir, map[string]string{
+		"card-tls.html": `<section id="custom-tls">custom TLS card for {{.Reflection.Method}}</section>`,
+		// Only {{define}} blocks: the "brand" template itself stays empty.
+		"brand.html": `{{define "head-extra"}}<link rel="stylesheet" href="{{asset "brand.css"}}">{{end}}
+{{define "footer"}}<footer id="brand">ACME edge debugging</footer>{{end}}`,
+		"assets/brand.css": `body { font-family: serif }`,
+		"notes.txt":        `not a template`,
+	})
+	tmpl, err := LoadTemplates(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, body := renderReflection(t, New(4096, WithTemplates(tmpl), WithLogger(discardLogger())).Handler())
+	if code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	for _, want := range []string{
+		`<section id="custom-tls">custom TLS card for GET</section>`,
+		`<footer id="brand">ACME edge debugging</footer>`,
+		`href="` + tmpl.set.assets.path("brand.css") + `"`,
+		// Cards that are not overridden keep their built-in content.
+		`<div class="card-header fw-semibold">Headers`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page lacks %q", want)
+		}
+	}
+	for _, unwanted := range []string{`<div class="card-header fw-semibold">TLS</div>`, "helpful for CDN debugging"} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("page still has the built-in %q", unwanted)
+		}
+	}
+	// Other pages share the overridden partials.
+	w := httptest.NewRecorder()
+	New(4096, WithTemplates(tmpl)).Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cookies", nil))
+	if !strings.Contains(w.Body.String(), "brand.css") {
+		t.Error("cookie page does not use head-extra")
+	}
+}
+
+func TestTemplateParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"card-tls.html": `{{if .Reflection}}unterminated`,
+		"footer.html":   `{{template "no-such-template"}`,
+	}
+	for name, content := range tests {
+		dir := t.TempDir()
+		writeTemplates(t, dir, map[string]string{name: content})
+		if _, err := LoadTemplates(dir, false); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("%s: error %v, want one naming the file", name, err)
+		}
+	}
+	if _, err := LoadTemplates(filepath.Join(t.TempDir(), "missing"), false); err == nil {
+		t.Error("missing directory loaded")
+	}
+}
+
+func TestTemplateExecutionError(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplates(t, dir, map[string]string{"card-tls.html": `{{.NoSuchField}}`})
+	for _, dev := range []bool{false, true} {
+		tmpl, err := LoadTemplates(dir, dev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, body := renderReflection(t, New(4096, WithTemplates(tmpl), WithLogger(discardLogger())).Handler())
+		if code != http.StatusInternalServerError || strings.Contains(body, "<html") {
+			t.Errorf("dev=%v: status %d, body %.80q", dev, code, body)
+		}
+		// The error is only shown to the browser in dev mode.
+		if shown := strings.Contains(body, "NoSuchField"); shown != dev {
+			t.Errorf("dev=%v: error shown %v: %q", dev, shown, body)
+		}
+	}
+}
+
+func TestTemplateDevReload(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplates(t, dir, map[string]string{"footer.html": `<footer>first</footer>`})
+	tmpl, err := LoadTemplates(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(4096, WithTemplates(tmpl), WithLogger(discardLogger())).Handler()
+	render := func() (int, string) {
+		t.Helper()
+		return renderReflection(t, h)
+	}
+	// expire makes the next request check the directory again.
+	expire := func() {
+		tmpl.mu.Lock()
+		tmpl.checked = time.Now().Add(-devReloadInterval)
+		tmpl.mu.Unlock()
+	}
+
+	if _, body := render(); !strings.Contains(body, "<footer>first</footer>") {
+		t.Fatal("initial template not used")
+	}
+
+	writeTemplates(t, dir, map[string]string{"footer.html": `<footer>second version</footer>`})
+	if _, body := render(); !strings.Contains(body, "<footer>first</footer>") {
+		t.Error("directory re-read before the reload interval passed")
+	}
+	expire()
+	if _, body := render(); !strings.Contains(body, "<footer>second version</footer>") {
+		t.Error("change not picked up")
+	}
+
+	// A broken edit is reported, and the last good set keeps its assets.
+	assetPath := tmpl.set.assets.path("reflector.css")
+	writeTemplates(t, dir, map[string]string{"footer.html": `<footer>{{if}}</footer>`})
+	expire()
+	if code, body := render(); code != http.StatusInternalServerError || !strings.Contains(body, "footer.html") {
+		t.Errorf("broken template: status %d, body %q", code, body)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, assetPath, nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("assets while the templates are broken: status %d", w.Code)
+	}
+
+	writeTemplates(t, dir, map[string]string{"footer.html": `<footer>fixed again</footer>`})
+	expire()
+	if code, body := render(); code != http.StatusOK || !strings.Contains(body, "<footer>fixed again</footer>") {
+		t.Errorf("after the fix: status %d", code)
+	}
+}
+
+func TestTemplateDarkTheme(t *testing.T) {
+	// By default the page follows the system setting through theme.js.
+	_, body := renderReflection(t, New(4096).Handler())
+	if strings.Contains(body, "data-bs-theme") {
+		t.Error("default page pins a theme")
+	}
+	if !strings.Contains(body, defaultTemplateSet.assets.path("theme.js")) {
+		t.Error("default page does not load theme.js")
+	}
+	css, err := fs.ReadFile(embeddedAssets, "reflector.css")
+	if err != nil || !strings.Contains(string(css), `[data-bs-theme="dark"]`) {
+		t.Errorf("reflector.css has no dark theme rules (%v)", err)
+	}
+
+	dir := t.TempDir()
+	writeTemplates(t, dir, map[string]string{
+		"reflection.html": `<!DOCTYPE html>
+<html lang="en" data-bs-theme="dark">
+<head>{{template "head" .}}</head>
+<body>{{template "card-overview" .}}{{template "footer" .}}</body>
+</html>`,
+	})
+	tmpl, err := LoadTemplates(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, body := renderReflection(t, New(4096, WithTemplates(tmpl), WithLogger(discardLogger())).Handler())
+	if code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	for _, want := range []string{`<html lang="en" data-bs-theme="dark">`, tmpl.set.assets.path("reflector.css"), "HTTP Reflector ·"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("dark page lacks %q", want)
+		}
+	}
+}
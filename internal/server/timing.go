@@ -0,0 +1,298 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type timingContextKey struct{}
+
+// timingListener wraps accepted connections so reflector can tell when the
+// first byte of each request arrived.
+type timingListener struct {
+	net.Listener
+}
+
+// timedConn records the time of the first read after it has been armed.
+// The server re-arms it whenever the connection goes idle, so each request
+// on a keep-alive connection gets its own first-byte timestamp.
+type timedConn struct {
+	net.Conn
+	acceptedAt time.Time
+	armed      atomic.Bool
+	firstByte  atomic.Int64
+	lastRead   atomic.Int64
+	tlsStart   atomic.Int64
+	tlsDone    atomic.Int64
+	// handshakeRead is the last read of the handshake, which may have
+	// carried the start of the first request as well.
+	handshakeRead atomic.Int64
+}
+
+// requestTiming collects the timestamps of a single request as it moves
+// through the server.
+type requestTiming struct {
+	accepted      time.Time
+	idleSince     time.Time
+	tlsStart      time.Time
+	tlsDone       time.Time
+	firstByte     time.Time
+	headersParsed time.Time
+	bodyStart     time.Time
+	bodyDone      time.Time
+}
+
+type timingDetails struct {
+	TLSHandshakeMS  float64       `json:"tls_handshake_ms,omitempty"`
+	FirstByteWaitMS float64       `json:"first_byte_wait_ms,omitempty"`
+	HeaderParseMS   float64       `json:"header_parse_ms,omitempty"`
+	BodyReadMS      float64       `json:"body_read_ms"`
+	Events          []timingEvent `json:"events"`
+}
+
+type timingEvent struct {
+	Name     string    `json:"name"`
+	At       time.Time `json:"at"`
+	OffsetMS float64   `json:"offset_ms"`
+}
+
+// Listener wraps ln so accepted connections carry the timestamps used in the
+// timing card. It is optional; without it only header parse and body read
+// times are reported.
+func (s *Server) Listener(ln net.Listener) net.Listener {
+	return &timingListener{Listener: ln}
+}
+
+func (l *timingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tc := &timedConn{Conn: c, acceptedAt: time.Now()}
+	tc.armed.Store(true)
+	return tc, nil
+}
+
+func (c *timedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		now := time.Now().UnixNano()
+		c.lastRead.Store(now)
+		if c.armed.CompareAndSwap(true, false) {
+			c.firstByte.Store(now)
+		}
+	}
+	return n, err
+}
+
+func (c *timedConn) rearm() {
+	c.firstByte.Store(0)
+	c.armed.Store(true)
+}
+
+// handshakeDone is called once the TLS handshake has completed. The first
+// read on the connection was the ClientHello; the next one is request data.
+func (c *timedConn) handshakeDone() {
+	c.tlsStart.Store(c.firstByte.Load())
+	c.tlsDone.Store(time.Now().UnixNano())
+	c.handshakeRead.Store(c.lastRead.Load())
+	c.rearm()
+}
+
+// tlsHandshakeTimeout bounds how long TLSListener waits for a client to
+// finish its handshake.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// tlsListener completes each handshake before handing the connection to the
+// HTTP server, so the first-byte timestamp can be re-armed once the client's
+// Finished message has been read.
+type tlsListener struct {
+	net.Listener
+	config    *tls.Config
+	logger    *slog.Logger
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// TLSListener terminates TLS on connections accepted from ln, which is
+// normally the result of Listener. Handshakes run concurrently, so a slow
+// client does not hold up Accept, and the timing card can tell the handshake
+// apart from the first request byte.
+func (s *Server) TLSListener(ln net.Listener, cfg *tls.Config) net.Listener {
+	l := &tlsListener{
+		Listener: ln,
+		config:   cfg,
+		logger:   s.logger,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *tlsListener) acceptLoop() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			// The send above paces retries to the HTTP server's backoff.
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go l.handshake(c)
+	}
+}
+
+func (l *tlsListener) handshake(c net.Conn) {
+	tlsConn := tls.Server(c, l.config)
+	ctx, cancel := context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		l.logger.Debug("tls handshake failed", "peer", c.RemoteAddr().String(), "err", err)
+		c.Close()
+		return
+	}
+	if tc, ok := c.(*timedConn); ok {
+		tc.handshakeDone()
+	}
+	select {
+	case l.conns <- tlsConn:
+	case <-l.done:
+		tlsConn.Close()
+	}
+}
+
+func (l *tlsListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *tlsListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+func timedConnFrom(c net.Conn) *timedConn {
+	if tlsConn, ok := c.(*tls.Conn); ok {
+		c = tlsConn.NetConn()
+	}
+	tc, _ := c.(*timedConn)
+	return tc
+}
+
+// recordTiming stores a requestTiming in the context, seeded with whatever
+// the connection already knows about this request.
+func (s *Server) recordTiming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rt := &requestTiming{headersParsed: time.Now()}
+		if info, ok := r.Context().Value(connContextKey{}).(*connInfo); ok {
+			first := info.requests.Load() <= 1
+			if first {
+				rt.accepted = info.acceptedAt
+			} else if idle := info.idleSince.Load(); idle > 0 {
+				rt.idleSince = time.Unix(0, idle)
+			}
+			// HTTP/2 multiplexes requests without going idle in between, so
+			// only the first stream can be matched to a first byte.
+			if tc := info.timed; tc != nil && (first || r.ProtoMajor == 1) {
+				rt.firstByte = unixNanoTime(tc.firstByte.Load())
+				if first {
+					rt.tlsStart = unixNanoTime(tc.tlsStart.Load())
+					rt.tlsDone = unixNanoTime(tc.tlsDone.Load())
+					// No read since the handshake: the request arrived in
+					// the same segment as the client's Finished message.
+					if rt.firstByte.IsZero() && !rt.tlsDone.IsZero() {
+						rt.firstByte = unixNanoTime(tc.handshakeRead.Load())
+					}
+				}
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), timingContextKey{}, rt)))
+	})
+}
+
+func timingFromRequest(r *http.Request) *requestTiming {
+	rt, _ := r.Context().Value(timingContextKey{}).(*requestTiming)
+	return rt
+}
+
+func (rt *requestTiming) details() *timingDetails {
+	if rt == nil {
+		return nil
+	}
+	out := &timingDetails{}
+	var origin time.Time
+	add := func(name string, at time.Time) {
+		if at.IsZero() {
+			return
+		}
+		if origin.IsZero() {
+			origin = at
+		}
+		out.Events = append(out.Events, timingEvent{Name: name, At: at.UTC(), OffsetMS: msBetween(origin, at)})
+	}
+	add("connection accepted", rt.accepted)
+	add("connection idle", rt.idleSince)
+	add("TLS handshake started", rt.tlsStart)
+	add("TLS handshake complete", rt.tlsDone)
+	add("first header byte", rt.firstByte)
+	add("headers parsed", rt.headersParsed)
+	add("body read started", rt.bodyStart)
+	add("body read complete", rt.bodyDone)
+
+	if !rt.tlsStart.IsZero() && !rt.tlsDone.IsZero() {
+		out.TLSHandshakeMS = msBetween(rt.tlsStart, rt.tlsDone)
+	}
+	waitFrom := rt.idleSince
+	switch {
+	case !rt.tlsDone.IsZero():
+		waitFrom = rt.tlsDone
+	case !rt.accepted.IsZero():
+		waitFrom = rt.accepted
+	}
+	if !waitFrom.IsZero() && !rt.firstByte.IsZero() {
+		// A request sent along with the client's Finished message arrived
+		// before the handshake was complete.
+		out.FirstByteWaitMS = max(0, msBetween(waitFrom, rt.firstByte))
+	}
+	if !rt.firstByte.IsZero() {
+		out.HeaderParseMS = msBetween(rt.firstByte, rt.headersParsed)
+	}
+	if !rt.bodyStart.IsZero() {
+		out.BodyReadMS = msBetween(rt.bodyStart, rt.bodyDone)
+	}
+	return out
+}
+
+func msBetween(from, to time.Time) float64 {
+	return float64(to.Sub(from).Microseconds()) / 1000
+}
+
+func unixNanoTime(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
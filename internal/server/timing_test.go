@@ -0,0 +1,146 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "reflector.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serveTLS runs s behind TLSListener the way main does and returns the
+// listening address.
+func serveTLS(t *testing.T, s *Server) string {
+	t.Helper()
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := s.TLSListener(s.Listener(raw), &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}})
+	hs := &http.Server{Handler: s.Handler(), ConnContext: s.ConnContext, ConnState: s.ConnState}
+	go func() { _ = hs.Serve(ln) }()
+	t.Cleanup(func() { hs.Close() })
+	return raw.Addr().String()
+}
+
+// heldConn passes the ClientHello through and holds every later write until
+// flush, so the client's Finished message and the request share a segment.
+type heldConn struct {
+	net.Conn
+	writes int
+	held   []byte
+}
+
+func (c *heldConn) Write(p []byte) (int, error) {
+	c.writes++
+	if c.writes == 1 {
+		return c.Conn.Write(p)
+	}
+	c.held = append(c.held, p...)
+	return len(p), nil
+}
+
+func (c *heldConn) flush() error {
+	_, err := c.Conn.Write(c.held)
+	return err
+}
+
+const testRequest = "GET / HTTP/1.1\r\nHost: reflector.test\r\nConnection: close\r\n\r\n"
+
+func tlsTiming(t *testing.T, s *Server) *timingDetails {
+	t.Helper()
+	captures := s.history.list(captureHTTP)
+	if len(captures) != 1 || captures[0].HTTP.Timing == nil {
+		t.Fatalf("history has %+v", captures)
+	}
+	return captures[0].HTTP.Timing
+}
+
+func hasTimingEvent(d *timingDetails, name string) bool {
+	for _, e := range d.Events {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTLSFirstByteExcludesHandshake(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		t.Run(tls.VersionName(version), func(t *testing.T) {
+			s := New(4096)
+			conn, err := tls.Dial("tcp", serveTLS(t, s), &tls.Config{InsecureSkipVerify: true, MaxVersion: version})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			time.Sleep(delay)
+			if _, err := io.WriteString(conn, testRequest); err != nil {
+				t.Fatal(err)
+			}
+			_, _ = io.ReadAll(conn)
+
+			timing := tlsTiming(t, s)
+			if timing.TLSHandshakeMS <= 0 {
+				t.Errorf("handshake took %v ms", timing.TLSHandshakeMS)
+			}
+			if timing.FirstByteWaitMS < float64(delay.Milliseconds())*0.8 {
+				t.Errorf("first byte wait %v ms, want about %v: the handshake was counted as request data", timing.FirstByteWaitMS, delay.Milliseconds())
+			}
+		})
+	}
+}
+
+func TestTLSRequestWithFinished(t *testing.T) {
+	s := New(4096)
+	raw, err := net.Dial("tcp", serveTLS(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	held := &heldConn{Conn: raw}
+	conn := tls.Client(held, &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS13})
+	defer conn.Close()
+	if err := conn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(conn, testRequest); err != nil {
+		t.Fatal(err)
+	}
+	if err := held.flush(); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.ReadAll(conn)
+
+	timing := tlsTiming(t, s)
+	if !hasTimingEvent(timing, "first header byte") || timing.FirstByteWaitMS != 0 {
+		t.Errorf("got %+v, want the first byte at the end of the handshake", timing)
+	}
+}
@@ -0,0 +1,421 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type spanContextKey struct{}
+
+const (
+	propagatorW3C     = "w3c"
+	propagatorB3      = "b3"
+	propagatorB3Multi = "b3-multi"
+	propagatorJaeger  = "jaeger"
+	propagatorCloud   = "gcp"
+)
+
+// traceContext is what a single propagation format says about the trace.
+type traceContext struct {
+	Propagator   string `json:"propagator"`
+	Header       string `json:"header"`
+	TraceID      string `json:"trace_id,omitempty"`
+	SpanID       string `json:"span_id,omitempty"`
+	ParentSpanID string `json:"parent_span_id,omitempty"`
+	Sampled      *bool  `json:"sampled,omitempty"`
+	Debug        bool   `json:"debug,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+type tracingDetails struct {
+	Contexts      []traceContext `json:"contexts"`
+	TraceState    []string       `json:"tracestate,omitempty"`
+	Disagreements []string       `json:"disagreements,omitempty"`
+	ServerSpan    *serverSpan    `json:"server_span,omitempty"`
+}
+
+// serverSpan identifies the span reflector itself exported for a request.
+type serverSpan struct {
+	TraceID      string `json:"trace_id"`
+	SpanID       string `json:"span_id"`
+	ParentSpanID string `json:"parent_span_id,omitempty"`
+}
+
+// tracingFromRequest decodes every supported propagation header and lists
+// the fields on which they disagree. It returns nil when none are present.
+func tracingFromRequest(r *http.Request) *tracingDetails {
+	contexts := parseTraceHeaders(r.Header)
+	span, _ := r.Context().Value(spanContextKey{}).(*serverSpan)
+	if len(contexts) == 0 && span == nil {
+		return nil
+	}
+	return &tracingDetails{
+		Contexts:      contexts,
+		TraceState:    headerTokens(r.Header, "Tracestate"),
+		Disagreements: traceDisagreements(contexts),
+		ServerSpan:    span,
+	}
+}
+
+func parseTraceHeaders(h http.Header) []traceContext {
+	var out []traceContext
+	if v := h.Get("Traceparent"); v != "" {
+		out = append(out, parseTraceparent(v))
+	}
+	if v := h.Get("B3"); v != "" {
+		out = append(out, parseB3Single(v))
+	}
+	if h.Get("X-B3-Traceid") != "" || h.Get("X-B3-Sampled") != "" || h.Get("X-B3-Flags") != "" {
+		out = append(out, parseB3Multi(h))
+	}
+	if v := h.Get("Uber-Trace-Id"); v != "" {
+		out = append(out, parseJaeger(v))
+	}
+	if v := h.Get("X-Cloud-Trace-Context"); v != "" {
+		out = append(out, parseCloudTrace(v))
+	}
+	return out
+}
+
+// parseTraceparent handles the W3C Trace Context header:
+// version-traceid-parentid-flags.
+func parseTraceparent(v string) traceContext {
+	tc := traceContext{Propagator: propagatorW3C, Header: v}
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || !isHex(parts[0]) {
+		tc.Error = "malformed traceparent"
+		return tc
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		tc.Error = "version 00 traceparent must have four fields"
+		return tc
+	}
+	traceID, ok := normalizeTraceID(parts[1], 32)
+	if !ok {
+		tc.Error = "invalid trace-id"
+		return tc
+	}
+	spanID, ok := normalizeSpanID(parts[2])
+	if !ok {
+		tc.Error = "invalid parent-id"
+		return tc
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil || len(parts[3]) != 2 {
+		tc.Error = "invalid trace-flags"
+		return tc
+	}
+	tc.TraceID, tc.SpanID = traceID, spanID
+	tc.Sampled = boolPtr(flags&0x01 != 0)
+	return tc
+}
+
+// parseB3Single handles the single "b3" header:
+// traceid-spanid[-sampling[-parentspanid]] or a bare sampling decision.
+func parseB3Single(v string) traceContext {
+	tc := traceContext{Propagator: propagatorB3, Header: v}
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) == 1 {
+		if !applyB3Sampling(&tc, parts[0]) {
+			tc.Error = "invalid sampling state"
+		}
+		return tc
+	}
+	if len(parts) > 4 {
+		tc.Error = "too many fields"
+		return tc
+	}
+	traceID, ok := normalizeTraceID(parts[0], 16, 32)
+	if !ok {
+		tc.Error = "invalid trace id"
+		return tc
+	}
+	spanID, ok := normalizeSpanID(parts[1])
+	if !ok {
+		tc.Error = "invalid span id"
+		return tc
+	}
+	tc.TraceID, tc.SpanID = traceID, spanID
+	if len(parts) > 2 && !applyB3Sampling(&tc, parts[2]) {
+		tc.Error = "invalid sampling state"
+		return tc
+	}
+	if len(parts) > 3 {
+		parent, ok := normalizeSpanID(parts[3])
+		if !ok {
+			tc.Error = "invalid parent span id"
+			return tc
+		}
+		tc.ParentSpanID = parent
+	}
+	return tc
+}
+
+func parseB3Multi(h http.Header) traceContext {
+	fields := []string{"X-B3-TraceId", "X-B3-SpanId", "X-B3-ParentSpanId", "X-B3-Sampled", "X-B3-Flags"}
+	var raw []string
+	for _, name := range fields {
+		if v := h.Get(name); v != "" {
+			raw = append(raw, name+": "+v)
+		}
+	}
+	tc := traceContext{Propagator: propagatorB3Multi, Header: strings.Join(raw, "; ")}
+
+	if v := h.Get("X-B3-TraceId"); v != "" {
+		traceID, ok := normalizeTraceID(v, 16, 32)
+		if !ok {
+			tc.Error = "invalid X-B3-TraceId"
+			return tc
+		}
+		tc.TraceID = traceID
+	}
+	if v := h.Get("X-B3-SpanId"); v != "" {
+		spanID, ok := normalizeSpanID(v)
+		if !ok {
+			tc.Error = "invalid X-B3-SpanId"
+			return tc
+		}
+		tc.SpanID = spanID
+	}
+	if v := h.Get("X-B3-ParentSpanId"); v != "" {
+		parent, ok := normalizeSpanID(v)
+		if !ok {
+			tc.Error = "invalid X-B3-ParentSpanId"
+			return tc
+		}
+		tc.ParentSpanID = parent
+	}
+	switch strings.ToLower(h.Get("X-B3-Sampled")) {
+	case "":
+	case "1", "true":
+		tc.Sampled = boolPtr(true)
+	case "0", "false":
+		tc.Sampled = boolPtr(false)
+	default:
+		tc.Error = "invalid X-B3-Sampled"
+		return tc
+	}
+	if h.Get("X-B3-Flags") == "1" {
+		tc.Debug = true
+		tc.Sampled = boolPtr(true)
+	}
+	if (tc.TraceID == "") != (tc.SpanID == "") {
+		tc.Error = "X-B3-TraceId and X-B3-SpanId must be sent together"
+	}
+	return tc
+}
+
+func applyB3Sampling(tc *traceContext, v string) bool {
+	switch v {
+	case "1":
+		tc.Sampled = boolPtr(true)
+	case "0":
+		tc.Sampled = boolPtr(false)
+	case "d":
+		tc.Sampled = boolPtr(true)
+		tc.Debug = true
+	default:
+		return false
+	}
+	return true
+}
+
+// parseJaeger handles uber-trace-id: trace-id:span-id:parent-span-id:flags.
+func parseJaeger(v string) traceContext {
+	tc := traceContext{Propagator: propagatorJaeger, Header: v}
+	decoded, err := url.PathUnescape(v)
+	if err != nil {
+		decoded = v
+	}
+	parts := strings.Split(strings.TrimSpace(decoded), ":")
+	if len(parts) != 4 {
+		tc.Error = "expected trace-id:span-id:parent-span-id:flags"
+		return tc
+	}
+	traceID, ok := normalizeTraceID(parts[0], 0)
+	if !ok {
+		tc.Error = "invalid trace id"
+		return tc
+	}
+	spanID, ok := normalizeSpanID(leftPad(parts[1], 16))
+	if !ok {
+		tc.Error = "invalid span id"
+		return tc
+	}
+	tc.TraceID, tc.SpanID = traceID, spanID
+	if parts[2] != "0" && parts[2] != "" {
+		if parent, ok := normalizeSpanID(leftPad(parts[2], 16)); ok {
+			tc.ParentSpanID = parent
+		}
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		tc.Error = "invalid flags"
+		return tc
+	}
+	tc.Sampled = boolPtr(flags&0x01 != 0)
+	tc.Debug = flags&0x02 != 0
+	return tc
+}
+
+// parseCloudTrace handles X-Cloud-Trace-Context: TRACE_ID/SPAN_ID;o=OPTIONS
+// where the span ID is a decimal unsigned 64-bit integer.
+func parseCloudTrace(v string) traceContext {
+	tc := traceContext{Propagator: propagatorCloud, Header: v}
+	value, options, _ := strings.Cut(strings.TrimSpace(v), ";")
+	traceRaw, spanRaw, _ := strings.Cut(value, "/")
+	traceID, ok := normalizeTraceID(traceRaw, 32)
+	if !ok {
+		tc.Error = "invalid trace id"
+		return tc
+	}
+	tc.TraceID = traceID
+	if spanRaw != "" {
+		span, err := strconv.ParseUint(spanRaw, 10, 64)
+		if err != nil || span == 0 {
+			tc.Error = "invalid span id"
+			return tc
+		}
+		tc.SpanID = fmt.Sprintf("%016x", span)
+	}
+	if opt, ok := strings.CutPrefix(strings.TrimSpace(options), "o="); ok {
+		tc.Sampled = boolPtr(opt == "1")
+	}
+	return tc
+}
+
+// traceDisagreements compares every valid context against the others.
+func traceDisagreements(contexts []traceContext) []string {
+	var out []string
+	compare := func(field string, value func(traceContext) string) {
+		seen := map[string][]string{}
+		var order []string
+		for _, tc := range contexts {
+			v := value(tc)
+			if tc.Error != "" || v == "" {
+				continue
+			}
+			if _, ok := seen[v]; !ok {
+				order = append(order, v)
+			}
+			seen[v] = append(seen[v], tc.Propagator)
+		}
+		if len(order) < 2 {
+			return
+		}
+		parts := make([]string, 0, len(order))
+		for _, v := range order {
+			parts = append(parts, strings.Join(seen[v], "+")+"="+v)
+		}
+		out = append(out, field+": "+strings.Join(parts, ", "))
+	}
+	compare("trace_id", func(tc traceContext) string { return tc.TraceID })
+	compare("span_id", func(tc traceContext) string { return tc.SpanID })
+	compare("sampled", func(tc traceContext) string {
+		if tc.Sampled == nil {
+			return ""
+		}
+		return strconv.FormatBool(*tc.Sampled)
+	})
+	return out
+}
+
+// traceRequests creates a server span for each request and hands it to the
+// exporter once the response is written. It is only installed when an OTLP
+// endpoint is configured.
+func (s *Server) traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		span := &serverSpan{SpanID: randomHex(8)}
+		sampled := true
+		for _, tc := range parseTraceHeaders(r.Header) {
+			if tc.Error != "" || tc.TraceID == "" {
+				continue
+			}
+			span.TraceID, span.ParentSpanID = tc.TraceID, tc.SpanID
+			if tc.Sampled != nil {
+				sampled = *tc.Sampled
+			}
+			break
+		}
+		if span.TraceID == "" {
+			span.TraceID = randomHex(16)
+		}
+
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), spanContextKey{}, span)))
+
+		if !sampled {
+			return
+		}
+		s.spans.export(otlpSpan{
+			span:      *span,
+			name:      r.Method + " " + pathClass(r.URL.Path),
+			start:     start,
+			end:       time.Now(),
+			status:    rec.statusCode(),
+			method:    r.Method,
+			path:      r.URL.Path,
+			pathClass: pathClass(r.URL.Path),
+			proto:     r.Proto,
+			host:      r.Host,
+			clientIP:  clientIP(r),
+			userAgent: r.UserAgent(),
+			requestID: requestIDFromRequest(r),
+		})
+	})
+}
+
+func normalizeTraceID(v string, lengths ...int) (string, bool) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if !isHex(v) || len(v) > 32 || len(v) == 0 {
+		return "", false
+	}
+	if lengths[0] != 0 {
+		valid := false
+		for _, n := range lengths {
+			valid = valid || len(v) == n
+		}
+		if !valid {
+			return "", false
+		}
+	}
+	v = leftPad(v, 32)
+	if strings.Trim(v, "0") == "" {
+		return "", false
+	}
+	return v, true
+}
+
+func normalizeSpanID(v string) (string, bool) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if len(v) != 16 || !isHex(v) || strings.Trim(v, "0") == "" {
+		return "", false
+	}
+	return v, true
+}
+
+func leftPad(v string, n int) string {
+	if len(v) >= n {
+		return v
+	}
+	return strings.Repeat("0", n-len(v)) + v
+}
+
+func isHex(v string) bool {
+	if v == "" {
+		return false
+	}
+	_, err := hex.DecodeString(leftPad(v, len(v)+len(v)%2))
+	return err == nil
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -1,12 +1,12 @@
 package server
 
 import (
-	"html/template"
 	"time"
 )
 
 // reflection contains all information we can discover about the incoming request.
 type reflection struct {
+	RequestID        string              `json:"request_id,omitempty"`
 	Timestamp        time.Time           `json:"timestamp"`
 	Method           string              `json:"method"`
 	Proto            string              `json:"proto"`
@@ -16,13 +16,23 @@ type reflection struct {
 	RemoteAddr       string              `json:"remote_addr"`
 	RemoteIP         string              `json:"remote_ip"`
 	RemotePort       string              `json:"remote_port"`
+	UserAgent        *userAgentDetails   `json:"user_agent,omitempty"`
+	Addresses        []addressDetails    `json:"addresses,omitempty"`
 	TLS              *tlsDetails         `json:"tls,omitempty"`
+	Connection       *connDetails        `json:"connection,omitempty"`
+	Timing           *timingDetails      `json:"timing,omitempty"`
+	Tracing          *tracingDetails     `json:"tracing,omitempty"`
 	Headers          map[string][]string `json:"headers"`
 	Query            map[string][]string `json:"query"`
 	Cookies          []cookieDetails     `json:"cookies,omitempty"`
+	CookieBytes      int                 `json:"cookie_header_bytes,omitempty"`
 	ContentLength    int64               `json:"content_length"`
 	TransferEncoding []string            `json:"transfer_encoding,omitempty"`
 	BodyPreview      string              `json:"body_preview,omitempty"`
+	BodyTruncated    bool                `json:"body_truncated,omitempty"`
+	ClientHints      []clientHint        `json:"client_hints,omitempty"`
+	Upstream         *upstreamExchange   `json:"upstream,omitempty"`
+	Redactions       []string            `json:"redactions,omitempty"`
 	ClientData       map[string]any      `json:"client_data,omitempty"`
 }
 
@@ -34,8 +44,11 @@ type tlsDetails struct {
 }
 
 type cookieDetails struct {
-	Name  string `json:"name"`
-	Value string `json:"value"`
+	Name      string   `json:"name"`
+	Value     string   `json:"value"`
+	Size      int      `json:"size"`
+	Duplicate bool     `json:"duplicate,omitempty"`
+	Problems  []string `json:"problems,omitempty"`
 }
 
 type keyValues struct {
@@ -51,5 +64,15 @@ type pageData struct {
 	HasClientData bool
 	StatusMessage string
 	StatusVariant string
-	ClientScript  template.JS
+	// CaptureID is set when the reflection was kept in history.
+	CaptureID string
+	Sharing   bool
+	// ExportPath is the export URL without the format extension.
+	ExportPath    string
+	ReplayTargets []string
+	// ReplayNotes lists why a replay would differ from the capture; the
+	// form asks for confirmation when there are any.
+	ReplayNotes []string
+	// Share is set when rendering a shared, read-only snapshot.
+	Share *share
 }
@@ -0,0 +1,156 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// useragentsJSON is the ordered rule set used to classify User-Agent
+// strings. Within each section the first matching rule wins, so specific
+// patterns must come before generic ones.
+//
+//go:embed useragents.json
+var useragentsJSON []byte
+
+type uaRule struct {
+	Regex   string `json:"regex"`
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	// Domains are the reverse DNS suffixes a bot's operator publishes for
+	// verifying its crawlers.
+	Domains []string `json:"domains,omitempty"`
+
+	re *regexp.Regexp
+}
+
+type uaRuleSet struct {
+	Bots     []uaRule `json:"bots"`
+	Clients  []uaRule `json:"clients"`
+	Browsers []uaRule `json:"browsers"`
+	OS       []uaRule `json:"os"`
+	Devices  []uaRule `json:"devices"`
+	Models   []uaRule `json:"models"`
+}
+
+var uaRules = mustLoadUARules(useragentsJSON)
+
+func mustLoadUARules(data []byte) *uaRuleSet {
+	var rules uaRuleSet
+	if err := json.Unmarshal(data, &rules); err != nil {
+		panic(fmt.Sprintf("parse embedded user agent rules: %v", err))
+	}
+	for _, section := range [][]uaRule{rules.Bots, rules.Clients, rules.Browsers, rules.OS, rules.Devices, rules.Models} {
+		for i := range section {
+			section[i].re = regexp.MustCompile(section[i].Regex)
+		}
+	}
+	return &rules
+}
+
+// matchUARules returns the expanded name and version of the first matching rule.
+// Without an explicit version template the first capture group is used.
+func matchUARules(rules []uaRule, ua string) (name, version string, rule *uaRule) {
+	for i := range rules {
+		rule = &rules[i]
+		m := rule.re.FindStringSubmatchIndex(ua)
+		if m == nil {
+			continue
+		}
+		name = string(rule.re.ExpandString(nil, rule.Name, ua, m))
+		tmpl := rule.Version
+		if tmpl == "" && rule.re.NumSubexp() > 0 {
+			tmpl = "$1"
+		}
+		version = strings.ReplaceAll(string(rule.re.ExpandString(nil, tmpl, ua, m)), "_", ".")
+		return name, version, rule
+	}
+	return "", "", nil
+}
+
+// userAgentDetails is the parsed form of the User-Agent header.
+type userAgentDetails struct {
+	Raw            string `json:"raw"`
+	Kind           string `json:"kind"`
+	Browser        string `json:"browser,omitempty"`
+	BrowserVersion string `json:"browser_version,omitempty"`
+	OS             string `json:"os,omitempty"`
+	OSVersion      string `json:"os_version,omitempty"`
+	Device         string `json:"device"`
+	Model          string `json:"model,omitempty"`
+	Bot            string `json:"bot,omitempty"`
+	// BotVerified reports whether the client's forward-confirmed hostname
+	// belongs to the claimed crawler, for bots that support verification.
+	BotVerified *bool `json:"bot_verified,omitempty"`
+
+	// Script holds the navigator.userAgent the page script reported, when it
+	// differs from the header, and Differences what that changes.
+	Script      string   `json:"script_user_agent,omitempty"`
+	ScriptMatch *bool    `json:"script_matches,omitempty"`
+	Differences []string `json:"differences,omitempty"`
+
+	botDomains []string
+}
+
+// User agent kinds.
+const (
+	uaKindBrowser = "browser"
+	uaKindClient  = "client"
+	uaKindBot     = "bot"
+	uaKindUnknown = "unknown"
+)
+
+func parseUserAgent(ua string) *userAgentDetails {
+	if ua == "" {
+		return nil
+	}
+	d := &userAgentDetails{Raw: ua, Kind: uaKindUnknown, Device: "unknown"}
+	if name, version, rule := matchUARules(uaRules.Bots, ua); rule != nil {
+		d.Kind, d.Bot, d.Browser, d.BrowserVersion = uaKindBot, name, name, version
+		d.botDomains = rule.Domains
+	} else if name, version, rule := matchUARules(uaRules.Clients, ua); rule != nil {
+		d.Kind, d.Browser, d.BrowserVersion = uaKindClient, name, version
+	} else if name, version, rule := matchUARules(uaRules.Browsers, ua); rule != nil {
+		d.Kind, d.Browser, d.BrowserVersion = uaKindBrowser, name, version
+	}
+	d.OS, d.OSVersion, _ = matchUARules(uaRules.OS, ua)
+	if device, _, rule := matchUARules(uaRules.Devices, ua); rule != nil {
+		d.Device = device
+	}
+	if d.Kind == uaKindBot {
+		d.Device = uaKindBot
+	}
+	d.Model, _, _ = matchUARules(uaRules.Models, ua)
+	return d
+}
+
+// userAgentFromRequest parses the User-Agent header and compares it with the
+// navigator.userAgent reported by the browser collector, if any.
+func userAgentFromRequest(header string, clientData map[string]any) *userAgentDetails {
+	d := parseUserAgent(header)
+	script, _ := clientData["userAgent"].(string)
+	if d == nil || script == "" {
+		return d
+	}
+	same := script == header
+	d.ScriptMatch = &same
+	if same {
+		return d
+	}
+	d.Script = script
+	other := parseUserAgent(script)
+	for _, f := range []struct{ label, a, b string }{
+		{"browser", d.Browser, other.Browser},
+		{"browser version", d.BrowserVersion, other.BrowserVersion},
+		{"OS", d.OS, other.OS},
+		{"OS version", d.OSVersion, other.OSVersion},
+		{"device", d.Device, other.Device},
+	} {
+		if f.a != f.b {
+			d.Differences = append(d.Differences, fmt.Sprintf("%s: header %q, script %q", f.label, f.a, f.b))
+		}
+	}
+	return d
+}
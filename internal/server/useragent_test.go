@@ -0,0 +1,175 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		name string
+		ua   string
+		want userAgentDetails
+	}{
+		{
+			name: "Chrome on Windows",
+			ua:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			want: userAgentDetails{Kind: uaKindBrowser, Browser: "Chrome", BrowserVersion: "124.0.0.0", OS: "Windows", OSVersion: "10/11", Device: "desktop"},
+		},
+		{
+			name: "Edge on Windows 7",
+			ua:   "Mozilla/5.0 (Windows NT 6.1; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/109.0.0.0 Safari/537.36 Edg/109.0.1518.78",
+			want: userAgentDetails{Kind: uaKindBrowser, Browser: "Edge", BrowserVersion: "109.0.1518.78", OS: "Windows", OSVersion: "7", Device: "desktop"},
+		},
+		{
+			name: "Firefox on Linux",
+			ua:   "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+			want: userAgentDetails{Kind: uaKindBrowser, Browser: "Firefox", BrowserVersion: "125.0", OS: "Ubuntu", Device: "desktop"},
+		},
+		{
+			name: "Safari on macOS",
+			ua:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Safari/605.1.15",
+			want: userAgentDetails{Kind: uaKindBrowser, Browser: "Safari", BrowserVersion: "17.4.1", OS: "macOS", OSVersion: "10.15.7", Device: "desktop"},
+		},
+		{
+			name: "Opera on ChromeOS",
+			ua:   "Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 OPR/109.0.0.0",
+			want: userAgentDetails{Kind: uaKindBrowser, Browser: "Opera", BrowserVersion: "109.0.0.0", OS: "ChromeOS", OSVersion: "14541.0.0", Device: "desktop"},
+		},
+		{
+			name: "Mobile Safari on iPhone",
+			ua:   "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Mobile/15E148 Safari/604.1",
+			want: userAgentDetails{Kind: uaKindBrowser, Browser: "Mobile Safari", BrowserVersion: "17.4.1", OS: "iOS", OSVersion: "17.4.1", Device: "mobile", Model: "iPhone"},
+		},
+		{
+			name: "Chrome on iPad",
+			ua:   "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/124.0.6367.88 Mobile/15E148 Safari/604.1",
+			want: userAgentDetails{Kind: uaKindBrowser, Browser: "Chrome", BrowserVersion: "124.0.6367.88", OS: "iPadOS", OSVersion: "16.6", Device: "tablet", Model: "iPad"},
+		},
+		{
+			name: "Samsung Internet on Android",
+			ua:   "Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/24.0 Chrome/117.0.0.0 Mobile Safari/537.36",
+			want: userAgentDetails{Kind: uaKindBrowser, Browser: "Samsung Internet", BrowserVersion: "24.0", OS: "Android", OSVersion: "14", Device: "mobile", Model: "SM-S918B"},
+		},
+		{
+			name: "Android WebView with build and locale",
+			ua:   "Mozilla/5.0 (Linux; U; Android 10; en-us; Pixel 4 Build/QQ3A.200805.001; wv) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/120.0.6099.230 Mobile Safari/537.36",
+			want: userAgentDetails{Kind: uaKindBrowser, Browser: "Android WebView", BrowserVersion: "120.0.6099.230", OS: "Android", OSVersion: "10", Device: "mobile", Model: "Pixel 4"},
+		},
+		{
+			name: "Android tablet",
+			ua:   "Mozilla/5.0 (Linux; Android 13; SM-X710) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			want: userAgentDetails{Kind: uaKindBrowser, Browser: "Chrome", BrowserVersion: "124.0.0.0", OS: "Android", OSVersion: "13", Device: "tablet", Model: "SM-X710"},
+		},
+		{
+			name: "Headless Chrome",
+			ua:   "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/124.0.6367.60 Safari/537.36",
+			want: userAgentDetails{Kind: uaKindBrowser, Browser: "Headless Chrome", BrowserVersion: "124.0.6367.60", OS: "Linux", Device: "desktop"},
+		},
+		{
+			name: "smart TV",
+			ua:   "Mozilla/5.0 (Web0S; Linux/SmartTV) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/87.0.4280.88 Safari/537.36 WebAppManager",
+			want: userAgentDetails{Kind: uaKindBrowser, Browser: "Chrome", BrowserVersion: "87.0.4280.88", OS: "webOS", Device: "tv"},
+		},
+		{
+			name: "Googlebot smartphone",
+			ua:   "Mozilla/5.0 (Linux; Android 6.0.1; Nexus 5X Build/MMB29P) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.6367.118 Mobile Safari/537.36 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			want: userAgentDetails{Kind: uaKindBot, Bot: "Googlebot", Browser: "Googlebot", BrowserVersion: "2.1", OS: "Android", OSVersion: "6.0.1", Device: uaKindBot, Model: "Nexus 5X"},
+		},
+		{
+			name: "Bingbot",
+			ua:   "Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)",
+			want: userAgentDetails{Kind: uaKindBot, Bot: "Bingbot", Browser: "Bingbot", BrowserVersion: "2.0", Device: uaKindBot},
+		},
+		{
+			name: "unlisted crawler",
+			ua:   "ExampleCrawler/1.0 (+https://example.com/crawler; crawler)",
+			want: userAgentDetails{Kind: uaKindBot, Bot: "Unidentified bot", Browser: "Unidentified bot", Device: uaKindBot},
+		},
+		{
+			name: "curl",
+			ua:   "curl/8.5.0",
+			want: userAgentDetails{Kind: uaKindClient, Browser: "curl", BrowserVersion: "8.5.0", Device: "unknown"},
+		},
+		{
+			name: "aiohttp version from the second group",
+			ua:   "Python/3.12 aiohttp/3.9.5",
+			want: userAgentDetails{Kind: uaKindClient, Browser: "aiohttp", BrowserVersion: "3.9.5", Device: "unknown"},
+		},
+		{
+			name: "Java underscores",
+			ua:   "Java/1.8.0_392",
+			want: userAgentDetails{Kind: uaKindClient, Browser: "Java", BrowserVersion: "1.8.0.392", Device: "unknown"},
+		},
+		{
+			name: "unknown",
+			ua:   "SomethingElse",
+			want: userAgentDetails{Kind: uaKindUnknown, Device: "unknown"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseUserAgent(tt.ua)
+			tt.want.Raw = tt.ua
+			got.botDomains = nil
+			if got.Raw != tt.want.Raw || got.Kind != tt.want.Kind || got.Browser != tt.want.Browser ||
+				got.BrowserVersion != tt.want.BrowserVersion || got.OS != tt.want.OS || got.OSVersion != tt.want.OSVersion ||
+				got.Device != tt.want.Device || got.Model != tt.want.Model || got.Bot != tt.want.Bot {
+				t.Errorf("\n got %+v\nwant %+v", *got, tt.want)
+			}
+		})
+	}
+	if parseUserAgent("") != nil {
+		t.Error("empty User-Agent parsed")
+	}
+}
+
+func TestUserAgentBotDomains(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want []string
+	}{
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", []string{"googlebot.com", "google.com", "googleusercontent.com"}},
+		{"Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)", []string{"search.msn.com"}},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_5) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.1.1 Safari/605.1.15 (Applebot/0.1; +http://www.apple.com/go/applebot)", []string{"applebot.apple.com"}},
+		// Bots without a published verification method have no domains.
+		{"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; GPTBot/1.2; +https://openai.com/gptbot)", nil},
+		{"curl/8.5.0", nil},
+	}
+	for _, tt := range tests {
+		got := parseUserAgent(tt.ua)
+		if strings.Join(got.botDomains, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: domains %q, want %q", tt.ua, got.botDomains, tt.want)
+		}
+	}
+}
+
+func TestUserAgentScriptComparison(t *testing.T) {
+	header := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+	spoofed := "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+
+	if d := userAgentFromRequest(header, nil); d == nil || d.ScriptMatch != nil {
+		t.Errorf("without script data: %+v", d)
+	}
+	d := userAgentFromRequest(header, map[string]any{"userAgent": header})
+	if d.ScriptMatch == nil || !*d.ScriptMatch || d.Script != "" || d.Differences != nil {
+		t.Errorf("same user agent: %+v", d)
+	}
+	d = userAgentFromRequest(header, map[string]any{"userAgent": spoofed})
+	if d.ScriptMatch == nil || *d.ScriptMatch || d.Script != spoofed {
+		t.Fatalf("different user agent: %+v", d)
+	}
+	want := []string{`OS: header "Windows", script "macOS"`, `OS version: header "10/11", script "10.15.7"`}
+	if strings.Join(d.Differences, "\n") != strings.Join(want, "\n") {
+		t.Errorf("differences %q, want %q", d.Differences, want)
+	}
+}
+
+func TestMustLoadUARules(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("invalid rules did not panic")
+		}
+	}()
+	mustLoadUARules([]byte(`{"bots": [{"regex": "(", "name": "broken"}]}`))
+}
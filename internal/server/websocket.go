@@ -0,0 +1,495 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// websocketGUID is the fixed value from RFC 6455 used to derive Sec-WebSocket-Accept.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsMaxMessageBytes = 1 << 20
+	wsIdleTimeout     = 2 * time.Minute
+)
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+const (
+	wsCloseNormal        = 1000
+	wsCloseProtocolError = 1002
+	wsCloseInvalidData   = 1007
+	wsCloseTooBig        = 1009
+)
+
+// wsHandshake is sent as the first message on every socket so the client can
+// see how the upgrade request looked once it reached reflector.
+type wsHandshake struct {
+	Type              string    `json:"type"`
+	RequestID         string    `json:"request_id,omitempty"`
+	Timestamp         time.Time `json:"timestamp"`
+	RemoteAddr        string    `json:"remote_addr"`
+	RemoteIP          string    `json:"remote_ip"`
+	Host              string    `json:"host"`
+	RequestURI        string    `json:"request_uri"`
+	Origin            string    `json:"origin,omitempty"`
+	Key               string    `json:"key"`
+	Accept            string    `json:"accept"`
+	Version           string    `json:"version"`
+	OfferedProtocols  []string  `json:"offered_protocols,omitempty"`
+	Protocol          string    `json:"protocol,omitempty"`
+	OfferedExtensions []string  `json:"offered_extensions,omitempty"`
+	Extensions        string    `json:"extensions,omitempty"`
+	Compression       bool      `json:"permessage_deflate"`
+}
+
+// wsFrameReport follows every echoed message and describes how it arrived.
+type wsFrameReport struct {
+	Type         string    `json:"type"`
+	Sequence     int       `json:"sequence"`
+	ReceivedAt   time.Time `json:"received_at"`
+	Opcode       string    `json:"opcode"`
+	Fragments    int       `json:"fragments"`
+	Compressed   bool      `json:"compressed"`
+	WireBytes    int       `json:"wire_bytes"`
+	PayloadBytes int       `json:"payload_bytes"`
+}
+
+type wsFrame struct {
+	fin     bool
+	rsv1    bool
+	opcode  byte
+	masked  bool
+	payload []byte
+	wire    int
+}
+
+type wsConn struct {
+	conn    net.Conn
+	br      *bufio.Reader
+	deflate bool
+}
+
+// wsCloseError carries the close code reflector should send before hanging up.
+type wsCloseError struct {
+	code   int
+	reason string
+}
+
+func (e *wsCloseError) Error() string {
+	return fmt.Sprintf("websocket close %d: %s", e.code, e.reason)
+}
+
+func (s *Server) websocketHandler(w http.ResponseWriter, r *http.Request) {
+	if !isWebSocketUpgrade(r) {
+		s.renderWebSocketPage(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	version := r.Header.Get("Sec-WebSocket-Version")
+	if version != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+	key := strings.TrimSpace(r.Header.Get("Sec-WebSocket-Key"))
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket upgrade unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	protocols := headerTokens(r.Header, "Sec-WebSocket-Protocol")
+	offered := headerTokens(r.Header, "Sec-WebSocket-Extensions")
+	extensions, deflate := negotiateDeflate(offered)
+
+	handshake := wsHandshake{
+		Type:              "handshake",
+		RequestID:         requestIDFromRequest(r),
+		Timestamp:         time.Now().UTC(),
+		RemoteAddr:        r.RemoteAddr,
+		RemoteIP:          clientIP(r),
+		Host:              r.Host,
+		RequestURI:        s.redaction.redactURI(r.RequestURI),
+		Origin:            r.Header.Get("Origin"),
+		Key:               key,
+		Accept:            websocketAccept(key),
+		Version:           version,
+		OfferedProtocols:  protocols,
+		OfferedExtensions: offered,
+		Extensions:        extensions,
+		Compression:       deflate,
+	}
+	if len(protocols) > 0 {
+		handshake.Protocol = protocols[0]
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		s.logger.Error("hijack websocket", "err", err)
+		return
+	}
+	defer conn.Close()
+
+	var resp strings.Builder
+	resp.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	resp.WriteString("Upgrade: websocket\r\n")
+	resp.WriteString("Connection: Upgrade\r\n")
+	resp.WriteString("Sec-WebSocket-Accept: " + handshake.Accept + "\r\n")
+	if handshake.RequestID != "" {
+		resp.WriteString(s.requestIDHeader + ": " + handshake.RequestID + "\r\n")
+	}
+	if handshake.Protocol != "" {
+		resp.WriteString("Sec-WebSocket-Protocol: " + handshake.Protocol + "\r\n")
+	}
+	if extensions != "" {
+		resp.WriteString("Sec-WebSocket-Extensions: " + extensions + "\r\n")
+	}
+	resp.WriteString("\r\n")
+	if _, err := io.WriteString(conn, resp.String()); err != nil {
+		s.logger.Warn("write websocket handshake", "err", err)
+		return
+	}
+
+	ws := &wsConn{conn: conn, br: rw.Reader, deflate: deflate}
+	if err := ws.writeJSON(handshake); err != nil {
+		s.logger.Warn("write websocket handshake report", "err", err)
+		return
+	}
+	if err := ws.echo(); err != nil {
+		var closeErr *wsCloseError
+		if errors.As(err, &closeErr) {
+			_ = ws.writeClose(closeErr.code, closeErr.reason)
+			return
+		}
+		if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+			s.logger.Warn("websocket", "err", err)
+		}
+	}
+}
+
+func (s *Server) renderWebSocketPage(w http.ResponseWriter, r *http.Request) {
+	s.renderPage(w, r, http.StatusOK, "websocket", nil)
+}
+
+// echo reads whole messages, sends each one back with the same opcode and
+// then reports the frame metadata as a JSON text message.
+func (c *wsConn) echo() error {
+	sequence := 0
+	for {
+		var (
+			message    []byte
+			opcode     byte
+			compressed bool
+			fragments  int
+			wire       int
+		)
+		for {
+			_ = c.conn.SetReadDeadline(time.Now().Add(wsIdleTimeout))
+			frame, err := c.readFrame()
+			if err != nil {
+				return err
+			}
+			switch frame.opcode {
+			case wsOpPing:
+				if err := c.writeFrame(wsOpPong, frame.payload, false); err != nil {
+					return err
+				}
+				continue
+			case wsOpPong:
+				continue
+			case wsOpClose:
+				code := wsCloseNormal
+				switch {
+				case len(frame.payload) == 1:
+					code = wsCloseProtocolError
+				case len(frame.payload) >= 2:
+					code = int(binary.BigEndian.Uint16(frame.payload))
+					if !wsCloseCodeSendable(code) {
+						code = wsCloseProtocolError
+					}
+				}
+				return &wsCloseError{code: code}
+			case wsOpText, wsOpBinary:
+				if fragments > 0 {
+					return &wsCloseError{code: wsCloseProtocolError, reason: "expected continuation frame"}
+				}
+				opcode = frame.opcode
+				compressed = frame.rsv1
+			case wsOpContinuation:
+				if fragments == 0 {
+					return &wsCloseError{code: wsCloseProtocolError, reason: "unexpected continuation frame"}
+				}
+			default:
+				return &wsCloseError{code: wsCloseProtocolError, reason: "unknown opcode"}
+			}
+			if frame.rsv1 && (!c.deflate || frame.opcode == wsOpContinuation) {
+				return &wsCloseError{code: wsCloseProtocolError, reason: "unexpected RSV1 bit"}
+			}
+			fragments++
+			wire += frame.wire
+			if len(message)+len(frame.payload) > wsMaxMessageBytes {
+				return &wsCloseError{code: wsCloseTooBig, reason: "message too big"}
+			}
+			message = append(message, frame.payload...)
+			if frame.fin {
+				break
+			}
+		}
+
+		receivedAt := time.Now().UTC()
+		if compressed {
+			inflated, err := inflateMessage(message)
+			if err != nil {
+				return &wsCloseError{code: wsCloseInvalidData, reason: "invalid compressed payload"}
+			}
+			message = inflated
+		}
+		if opcode == wsOpText && !utf8.Valid(message) {
+			return &wsCloseError{code: wsCloseInvalidData, reason: "invalid UTF-8"}
+		}
+
+		sequence++
+		if err := c.writeFrame(opcode, message, c.deflate); err != nil {
+			return err
+		}
+		report := wsFrameReport{
+			Type:         "frame",
+			Sequence:     sequence,
+			ReceivedAt:   receivedAt,
+			Opcode:       wsOpcodeName(opcode),
+			Fragments:    fragments,
+			Compressed:   compressed,
+			WireBytes:    wire,
+			PayloadBytes: len(message),
+		}
+		if err := c.writeJSON(report); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (wsFrame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return wsFrame{}, err
+	}
+	frame := wsFrame{
+		fin:    header[0]&0x80 != 0,
+		rsv1:   header[0]&0x40 != 0,
+		opcode: header[0] & 0x0f,
+		masked: header[1]&0x80 != 0,
+		wire:   2,
+	}
+	if header[0]&0x30 != 0 {
+		return frame, &wsCloseError{code: wsCloseProtocolError, reason: "unexpected RSV bits"}
+	}
+	if !frame.masked {
+		return frame, &wsCloseError{code: wsCloseProtocolError, reason: "client frames must be masked"}
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return frame, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+		frame.wire += 2
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return frame, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		frame.wire += 8
+	}
+	if frame.opcode >= wsOpClose && (length > 125 || !frame.fin) {
+		return frame, &wsCloseError{code: wsCloseProtocolError, reason: "invalid control frame"}
+	}
+	if length > wsMaxMessageBytes {
+		return frame, &wsCloseError{code: wsCloseTooBig, reason: "frame too big"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return frame, err
+	}
+	frame.wire += 4
+
+	frame.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, frame.payload); err != nil {
+		return frame, err
+	}
+	for i := range frame.payload {
+		frame.payload[i] ^= mask[i%4]
+	}
+	frame.wire += int(length)
+	return frame, nil
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte, compress bool) error {
+	first := 0x80 | opcode
+	if compress && opcode < wsOpClose {
+		deflated, err := deflateMessage(payload)
+		if err != nil {
+			return err
+		}
+		payload = deflated
+		first |= 0x40
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(first)
+	switch {
+	case len(payload) < 126:
+		buf.WriteByte(byte(len(payload)))
+	case len(payload) <= 0xffff:
+		buf.WriteByte(126)
+		_ = binary.Write(&buf, binary.BigEndian, uint16(len(payload)))
+	default:
+		buf.WriteByte(127)
+		_ = binary.Write(&buf, binary.BigEndian, uint64(len(payload)))
+	}
+	buf.Write(payload)
+
+	_ = c.conn.SetWriteDeadline(time.Now().Add(wsIdleTimeout))
+	_, err := c.conn.Write(buf.Bytes())
+	return err
+}
+
+func (c *wsConn) writeJSON(v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsOpText, payload, c.deflate)
+}
+
+func (c *wsConn) writeClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	return c.writeFrame(wsOpClose, payload, false)
+}
+
+// wsCloseCodeSendable reports whether code may appear in a close frame.
+// 1005, 1006 and 1015 are reserved for reporting locally and, like the other
+// unassigned codes below 3000, must never go on the wire.
+func wsCloseCodeSendable(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	default:
+		return code >= 3000 && code <= 4999
+	}
+}
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// negotiateDeflate accepts the first usable permessage-deflate offer. Both
+// sides are asked to reset their compression context for every message so
+// reflector never has to keep a sliding window around between frames.
+func negotiateDeflate(offers []string) (string, bool) {
+	for _, offer := range offers {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		usable := true
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+			switch strings.TrimSpace(name) {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits":
+				// compress/flate always uses a 32KiB window.
+				usable = usable && value == "15"
+			default:
+				usable = false
+			}
+		}
+		if usable {
+			return "permessage-deflate; server_no_context_takeover; client_no_context_takeover", true
+		}
+	}
+	return "", false
+}
+
+func deflateMessage(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := fw.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{0x00, 0x00, 0xff, 0xff}), nil
+}
+
+func inflateMessage(payload []byte) ([]byte, error) {
+	src := io.MultiReader(bytes.NewReader(payload), bytes.NewReader([]byte{0x00, 0x00, 0xff, 0xff}))
+	fr := flate.NewReader(src)
+	defer fr.Close()
+	out, err := io.ReadAll(io.LimitReader(fr, wsMaxMessageBytes+1))
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	if len(out) > wsMaxMessageBytes {
+		return nil, errors.New("inflated message too big")
+	}
+	return out, nil
+}
+
+func wsOpcodeName(op byte) string {
+	switch op {
+	case wsOpText:
+		return "text"
+	case wsOpBinary:
+		return "binary"
+	default:
+		return fmt.Sprintf("0x%x", op)
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// clientFrame encodes a masked frame the way a browser would send it.
+func clientFrame(first byte, payload []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(first)
+	switch {
+	case len(payload) < 126:
+		buf.WriteByte(0x80 | byte(len(payload)))
+	case len(payload) <= 0xffff:
+		buf.WriteByte(0x80 | 126)
+		_ = binary.Write(&buf, binary.BigEndian, uint16(len(payload)))
+	default:
+		buf.WriteByte(0x80 | 127)
+		_ = binary.Write(&buf, binary.BigEndian, uint64(len(payload)))
+	}
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	buf.Write(mask[:])
+	for i, b := range payload {
+		buf.WriteByte(b ^ mask[i%4])
+	}
+	return buf.Bytes()
+}
+
+func readTestFrame(data []byte) (wsFrame, error) {
+	c := &wsConn{br: bufio.NewReader(bytes.NewReader(data))}
+	return c.readFrame()
+}
+
+func TestReadFrame(t *testing.T) {
+	long := bytes.Repeat([]byte("x"), 300)
+	frame, err := readTestFrame(clientFrame(0x80|wsOpText, []byte("hello")))
+	if err != nil {
+		t.Fatalf("readFrame: %v", err)
+	}
+	if !frame.fin || frame.opcode != wsOpText || string(frame.payload) != "hello" || frame.wire != 11 {
+		t.Errorf("got %+v", frame)
+	}
+	frame, err = readTestFrame(clientFrame(0x80|wsOpBinary, long))
+	if err != nil {
+		t.Fatalf("readFrame 16-bit length: %v", err)
+	}
+	if !bytes.Equal(frame.payload, long) || frame.wire != 2+2+4+300 {
+		t.Errorf("16-bit length frame: wire %d, %d payload bytes", frame.wire, len(frame.payload))
+	}
+}
+
+func TestReadFrameMalformed(t *testing.T) {
+	valid := clientFrame(0x80|wsOpText, []byte("hello"))
+	unmasked := append([]byte(nil), valid...)
+	unmasked[1] &^= 0x80
+	hugeLength := []byte{0x80 | wsOpBinary, 0x80 | 127, 0, 0, 0, 0, 0x10, 0, 0, 0}
+
+	tests := []struct {
+		name string
+		data []byte
+		code int // 0 means a plain read error is expected
+	}{
+		{"empty", nil, 0},
+		{"one header byte", valid[:1], 0},
+		{"truncated mask", valid[:4], 0},
+		{"truncated payload", valid[:len(valid)-1], 0},
+		{"truncated 16-bit length", []byte{0x80 | wsOpText, 0x80 | 126, 0x01}, 0},
+		{"truncated 64-bit length", []byte{0x80 | wsOpText, 0x80 | 127, 0, 0, 0}, 0},
+		{"unmasked", unmasked, wsCloseProtocolError},
+		{"rsv2 set", clientFrame(0x80|0x20|wsOpText, nil), wsCloseProtocolError},
+		{"rsv3 set", clientFrame(0x80|0x10|wsOpText, nil), wsCloseProtocolError},
+		{"fragmented ping", clientFrame(wsOpPing, nil), wsCloseProtocolError},
+		{"oversized close", clientFrame(0x80|wsOpClose, make([]byte, 126)), wsCloseProtocolError},
+		{"frame too big", hugeLength, wsCloseTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readTestFrame(tt.data)
+			if err == nil {
+				t.Fatal("readFrame accepted malformed input")
+			}
+			var closeErr *wsCloseError
+			if tt.code == 0 {
+				if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Errorf("got %v, want EOF", err)
+				}
+				return
+			}
+			if !errors.As(err, &closeErr) || closeErr.code != tt.code {
+				t.Errorf("got %v, want close code %d", err, tt.code)
+			}
+		})
+	}
+}
+
+// echoClose runs echo over a stream of client frames and returns the close
+// code it would answer with.
+func echoClose(t *testing.T, frames ...[]byte) int {
+	t.Helper()
+	client, server := netPipe(t)
+	c := &wsConn{conn: server, br: bufio.NewReader(bytes.NewReader(bytes.Join(frames, nil)))}
+	go func() { _, _ = io.Copy(io.Discard, client) }()
+	err := c.echo()
+	var closeErr *wsCloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("echo returned %v, want a close", err)
+	}
+	return closeErr.code
+}
+
+func netPipe(t *testing.T) (net.Conn, net.Conn) {
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func closePayload(code int) []byte {
+	return binary.BigEndian.AppendUint16(nil, uint16(code))
+}
+
+func TestEchoClose(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    int
+	}{
+		{"no code", nil, wsCloseNormal},
+		{"normal", closePayload(1000), 1000},
+		{"going away", closePayload(1001), 1001},
+		{"application code", closePayload(4000), 4000},
+		{"one byte payload", []byte{0x03}, wsCloseProtocolError},
+		{"reserved 1005", closePayload(1005), wsCloseProtocolError},
+		{"reserved 1006", closePayload(1006), wsCloseProtocolError},
+		{"reserved 1015", closePayload(1015), wsCloseProtocolError},
+		{"unassigned 999", closePayload(999), wsCloseProtocolError},
+		{"out of range", closePayload(5000), wsCloseProtocolError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := echoClose(t, clientFrame(0x80|wsOpClose, tt.payload)); got != tt.want {
+				t.Errorf("close code %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEchoProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][]byte
+		want   int
+	}{
+		{"continuation without start", [][]byte{clientFrame(0x80|wsOpContinuation, []byte("x"))}, wsCloseProtocolError},
+		{"new message inside fragments", [][]byte{clientFrame(wsOpText, []byte("a")), clientFrame(0x80|wsOpText, []byte("b"))}, wsCloseProtocolError},
+		{"unknown opcode", [][]byte{clientFrame(0x80|0x3, nil)}, wsCloseProtocolError},
+		{"rsv1 without deflate", [][]byte{clientFrame(0x80|0x40|wsOpText, []byte("x"))}, wsCloseProtocolError},
+		{"invalid utf-8", [][]byte{clientFrame(0x80|wsOpText, []byte{0xff, 0xfe})}, wsCloseInvalidData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := echoClose(t, tt.frames...); got != tt.want {
+				t.Errorf("close code %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiateDeflate(t *testing.T) {
+	tests := []struct {
+		offers []string
+		want   bool
+	}{
+		{nil, false},
+		{[]string{"x-webkit-deflate-frame"}, false},
+		{[]string{"permessage-deflate"}, true},
+		{[]string{"permessage-deflate; client_max_window_bits"}, true},
+		{[]string{"permessage-deflate; server_max_window_bits=15"}, true},
+		{[]string{`permessage-deflate; server_max_window_bits="15"`}, true},
+		{[]string{"permessage-deflate; server_max_window_bits=10"}, false},
+		{[]string{"permessage-deflate; foo"}, false},
+		{[]string{"permessage-deflate; foo; server_max_window_bits=15"}, false},
+		{[]string{"permessage-deflate; server_max_window_bits=15; foo"}, false},
+		{[]string{"permessage-deflate; server_max_window_bits=10", "permessage-deflate"}, true},
+	}
+	for _, tt := range tests {
+		if _, got := negotiateDeflate(tt.offers); got != tt.want {
+			t.Errorf("negotiateDeflate(%q) = %v, want %v", tt.offers, got, tt.want)
+		}
+	}
+}
+
+func TestDeflateRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte("reflector "), 100)
+	deflated, err := deflateMessage(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inflated, err := inflateMessage(deflated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(inflated, payload) {
+		t.Error("round trip changed the payload")
+	}
+	if _, err := inflateMessage([]byte{0xff, 0xff, 0xff}); err == nil {
+		t.Error("inflateMessage accepted garbage")
+	}
+}
+
+func FuzzReadFrame(f *testing.F) {
+	f.Add(clientFrame(0x80|wsOpText, []byte("hello")))
+	f.Add(clientFrame(0x80|wsOpBinary, bytes.Repeat([]byte{1}, 200)))
+	f.Add(clientFrame(0x80|wsOpClose, closePayload(1000)))
+	f.Add([]byte{0x81, 0xff, 0, 0, 0, 0, 0, 0, 0, 1})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frame, err := readTestFrame(data)
+		if err != nil {
+			return
+		}
+		if !frame.masked || frame.wire > len(data) || len(frame.payload) > wsMaxMessageBytes {
+			t.Fatalf("accepted inconsistent frame %+v from %d bytes", frame, len(data))
+		}
+		if frame.opcode >= wsOpClose && (len(frame.payload) > 125 || !frame.fin) {
+			t.Fatalf("accepted invalid control frame %+v", frame)
+		}
+	})
+}